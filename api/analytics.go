@@ -0,0 +1,707 @@
+package api
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klemis/user-actions-api/types"
+)
+
+// transition is a pair of consecutive actions performed by the same user.
+type transition struct {
+	from types.Action
+	to   types.Action
+}
+
+// groupedByUser returns the actions grouped by user and ordered by createdAt within
+// each user, the order the transition helpers expect. Actions already in that order,
+// as the in-memory storage returns them, are returned as is. Otherwise a sorted copy is
+// returned, with actions of a user created at the same time ordered by ID.
+func groupedByUser(actions []types.Action) []types.Action {
+	inOrder := sort.SliceIsSorted(actions, func(i, j int) bool {
+		if actions[i].UserID == actions[j].UserID {
+			return actions[i].CreatedAt.Before(actions[j].CreatedAt)
+		}
+		return actions[i].UserID < actions[j].UserID
+	})
+	if inOrder {
+		return actions
+	}
+
+	sorted := append([]types.Action(nil), actions...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+
+	return sorted
+}
+
+// nextActions returns every transition that starts with an action of the given type.
+// The actions are expected to be sorted by user and createdAt.
+func nextActions(actions []types.Action, actionType types.ActionType) []transition {
+	var transitions []transition
+	for i := 0; i < len(actions)-1; i++ {
+		if actions[i].Type == actionType && actions[i].UserID == actions[i+1].UserID {
+			transitions = append(transitions, transition{from: actions[i], to: actions[i+1]})
+		}
+	}
+
+	return transitions
+}
+
+// actionTypeSeen reports whether any action has the given type.
+func actionTypeSeen(actions []types.Action, actionType types.ActionType) bool {
+	for _, action := range actions {
+		if action.Type == actionType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transitionCounts counts, in one pass, how often each action type is directly
+// followed by each other action type of the same user.
+func transitionCounts(actions []types.Action) map[types.ActionType]map[types.ActionType]int {
+	counts := make(map[types.ActionType]map[types.ActionType]int)
+	for i := 0; i < len(actions)-1; i++ {
+		if actions[i].UserID != actions[i+1].UserID {
+			continue
+		}
+
+		from, to := actions[i].Type, actions[i+1].Type
+		if counts[from] == nil {
+			counts[from] = make(map[types.ActionType]int)
+		}
+		counts[from][to]++
+	}
+
+	return counts
+}
+
+// transitionEdges flattens transition counts into edges sorted by source and target type.
+func transitionEdges(counts map[types.ActionType]map[types.ActionType]int) []types.TransitionEdge {
+	edges := []types.TransitionEdge{}
+	for from, targets := range counts {
+		for to, count := range targets {
+			edges = append(edges, types.TransitionEdge{From: from, To: to, Count: count})
+		}
+	}
+
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From == edges[j].From {
+			return edges[i].To < edges[j].To
+		}
+		return edges[i].From < edges[j].From
+	})
+
+	return edges
+}
+
+// transitionMatrix turns transition counts into the probability of each action type
+// following each other one, rounded with the rounding mode. Transitions counted fewer
+// than minCount times are dropped before the probabilities are calculated, so each row
+// still sums to 1; rows left without transitions are omitted.
+func transitionMatrix(counts map[types.ActionType]map[types.ActionType]int, minCount int, mode string) types.TransitionMatrix {
+	matrix := make(types.TransitionMatrix, len(counts))
+	for from, targets := range counts {
+		total := 0
+		for _, count := range targets {
+			if count >= minCount {
+				total += count
+			}
+		}
+		if total == 0 {
+			continue
+		}
+
+		row := make(types.ActionsProbalibity, len(targets))
+		for to, count := range targets {
+			if count >= minCount {
+				row[to] = roundProbability(float64(count)/float64(total), mode)
+			}
+		}
+		matrix[from] = row
+	}
+
+	return matrix
+}
+
+// maxSequenceLength is the longest action sequence commonSequences counts.
+const maxSequenceLength = 5
+
+// commonSequences counts every run of length consecutive actions of the same user, and
+// returns the limit most frequent sequences of types, most frequent first and then in
+// order of their types. The actions must be grouped by user and ordered by createdAt,
+// and length must be between 1 and maxSequenceLength.
+func commonSequences(actions []types.Action, length, limit int) []types.ActionSequence {
+	counts := make(map[[maxSequenceLength]types.ActionType]int)
+	for i := 0; i+length <= len(actions); i++ {
+		// Grouped by user, the window belongs to one user if its ends do.
+		if actions[i].UserID != actions[i+length-1].UserID {
+			continue
+		}
+
+		var key [maxSequenceLength]types.ActionType
+		for j := range length {
+			key[j] = actions[i+j].Type
+		}
+		counts[key]++
+	}
+
+	sequences := make([]types.ActionSequence, 0, len(counts))
+	for key, count := range counts {
+		sequences = append(sequences, types.ActionSequence{Sequence: slices.Clone(key[:length]), Count: count})
+	}
+	slices.SortFunc(sequences, func(a, b types.ActionSequence) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return slices.CompareFunc(a.Sequence, b.Sequence, func(x, y types.ActionType) int {
+			return strings.Compare(string(x), string(y))
+		})
+	})
+	if len(sequences) > limit {
+		sequences = sequences[:limit]
+	}
+
+	return sequences
+}
+
+// funnel counts, for each step, the users who performed it after performing every step
+// before it, and the conversion between steps rounded with the rounding mode. The
+// actions must be grouped by user and ordered by createdAt.
+func funnel(actions []types.Action, steps []types.ActionType, mode string) types.Funnel {
+	reached := make([]int, len(steps))
+	step := 0
+	for i, action := range actions {
+		// Matching each step at its earliest occurrence finds the longest prefix of the
+		// funnel the user went through.
+		if step < len(steps) && action.Type == steps[step] {
+			step++
+		}
+		if i == len(actions)-1 || actions[i+1].UserID != action.UserID {
+			for j := range step {
+				reached[j]++
+			}
+			step = 0
+		}
+	}
+
+	result := types.Funnel{Steps: make([]types.FunnelStep, len(steps))}
+	for i, actionType := range steps {
+		result.Steps[i] = types.FunnelStep{Type: actionType, Users: reached[i]}
+		if i > 0 {
+			result.Steps[i].Conversion = conversionRate(reached[i], reached[i-1], mode)
+		}
+	}
+	result.Conversion = conversionRate(reached[len(steps)-1], reached[0], mode)
+
+	return result
+}
+
+// conversionRate returns the share of converted among total rounded with the rounding
+// mode, or nil when total is 0.
+func conversionRate(converted, total int, mode string) *float64 {
+	if total == 0 {
+		return nil
+	}
+	rate := roundProbability(float64(converted)/float64(total), mode)
+
+	return &rate
+}
+
+// transitionEntropy calculates, for every action type in the data, the Shannon entropy
+// of its next-action distribution from the transition counts, sorted by type. Types
+// that are never followed by another action are marked terminal.
+func transitionEntropy(actions []types.Action) []types.ActionTypeEntropy {
+	counts := transitionCounts(actions)
+
+	seen := make(map[types.ActionType]bool)
+	for _, action := range actions {
+		seen[action.Type] = true
+	}
+
+	entropies := make([]types.ActionTypeEntropy, 0, len(seen))
+	for actionType := range seen {
+		entry := types.ActionTypeEntropy{Type: actionType, Terminal: len(counts[actionType]) == 0}
+		for _, count := range counts[actionType] {
+			entry.Samples += count
+		}
+		for _, count := range counts[actionType] {
+			p := float64(count) / float64(entry.Samples)
+			entry.Entropy -= p * math.Log2(p)
+		}
+		entropies = append(entropies, entry)
+	}
+
+	sort.Slice(entropies, func(i, j int) bool { return entropies[i].Type < entropies[j].Type })
+
+	return entropies
+}
+
+// nextActionProbability calculates the probability of each action type following the
+// given action type. The probabilities are not rounded.
+func nextActionProbability(actions []types.Action, actionType types.ActionType) types.ActionsProbalibity {
+	actionCounts := make(map[types.ActionType]int)
+	totalNextActions := 0
+
+	// Count next actions after each specified action type.
+	for _, t := range nextActions(actions, actionType) {
+		actionCounts[t.to.Type]++
+		totalNextActions++
+	}
+
+	// Calculate probabilities.
+	result := make(types.ActionsProbalibity)
+	for action, count := range actionCounts {
+		result[action] = float64(count) / float64(totalNextActions)
+	}
+
+	return result
+}
+
+// conditionalNextActionProbability calculates the probability of each action type
+// following an action of type current that was itself directly preceded by an action
+// of type previous, all by the same user. The probabilities are not rounded.
+func conditionalNextActionProbability(actions []types.Action, previous, current types.ActionType) types.ActionsProbalibity {
+	actionCounts := make(map[types.ActionType]int)
+	total := 0
+	for i := 1; i < len(actions)-1; i++ {
+		if actions[i-1].Type != previous || actions[i].Type != current {
+			continue
+		}
+		if actions[i-1].UserID != actions[i].UserID || actions[i].UserID != actions[i+1].UserID {
+			continue
+		}
+
+		actionCounts[actions[i+1].Type]++
+		total++
+	}
+
+	result := make(types.ActionsProbalibity)
+	for action, count := range actionCounts {
+		result[action] = float64(count) / float64(total)
+	}
+
+	return result
+}
+
+// explainTransitions groups the transitions by the type of the next action, keeping the
+// IDs of the actions involved in the order they appear in the data.
+func explainTransitions(transitions []transition) map[types.ActionType]types.NextActionExplanation {
+	explanations := make(map[types.ActionType]types.NextActionExplanation)
+	for _, t := range transitions {
+		explanation := explanations[t.to.Type]
+		explanation.Count++
+		explanation.Pairs = append(explanation.Pairs, types.TransitionPair{SourceActionID: t.from.ID, NextActionID: t.to.ID})
+		explanations[t.to.Type] = explanation
+	}
+
+	return explanations
+}
+
+// Rounding modes for probabilities. At two decimal places 0.125 rounds to 0.13 with
+// RoundHalfUp but to 0.12 with RoundHalfEven, which rounds ties to the even digit so
+// aggregates of many rounded values are not biased upward.
+const (
+	RoundHalfUp   = "half-up"
+	RoundHalfEven = "half-even"
+)
+
+// roundProbability rounds a probability to two decimal places using the rounding mode.
+func roundProbability(probability float64, mode string) float64 {
+	if mode == RoundHalfEven {
+		return math.RoundToEven(probability*100) / 100
+	}
+	return math.Round(probability*100) / 100
+}
+
+// roundProbabilities returns a copy of the distribution rounded to two decimal places.
+func roundProbabilities(probabilities types.ActionsProbalibity, mode string) types.ActionsProbalibity {
+	result := make(types.ActionsProbalibity, len(probabilities))
+	for action, probability := range probabilities {
+		result[action] = roundProbability(probability, mode)
+	}
+
+	return result
+}
+
+// sortedProbabilities returns the distribution as a list sorted by probability
+// descending, with ties broken by type name so the order is deterministic.
+func sortedProbabilities(probabilities types.ActionsProbalibity) []types.ActionProbability {
+	sorted := make([]types.ActionProbability, 0, len(probabilities))
+	for action, probability := range probabilities {
+		sorted = append(sorted, types.ActionProbability{Type: action, Probability: probability})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Probability == sorted[j].Probability {
+			return sorted[i].Type < sorted[j].Type
+		}
+		return sorted[i].Probability > sorted[j].Probability
+	})
+
+	return sorted
+}
+
+// detailedProbabilities counts the transitions by the type of the next action and
+// returns each type with its count and probability, raw and rounded using the rounding
+// mode. The list is sorted by probability descending, with ties broken by type name so
+// the order is deterministic.
+func detailedProbabilities(transitions []transition, mode string) []types.DetailedActionProbability {
+	counts := make(map[types.ActionType]int)
+	for _, t := range transitions {
+		counts[t.to.Type]++
+	}
+
+	detailed := make([]types.DetailedActionProbability, 0, len(counts))
+	for action, count := range counts {
+		probability := float64(count) / float64(len(transitions))
+		detailed = append(detailed, types.DetailedActionProbability{
+			Type:           action,
+			Count:          count,
+			Probability:    roundProbability(probability, mode),
+			RawProbability: probability,
+		})
+	}
+
+	sort.Slice(detailed, func(i, j int) bool {
+		if detailed[i].Count == detailed[j].Count {
+			return detailed[i].Type < detailed[j].Type
+		}
+		return detailed[i].Count > detailed[j].Count
+	})
+
+	return detailed
+}
+
+// totalVariationDistance returns half the sum of absolute differences between two
+// distributions: 0 when they are identical and 1 when they share no outcomes.
+func totalVariationDistance(a, b types.ActionsProbalibity) float64 {
+	sum := 0.0
+	for action, probability := range a {
+		sum += math.Abs(probability - b[action])
+	}
+	for action, probability := range b {
+		if _, exists := a[action]; !exists {
+			sum += probability
+		}
+	}
+
+	return sum / 2
+}
+
+// expectedNextAction combines the next-action probabilities with the mean time to
+// each next action. The result is the sum of probability * meanSeconds over all
+// next action types, and ExpectedSeconds is nil when there are no transitions.
+func expectedNextAction(transitions []transition) types.ExpectedNextAction {
+	result := types.ExpectedNextAction{
+		Samples:     len(transitions),
+		Transitions: make(map[types.ActionType]types.NextActionTiming),
+	}
+	if len(transitions) == 0 {
+		return result
+	}
+
+	totalSeconds := make(map[types.ActionType]float64)
+	for _, t := range transitions {
+		timing := result.Transitions[t.to.Type]
+		timing.Count++
+		result.Transitions[t.to.Type] = timing
+		totalSeconds[t.to.Type] += t.to.CreatedAt.Sub(t.from.CreatedAt).Seconds()
+	}
+
+	expected := 0.0
+	for actionType, timing := range result.Transitions {
+		timing.Probability = float64(timing.Count) / float64(len(transitions))
+		timing.MeanSeconds = totalSeconds[actionType] / float64(timing.Count)
+		result.Transitions[actionType] = timing
+
+		expected += timing.Probability * timing.MeanSeconds
+	}
+	result.ExpectedSeconds = &expected
+
+	return result
+}
+
+// gapStats computes the distribution of the time between the actions of each
+// transition.
+func gapStats(transitions []transition) types.GapStats {
+	result := types.GapStats{Samples: len(transitions)}
+	if len(transitions) == 0 {
+		return result
+	}
+
+	gaps := make([]float64, len(transitions))
+	total := 0.0
+	for i, t := range transitions {
+		gaps[i] = t.to.CreatedAt.Sub(t.from.CreatedAt).Seconds()
+		total += gaps[i]
+	}
+	sort.Float64s(gaps)
+
+	minimum, maximum := gaps[0], gaps[len(gaps)-1]
+	mean := total / float64(len(gaps))
+	median, p90 := percentile(gaps, 0.5), percentile(gaps, 0.9)
+	result.MinSeconds = &minimum
+	result.MaxSeconds = &maximum
+	result.MeanSeconds = &mean
+	result.MedianSeconds = &median
+	result.P90Seconds = &p90
+
+	return result
+}
+
+// consecutiveActions returns the transitions between consecutive actions of a single
+// user, ordered by createdAt.
+func consecutiveActions(actions []types.Action) []transition {
+	var transitions []transition
+	for i := 0; i < len(actions)-1; i++ {
+		transitions = append(transitions, transition{from: actions[i], to: actions[i+1]})
+	}
+
+	return transitions
+}
+
+// formatGapStats formats the statistics for display, leaving absent ones nil.
+func formatGapStats(stats types.GapStats) types.FormattedGapStats {
+	format := func(seconds *float64) *string {
+		if seconds == nil {
+			return nil
+		}
+		formatted := humanizeDuration(*seconds)
+		return &formatted
+	}
+
+	return types.FormattedGapStats{
+		Min:    format(stats.MinSeconds),
+		Max:    format(stats.MaxSeconds),
+		Mean:   format(stats.MeanSeconds),
+		Median: format(stats.MedianSeconds),
+	}
+}
+
+// percentile returns the p-th quantile (0 <= p <= 1) of the sorted, non-empty values,
+// interpolating linearly between the two closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	rank := p * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// sampleActions picks n actions uniformly at random using reservoir sampling, so
+// only the n-sized result is allocated. All actions are returned if n exceeds the total.
+func sampleActions(actions []types.Action, n int, rng *rand.Rand) []types.Action {
+	if n >= len(actions) {
+		return actions
+	}
+
+	sample := make([]types.Action, n)
+	copy(sample, actions[:n])
+	for i := n; i < len(actions); i++ {
+		if j := rng.Intn(i + 1); j < n {
+			sample[j] = actions[i]
+		}
+	}
+
+	return sample
+}
+
+// newerThan reports whether a was created after b, using the ID to break ties.
+func newerThan(a, b types.Action) bool {
+	if a.CreatedAt.Equal(b.CreatedAt) {
+		return a.ID > b.ID
+	}
+	return a.CreatedAt.After(b.CreatedAt)
+}
+
+// actionHeap is a min-heap of actions ordered by creation time, oldest on top.
+type actionHeap []types.Action
+
+func (h actionHeap) Len() int           { return len(h) }
+func (h actionHeap) Less(i, j int) bool { return newerThan(h[j], h[i]) }
+func (h actionHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *actionHeap) Push(x any)        { *h = append(*h, x.(types.Action)) }
+func (h *actionHeap) Pop() any {
+	old := *h
+	action := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return action
+}
+
+// recentActions returns the n most recent actions, newest first. Since actions are
+// ordered by user rather than time, it keeps the newest n seen so far in a heap
+// instead of sorting everything.
+func recentActions(actions []types.Action, n int) []types.Action {
+	h := make(actionHeap, 0, min(n, len(actions)))
+	for _, action := range actions {
+		if h.Len() < n {
+			heap.Push(&h, action)
+		} else if newerThan(action, h[0]) {
+			h[0] = action
+			heap.Fix(&h, 0)
+		}
+	}
+
+	recent := make([]types.Action, h.Len())
+	for i := len(recent) - 1; i >= 0; i-- {
+		recent[i] = heap.Pop(&h).(types.Action)
+	}
+
+	return recent
+}
+
+// bucketStart returns the start of the time bucket containing t, in UTC. Weeks start
+// on Monday. It reports false for an unknown granularity.
+func bucketStart(t time.Time, granularity string) (time.Time, bool) {
+	t = t.UTC()
+	switch granularity {
+	case "hour":
+		return t.Truncate(time.Hour), true
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), true
+	case "week":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7), true
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), true
+	}
+
+	return time.Time{}, false
+}
+
+// typeShares computes, per time bucket, the fraction of the bucket's actions each
+// action type accounts for. Every bucket lists every type seen in the data, with a
+// zero share where the type is absent, so the series align. Buckets are sorted by time.
+func typeShares(actions []types.Action, granularity string) []types.TypeShareBucket {
+	counts := make(map[time.Time]map[types.ActionType]int)
+	allTypes := make(map[types.ActionType]bool)
+	for _, action := range actions {
+		start, _ := bucketStart(action.CreatedAt, granularity)
+		if counts[start] == nil {
+			counts[start] = make(map[types.ActionType]int)
+		}
+		counts[start][action.Type]++
+		allTypes[action.Type] = true
+	}
+
+	buckets := make([]types.TypeShareBucket, 0, len(counts))
+	for start, typeCounts := range counts {
+		bucket := types.TypeShareBucket{Start: start, Shares: make(map[types.ActionType]float64, len(allTypes))}
+		for _, count := range typeCounts {
+			bucket.Total += count
+		}
+		for actionType := range allTypes {
+			bucket.Shares[actionType] = float64(typeCounts[actionType]) / float64(bucket.Total)
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+
+	return buckets
+}
+
+// userTimeline converts a user's actions, ordered by createdAt, into their timeline.
+func userTimeline(userID int, actions []types.Action) types.UserTimeline {
+	events := make([]types.TimelineEvent, 0, len(actions))
+	for _, action := range actions {
+		events = append(events, types.TimelineEvent{Type: action.Type, CreatedAt: action.CreatedAt})
+	}
+
+	return types.UserTimeline{UserID: userID, Events: events}
+}
+
+// topActionTypes returns the n most frequent action types, most frequent first and
+// ties broken by type.
+func topActionTypes(actions []types.Action, n int) []types.ActionTypeCount {
+	counts := make(map[types.ActionType]int)
+	for _, action := range actions {
+		counts[action.Type]++
+	}
+
+	top := make([]types.ActionTypeCount, 0, len(counts))
+	for actionType, count := range counts {
+		top = append(top, types.ActionTypeCount{Type: actionType, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count == top[j].Count {
+			return top[i].Type < top[j].Type
+		}
+		return top[i].Count > top[j].Count
+	})
+
+	return top[:min(n, len(top))]
+}
+
+// firstActions returns the first action of each user. The actions are expected to be
+// sorted by user and createdAt.
+func firstActions(actions []types.Action) []types.Action {
+	var first []types.Action
+	for i, action := range actions {
+		if i == 0 || actions[i-1].UserID != action.UserID {
+			first = append(first, action)
+		}
+	}
+
+	return first
+}
+
+// sortUsersBySignup sorts users by creation time, oldest first or, with newestFirst,
+// newest first. Users created at the same time are ordered by ID in both cases.
+func sortUsersBySignup(users []types.User, newestFirst bool) {
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].ID < users[j].ID
+		}
+		if newestFirst {
+			return users[i].CreatedAt.After(users[j].CreatedAt)
+		}
+		return users[i].CreatedAt.Before(users[j].CreatedAt)
+	})
+}
+
+// newestActionTime returns the creation time of the newest action, or the zero time
+// when there are no actions.
+func newestActionTime(actions []types.Action) time.Time {
+	var newest time.Time
+	for _, action := range actions {
+		if action.CreatedAt.After(newest) {
+			newest = action.CreatedAt
+		}
+	}
+
+	return newest
+}
+
+// countWithin counts the actions created in the window of the given length ending at
+// end, excluding its start and including its end.
+func countWithin(actions []types.Action, end time.Time, window time.Duration) int {
+	start := end.Add(-window)
+
+	count := 0
+	for _, action := range actions {
+		if action.CreatedAt.After(start) && !action.CreatedAt.After(end) {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTotalVariationDistance(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        types.ActionsProbalibity
+		b        types.ActionsProbalibity
+		expected float64
+	}{
+		{
+			name:     "Identical",
+			a:        types.ActionsProbalibity{"ADD_CONTACT": 0.5, "EDIT_CONTACT": 0.5},
+			b:        types.ActionsProbalibity{"ADD_CONTACT": 0.5, "EDIT_CONTACT": 0.5},
+			expected: 0,
+		},
+		{
+			name:     "Disjoint",
+			a:        types.ActionsProbalibity{"ADD_CONTACT": 1},
+			b:        types.ActionsProbalibity{"EDIT_CONTACT": 1},
+			expected: 1,
+		},
+		{
+			name:     "Partial overlap",
+			a:        types.ActionsProbalibity{"ADD_CONTACT": 0.75, "EDIT_CONTACT": 0.25},
+			b:        types.ActionsProbalibity{"ADD_CONTACT": 0.25, "VIEW_CONTACTS": 0.75},
+			expected: 0.75,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			assert.InDelta(t, tt.expected, totalVariationDistance(tt.a, tt.b), 1e-9)
+			assert.InDelta(t, tt.expected, totalVariationDistance(tt.b, tt.a), 1e-9)
+		})
+	}
+}
+
+func TestRoundProbability(t *testing.T) {
+	tests := []struct {
+		name        string
+		probability float64
+		halfUp      float64
+		halfEven    float64
+	}{
+		{"Tie rounding to odd", 0.125, 0.13, 0.12},
+		{"Tie rounding to even", 0.375, 0.38, 0.38},
+		{"Tie below one", 0.625, 0.63, 0.62},
+		{"Tie at half", 0.005, 0.01, 0},
+		{"Above tie", 0.126, 0.13, 0.13},
+		{"Below tie", 0.124, 0.12, 0.12},
+		{"Exact", 0.5, 0.5, 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			assert.Equal(t, tt.halfUp, roundProbability(tt.probability, RoundHalfUp))
+			assert.Equal(t, tt.halfEven, roundProbability(tt.probability, RoundHalfEven))
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []float64
+		p        float64
+		expected float64
+	}{
+		{"Median of odd count", []float64{10, 20, 30, 40, 50}, 0.5, 30},
+		{"Median of even count", []float64{10, 20, 30, 40}, 0.5, 25},
+		{"P90 interpolated", []float64{10, 20, 30, 40, 50}, 0.9, 46},
+		{"P90 of ten values", []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 0.9, 9.1},
+		{"Minimum", []float64{10, 20, 30}, 0, 10},
+		{"Maximum", []float64{10, 20, 30}, 1, 30},
+		{"Single value", []float64{42}, 0.9, 42},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			assert.InDelta(t, tt.expected, percentile(tt.values, tt.p), 1e-9)
+		})
+	}
+}
+
+func TestTypeSharesSumToOne(t *testing.T) {
+	start, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	actionTypes := types.KnownActionTypes
+	var actions []types.Action
+	for i := 0; i < 500; i++ {
+		actions = append(actions, types.Action{
+			ID:        i,
+			UserID:    i % 7,
+			Type:      actionTypes[(i/3)%len(actionTypes)],
+			CreatedAt: start.Add(time.Duration(i*37) * time.Minute),
+		})
+	}
+
+	for _, granularity := range []string{"hour", "day", "week", "month"} {
+		t.Run(granularity, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			buckets := typeShares(actions, granularity)
+			assert.NotEmpty(t, buckets)
+
+			for _, bucket := range buckets {
+				sum := 0.0
+				for _, share := range bucket.Shares {
+					sum += share
+				}
+				assert.InDelta(t, 1.0, sum, 1e-9)
+				assert.Len(t, bucket.Shares, len(actionTypes))
+			}
+		})
+	}
+}
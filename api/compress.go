@@ -0,0 +1,135 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compress gzip-compresses the responses of clients sending "Accept-Encoding: gzip".
+// Responses are buffered until they reach minSize bytes, so small ones are sent as
+// they are. Streamed responses are compressed from their first flush on. Responses
+// that already have a Content-Encoding, such as those of /metrics, are left alone.
+func compress(minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		writer := &gzipWriter{ResponseWriter: c.Writer, minSize: minSize}
+		c.Writer = writer
+		c.Next()
+		writer.close()
+		c.Writer = writer.ResponseWriter
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header value accepts gzip.
+func acceptsGzip(header string) bool {
+	for _, coding := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+
+		// A quality of 0 explicitly refuses the coding.
+		value, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !found {
+			return true
+		}
+		q, err := strconv.ParseFloat(value, 64)
+		return err != nil || q > 0
+	}
+
+	return false
+}
+
+// gzipWriter buffers a response until it is large enough to compress, and then
+// compresses it on the way to the underlying writer.
+type gzipWriter struct {
+	gin.ResponseWriter
+	minSize int
+	buffer  bytes.Buffer
+	// gz is the compressing writer, nil until compression starts.
+	gz *gzip.Writer
+	// passthrough sends the response unchanged, as it was already encoded.
+	passthrough bool
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	switch {
+	case w.gz != nil:
+		return w.gz.Write(data)
+	case w.passthrough:
+		return w.ResponseWriter.Write(data)
+	}
+
+	w.buffer.Write(data)
+	if w.buffer.Len() >= w.minSize {
+		if err := w.start(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(data), nil
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what was written so far. The size of a streamed response is unknown,
+// so it is compressed whatever it has reached.
+func (w *gzipWriter) Flush() {
+	if w.gz == nil && !w.passthrough {
+		if err := w.start(); err != nil {
+			return
+		}
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// start writes out the buffered response, compressing it unless it already has a
+// Content-Encoding.
+func (w *gzipWriter) start() error {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		w.passthrough = true
+	} else {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		// The compressed bytes differ, so the ETag only still identifies the content.
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+
+	data := w.buffer.Bytes()
+	w.buffer.Reset()
+	if w.gz != nil {
+		_, err := w.gz.Write(data)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(data)
+	return err
+}
+
+// close finishes the response, writing it out uncompressed if it stayed below the
+// minimum size.
+func (w *gzipWriter) close() {
+	switch {
+	case w.gz != nil:
+		w.gz.Close()
+	case w.buffer.Len() > 0:
+		w.ResponseWriter.Write(w.buffer.Bytes())
+	}
+}
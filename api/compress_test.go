@@ -0,0 +1,136 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// decompress returns the body of a response, gunzipped if it is compressed.
+func decompress(t *testing.T, response *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	if response.Header().Get("Content-Encoding") != "gzip" {
+		return response.Body.String()
+	}
+
+	reader, err := gzip.NewReader(response.Body)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+
+	return string(body)
+}
+
+func TestCompress(t *testing.T) {
+	large := `{"actions": [` + strings.Repeat(`{"id": 1, "type": "WELCOME", "userId": 1}, `, 50) + `{}]}`
+	small := `{"count": 5}`
+
+	tests := []struct {
+		name             string
+		path             string
+		acceptEncoding   string
+		expectedEncoding string
+		expectedBody     string
+	}{
+		{name: "Large response", path: "/large", acceptEncoding: "gzip", expectedEncoding: "gzip", expectedBody: large},
+		{name: "Large response among other codings", path: "/large", acceptEncoding: "br;q=1.0, gzip;q=0.8", expectedEncoding: "gzip", expectedBody: large},
+		{name: "Large response without Accept-Encoding", path: "/large", expectedBody: large},
+		{name: "Large response with gzip refused", path: "/large", acceptEncoding: "gzip;q=0", expectedBody: large},
+		{name: "Small response", path: "/small", acceptEncoding: "gzip", expectedBody: small},
+		{name: "Already encoded response", path: "/encoded", acceptEncoding: "gzip", expectedEncoding: "identity", expectedBody: large},
+		{name: "Streamed response", path: "/stream", acceptEncoding: "gzip", expectedEncoding: "gzip", expectedBody: small + "\n" + small + "\n"},
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(compress(1024))
+	router.GET("/large", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", []byte(large))
+	})
+	router.GET("/small", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", []byte(small))
+	})
+	router.GET("/encoded", func(c *gin.Context) {
+		c.Header("Content-Encoding", "identity")
+		c.Data(http.StatusOK, "application/json", []byte(large))
+	})
+	router.GET("/stream", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		for range 2 {
+			c.Writer.WriteString(small + "\n")
+			c.Writer.Flush()
+		}
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, http.StatusOK, response.Code)
+			assert.Equal(t, tt.expectedEncoding, response.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", response.Header().Get("Vary"))
+			assert.Equal(t, tt.expectedBody, decompress(t, response))
+		})
+	}
+}
+
+// TestCompressServer checks compression through the server's middleware, on JSON
+// responses with an ETag and on /metrics, which compresses its own responses.
+func TestCompressServer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockStore := new(MockStorage)
+	server := NewServer("", mockStore, Config{CompressMinSize: 1})
+
+	t.Run("ETag", func(t *testing.T) {
+		mockStore.On("GetUser", 1).Return(&types.User{ID: 1, Name: "Tom", CreatedAt: time.Date(2021, time.July, 4, 12, 47, 9, 888000000, time.UTC)})
+
+		req, _ := http.NewRequest("GET", "/users/1", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		response := httptest.NewRecorder()
+		server.router.ServeHTTP(response, req)
+
+		assert.Equal(t, "gzip", response.Header().Get("Content-Encoding"))
+		assert.JSONEq(t, `{"id": 1, "name": "Tom", "createdAt": "2021-07-04T12:47:09.888Z"}`, decompress(t, response))
+
+		// The compressed response carries a weak ETag, which conditional requests accept.
+		etag := response.Header().Get("ETag")
+		assert.True(t, strings.HasPrefix(etag, `W/"`))
+
+		req, _ = http.NewRequest("GET", "/users/1", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("If-None-Match", etag)
+		response = httptest.NewRecorder()
+		server.router.ServeHTTP(response, req)
+
+		assert.Equal(t, http.StatusNotModified, response.Code)
+	})
+
+	t.Run("Metrics", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		response := httptest.NewRecorder()
+		server.router.ServeHTTP(response, req)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "gzip", response.Header().Get("Content-Encoding"))
+		// Decompressing once yields the plain exposition format.
+		assert.Contains(t, decompress(t, response), "# HELP")
+	})
+}
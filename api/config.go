@@ -0,0 +1,136 @@
+package api
+
+import (
+	"log"
+	"time"
+
+	"github.com/klemis/user-actions-api/types"
+)
+
+// Config holds the tunable behaviour of the API server.
+type Config struct {
+	// EnvelopeResponses wraps responses in a {"data", "meta"} envelope by default.
+	// Clients can override it per request with ?envelope=true|false.
+	EnvelopeResponses bool
+
+	// MaxReferralVisits caps the number of users visited while computing the
+	// referral index. Requests exceeding it get a 503. Zero means no limit.
+	MaxReferralVisits int
+
+	// MaxConcurrentRequests caps the number of requests served at once. Requests
+	// beyond it get a 503 with Retry-After. Zero means no limit.
+	MaxConcurrentRequests int
+
+	// StrictActionTypes rejects action types in requests that are not made of
+	// upper-case letters and underscores, e.g. "WELCOME".
+	StrictActionTypes bool
+
+	// AllowedActionTypes restricts the action types the probability and transition
+	// endpoints accept when StrictActionTypes is set. Empty means unrestricted.
+	AllowedActionTypes []types.ActionType
+
+	// AllowUnknownActionTypes lets POST /actions create actions of types outside
+	// types.KnownActionTypes. By default they are rejected, so typos do not end up in
+	// the statistics. Loaded data is never rejected for its types.
+	AllowUnknownActionTypes bool
+
+	// ZeroTargetUserValid counts referrals to user 0, for data whose user IDs start at 0.
+	// By default a target of 0 is treated as absent, as older data used it for no target.
+	ZeroTargetUserValid bool
+
+	// EmptyReferralIndexAs200 makes the referral index return 200 with an empty object,
+	// rather than 404, when there are no actions or referrals. Clients can override it
+	// per request with ?emptyAs200=true|false.
+	EmptyReferralIndexAs200 bool
+
+	// GroupConcurrencyLimits caps the number of requests served at once per endpoint
+	// group (GroupAnalytics, GroupExport), independently of MaxConcurrentRequests.
+	// Groups without a positive limit are not throttled.
+	GroupConcurrencyLimits map[string]int
+
+	// LogSampleRate is the fraction of requests logged, e.g. 0.1 for 10%. Failed
+	// requests and requests slower than SlowRequestThreshold are always logged.
+	// Zero means every request is logged.
+	LogSampleRate float64
+
+	// SlowRequestThreshold is the duration from which a request is always logged.
+	// Zero disables it.
+	SlowRequestThreshold time.Duration
+
+	// JSONLogs writes each request log line as a JSON object, for log aggregators,
+	// instead of gin's text format.
+	JSONLogs bool
+
+	// RoundingMode is how probabilities are rounded, RoundHalfUp or RoundHalfEven.
+	// Clients can override it per request with ?roundingMode=. Empty means RoundHalfUp.
+	RoundingMode string
+
+	// RateLimit is the number of requests per second each client may make, answered
+	// with a 429 beyond it. Clients are told apart by IP. Zero means no limit.
+	RateLimit float64
+
+	// RateBurst is the number of requests a client may make at once before RateLimit
+	// applies.
+	RateBurst int
+
+	// CompressMinSize is the size in bytes from which responses are gzip-compressed for
+	// clients accepting it. Zero disables compression.
+	CompressMinSize int
+
+	// CORSOrigins are the browser origins allowed to call the API, e.g.
+	// "https://dashboard.example.com", or "*" for any origin. Empty disables CORS.
+	CORSOrigins []string
+
+	// APIKey is required in an "Authorization: Bearer" or X-API-Key header on every
+	// route except the health probes. Empty disables authentication.
+	APIKey string
+}
+
+// config returns the current config. Settings that Reload can change must be read
+// through it rather than from s.cfg, which only holds the config at startup.
+func (s *Server) config() *Config {
+	if cfg := s.reloaded.Load(); cfg != nil {
+		return cfg
+	}
+
+	return &s.cfg
+}
+
+// Reload applies the settings of cfg that can change while the server runs:
+// EnvelopeResponses, MaxReferralVisits, EmptyReferralIndexAs200, LogSampleRate,
+// SlowRequestThreshold, RoundingMode, RateLimit and RateBurst. The new config is swapped
+// in as a whole, and each changed setting is logged. The other settings, such as the concurrency limits,
+// are fixed at startup and ignored.
+func (s *Server) Reload(cfg Config) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	current := s.config()
+	next := *current
+	next.EnvelopeResponses = cfg.EnvelopeResponses
+	next.MaxReferralVisits = cfg.MaxReferralVisits
+	next.EmptyReferralIndexAs200 = cfg.EmptyReferralIndexAs200
+	next.LogSampleRate = cfg.LogSampleRate
+	next.SlowRequestThreshold = cfg.SlowRequestThreshold
+	next.RoundingMode = cfg.RoundingMode
+	next.RateLimit = cfg.RateLimit
+	next.RateBurst = cfg.RateBurst
+
+	logChange("EnvelopeResponses", current.EnvelopeResponses, next.EnvelopeResponses)
+	logChange("MaxReferralVisits", current.MaxReferralVisits, next.MaxReferralVisits)
+	logChange("EmptyReferralIndexAs200", current.EmptyReferralIndexAs200, next.EmptyReferralIndexAs200)
+	logChange("LogSampleRate", current.LogSampleRate, next.LogSampleRate)
+	logChange("SlowRequestThreshold", current.SlowRequestThreshold, next.SlowRequestThreshold)
+	logChange("RoundingMode", current.RoundingMode, next.RoundingMode)
+	logChange("RateLimit", current.RateLimit, next.RateLimit)
+	logChange("RateBurst", current.RateBurst, next.RateBurst)
+
+	s.reloaded.Store(&next)
+}
+
+// logChange logs a reloaded setting if its value changed.
+func logChange(name string, old, new any) {
+	if old != new {
+		log.Printf("Reloaded %s: %v -> %v", name, old, new)
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReload tests that reloading changes the request logging while the server runs,
+// and leaves the settings fixed at startup alone.
+func TestReload(t *testing.T) {
+	server := &Server{cfg: Config{MaxConcurrentRequests: 2, RoundingMode: RoundHalfUp}}
+	var requestLogs bytes.Buffer
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// A fixed request ID outside the tiny sample keeps the test deterministic.
+	router.Use(requestStart(), func(c *gin.Context) {
+		c.Set(requestIDKey, "not-sampled")
+		c.Next()
+	}, server.logRequests(&requestLogs))
+	router.GET("/ok", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	serve := func() {
+		req, _ := http.NewRequest("GET", "/ok", nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Every request is logged before the reload.
+	serve()
+	assert.Contains(t, requestLogs.String(), "/ok")
+
+	var reloadLogs bytes.Buffer
+	log.SetOutput(&reloadLogs)
+	defer log.SetOutput(os.Stderr)
+
+	server.Reload(Config{
+		LogSampleRate:        0.000001,
+		SlowRequestThreshold: time.Hour,
+		RoundingMode:         RoundHalfUp,
+		// Not reloadable.
+		MaxConcurrentRequests: 5,
+		ZeroTargetUserValid:   true,
+	})
+
+	// Successful requests are now sampled out.
+	requestLogs.Reset()
+	serve()
+	assert.Empty(t, requestLogs.String())
+
+	cfg := server.config()
+	assert.Equal(t, 0.000001, cfg.LogSampleRate)
+	assert.Equal(t, time.Hour, cfg.SlowRequestThreshold)
+	assert.Equal(t, 2, cfg.MaxConcurrentRequests)
+	assert.False(t, cfg.ZeroTargetUserValid)
+
+	// Only the changed settings are logged.
+	lines := strings.Split(strings.TrimSpace(reloadLogs.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, reloadLogs.String(), "Reloaded LogSampleRate: 0 -> 1e-06")
+	assert.Contains(t, reloadLogs.String(), "Reloaded SlowRequestThreshold: 0s -> 1h0m0s")
+
+	// The startup config is untouched.
+	assert.Equal(t, float64(0), server.cfg.LogSampleRate)
+}
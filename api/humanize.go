@@ -0,0 +1,80 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+)
+
+// countUnits are the abbreviations humanizeCount uses, largest first.
+var countUnits = []struct {
+	size   int
+	suffix string
+}{
+	{1_000_000_000, "B"},
+	{1_000_000, "M"},
+	{1_000, "k"},
+}
+
+// humanizeCount formats a count for display, abbreviating thousands, millions and
+// billions to one decimal, e.g. 1500 as "1.5k". The decimal is truncated rather than
+// rounded, so 999999 is "999.9k" instead of jumping to "1000k", and a zero decimal is
+// dropped.
+func humanizeCount(n int) string {
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+
+	for _, unit := range countUnits {
+		if n < unit.size {
+			continue
+		}
+
+		tenths := n / (unit.size / 10)
+		formatted := strconv.Itoa(tenths / 10)
+		if tenths%10 != 0 {
+			formatted += "." + strconv.Itoa(tenths%10)
+		}
+		return sign + formatted + unit.suffix
+	}
+
+	return sign + strconv.Itoa(n)
+}
+
+// durationUnits are the units humanizeDuration uses, largest first, in seconds.
+var durationUnits = []struct {
+	seconds int
+	suffix  string
+}{
+	{24 * 60 * 60, "d"},
+	{60 * 60, "h"},
+	{60, "m"},
+	{1, "s"},
+}
+
+// humanizeDuration formats a duration in seconds for display in its two largest
+// units, e.g. 5400 as "1h 30m". Smaller units and fractions of a second are truncated,
+// so durations under a second are "0s".
+func humanizeDuration(seconds float64) string {
+	remaining := int(seconds)
+	if remaining <= 0 {
+		return "0s"
+	}
+
+	var parts []string
+	for _, unit := range durationUnits {
+		if len(parts) == 2 {
+			break
+		}
+		if n := remaining / unit.seconds; n > 0 {
+			parts = append(parts, strconv.Itoa(n)+unit.suffix)
+			remaining -= n * unit.seconds
+		} else if len(parts) > 0 {
+			// Stop at the first empty unit below the largest, so 1h 0m 5s is "1h".
+			break
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
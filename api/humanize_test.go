@@ -0,0 +1,59 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHumanizeCount(t *testing.T) {
+	tests := []struct {
+		n        int
+		expected string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1k"},
+		{1050, "1k"},
+		{1500, "1.5k"},
+		{999_999, "999.9k"},
+		{1_000_000, "1M"},
+		{1_250_000, "1.2M"},
+		{2_000_000_000, "2B"},
+		{-1500, "-1.5k"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			assert.Equal(t, tt.expected, humanizeCount(tt.n))
+		})
+	}
+}
+
+func TestHumanizeDuration(t *testing.T) {
+	tests := []struct {
+		seconds  float64
+		expected string
+	}{
+		{0, "0s"},
+		{0.4, "0s"},
+		{59.9, "59s"},
+		{90, "1m 30s"},
+		{3600, "1h"},
+		{3605, "1h"},
+		{5400, "1h 30m"},
+		{5430, "1h 30m"},
+		{90_061, "1d 1h"},
+		{14 * 24 * 3600, "14d"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			assert.Equal(t, tt.expected, humanizeDuration(tt.seconds))
+		})
+	}
+}
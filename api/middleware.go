@@ -0,0 +1,259 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klemis/user-actions-api/metrics"
+	"github.com/klemis/user-actions-api/types"
+)
+
+// requestIDKey is the context key holding the ID generated for a request.
+const requestIDKey = "requestID"
+
+// concurrencyExemptPaths are health and monitoring endpoints, which stay reachable
+// while the server is saturated or a client is rate limited.
+var concurrencyExemptPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// authExemptPaths are the health probes, which load balancers call without
+// credentials.
+var authExemptPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// Endpoint groups that can be given their own concurrency limit in Config.GroupConcurrencyLimits.
+const (
+	// GroupAnalytics holds the endpoints computing statistics over all actions.
+	GroupAnalytics = "analytics"
+	// GroupExport holds the bulk export endpoints.
+	GroupExport = "export"
+)
+
+// limitConcurrency caps the number of requests served at once. Requests arriving
+// while the limit is reached are rejected with a 503 instead of queueing.
+func (s *Server) limitConcurrency(limit int) gin.HandlerFunc {
+	acquire := s.semaphore(limit, "Too many concurrent requests")
+
+	return func(c *gin.Context) {
+		if concurrencyExemptPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		acquire(c)
+	}
+}
+
+// limitGroup caps the number of requests served at once by the endpoints of a group,
+// using the limit configured for it. Groups without a limit are not throttled.
+func (s *Server) limitGroup(group string) gin.HandlerFunc {
+	limit := s.cfg.GroupConcurrencyLimits[group]
+	if limit <= 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	return s.semaphore(limit, "Too many concurrent "+group+" requests")
+}
+
+// semaphore returns a middleware letting at most limit requests through at a time,
+// rejecting the rest with a 503 and the given message.
+func (s *Server) semaphore(limit int, message string) gin.HandlerFunc {
+	slots := make(chan struct{}, limit)
+
+	return func(c *gin.Context) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			c.Next()
+		default:
+			c.Header("Retry-After", "1")
+			s.respondError(c, http.StatusServiceUnavailable, types.CodeOverloaded, message)
+			c.Abort()
+		}
+	}
+}
+
+// authenticate rejects requests without the API key with a 401. The key is taken from
+// the X-API-Key header, or else from an "Authorization: Bearer" header, and compared
+// in constant time.
+func (s *Server) authenticate(key string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authExemptPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		provided := providedAPIKey(c)
+		switch {
+		case provided == "":
+			c.Header("WWW-Authenticate", "Bearer")
+			s.respondError(c, http.StatusUnauthorized, types.CodeUnauthorized, "Missing API key")
+			c.Abort()
+		case subtle.ConstantTimeCompare([]byte(provided), []byte(key)) != 1:
+			c.Header("WWW-Authenticate", "Bearer")
+			s.respondError(c, http.StatusUnauthorized, types.CodeUnauthorized, "Invalid API key")
+			c.Abort()
+		default:
+			c.Next()
+		}
+	}
+}
+
+// allowOrigins lets browsers on the given origins call the API, setting the CORS
+// headers for them and answering their preflight requests with a 204. An origin of
+// "*" allows every origin. Requests from other origins get no CORS headers, so the
+// browser blocks them.
+func allowOrigins(origins []string) gin.HandlerFunc {
+	allowAll := slices.Contains(origins, "*")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || !allowAll && !slices.Contains(origins, origin) {
+			c.Next()
+			return
+		}
+
+		if allowAll {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID, X-Action-Type-Seen")
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key")
+			c.Header("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// providedAPIKey returns the API key sent with the request, or "" if there is none.
+func providedAPIKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	key, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+	return key
+}
+
+// recordMetrics records the method, route template, status and duration of each
+// request in the Prometheus HTTP metrics.
+func recordMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		metrics.RecordRequest(c.Request.Method, c.FullPath(), c.Writer.Status(), time.Since(start))
+	}
+}
+
+// requestID generates a random ID identifying the request, and returns it to the
+// client in the X-Request-ID header so it can be matched with the logs.
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := make([]byte, 8)
+		if _, err := rand.Read(raw); err == nil {
+			id := hex.EncodeToString(raw)
+			c.Set(requestIDKey, id)
+			c.Header("X-Request-ID", id)
+		}
+		c.Next()
+	}
+}
+
+// logRequests writes a log line for each request to out, as JSON with
+// Config.JSONLogs. When Config.LogSampleRate is set only that fraction of requests is
+// logged, while errors and slow requests are always logged in full.
+func (s *Server) logRequests(out io.Writer) gin.HandlerFunc {
+	cfg := gin.LoggerConfig{
+		Output: out,
+		Skip: func(c *gin.Context) bool {
+			return !s.shouldLog(c)
+		},
+	}
+	if s.cfg.JSONLogs {
+		cfg.Formatter = formatJSONLog
+	}
+
+	return gin.LoggerWithConfig(cfg)
+}
+
+// requestLog is a request log line written with Config.JSONLogs.
+type requestLog struct {
+	Time      string  `json:"time"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Status    int     `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	ClientIP  string  `json:"client_ip"`
+	RequestID string  `json:"request_id"`
+}
+
+// formatJSONLog formats a request as a single JSON line.
+func formatJSONLog(params gin.LogFormatterParams) string {
+	requestID, _ := params.Keys[requestIDKey].(string)
+	line, err := json.Marshal(requestLog{
+		Time:      params.TimeStamp.UTC().Format(time.RFC3339Nano),
+		Method:    params.Method,
+		Path:      params.Path,
+		Status:    params.StatusCode,
+		LatencyMS: float64(params.Latency) / float64(time.Millisecond),
+		ClientIP:  params.ClientIP,
+		RequestID: requestID,
+	})
+	if err != nil {
+		return ""
+	}
+
+	return string(line) + "\n"
+}
+
+// shouldLog reports whether a finished request is logged.
+func (s *Server) shouldLog(c *gin.Context) bool {
+	if c.Writer.Status() >= http.StatusBadRequest {
+		return true
+	}
+	cfg := s.config()
+	if start := c.GetTime(requestStartKey); cfg.SlowRequestThreshold > 0 && !start.IsZero() &&
+		time.Since(start) >= cfg.SlowRequestThreshold {
+		return true
+	}
+
+	return sampled(c.GetString(requestIDKey), cfg.LogSampleRate)
+}
+
+// sampled reports whether the request with the given ID falls within the sample. The
+// decision is derived from the ID, so it is the same wherever it is made. A rate
+// outside (0, 1) samples every request.
+func sampled(requestID string, rate float64) bool {
+	if rate <= 0 || rate >= 1 {
+		return true
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(requestID))
+
+	return float64(hash.Sum32()) < rate*(1<<32)
+}
@@ -0,0 +1,449 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klemis/user-actions-api/metrics"
+	"github.com/klemis/user-actions-api/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// TestLimitConcurrency saturates the semaphore and checks further requests are rejected.
+func TestLimitConcurrency(t *testing.T) {
+	server := &Server{store: &MockStorage{}}
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(server.limitConcurrency(1))
+	router.GET("/slow", func(c *gin.Context) {
+		entered <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+	router.GET("/metrics", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	// Occupy the only slot.
+	var wg sync.WaitGroup
+	var first *httptest.ResponseRecorder
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		first = serve("/slow")
+	}()
+	<-entered
+
+	// The limit is reached, so further requests are rejected.
+	rejected := serve("/slow")
+	assert.Equal(t, http.StatusServiceUnavailable, rejected.Code)
+	assert.Equal(t, "1", rejected.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error": "Too many concurrent requests", "code": "OVERLOADED"}`, rejected.Body.String())
+
+	// Monitoring endpoints are exempt.
+	assert.Equal(t, http.StatusOK, serve("/metrics").Code)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, http.StatusOK, first.Code)
+
+	// Once the slot is freed, requests are served again.
+	go func() { <-entered }()
+	assert.Equal(t, http.StatusOK, serve("/slow").Code)
+}
+
+// TestLimitGroup saturates the analytics group and checks that its endpoints are
+// rejected while cheap lookups are still served.
+func TestLimitGroup(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	mockStore := &MockStorage{}
+	// The first analytics request blocks while holding the group's only slot.
+	mockStore.On("GetActions").Run(func(mock.Arguments) {
+		entered <- struct{}{}
+		<-release
+	}).Return([]types.Action{}).Once()
+	mockStore.On("GetActions").Return([]types.Action{})
+	mockStore.On("GetUser", 1).Return(&types.User{ID: 1, Name: "Alice"})
+
+	gin.SetMode(gin.TestMode)
+	server := NewServer("", mockStore, Config{GroupConcurrencyLimits: map[string]int{GroupAnalytics: 1}})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		response := httptest.NewRecorder()
+		server.router.ServeHTTP(response, req)
+		return response
+	}
+
+	var wg sync.WaitGroup
+	var first *httptest.ResponseRecorder
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		first = serve("/actions/transition-graph")
+	}()
+	<-entered
+
+	// Every endpoint of the saturated group is rejected.
+	for _, path := range []string{"/actions/transition-graph", "/actions/type-share"} {
+		rejected := serve(path)
+		assert.Equal(t, http.StatusServiceUnavailable, rejected.Code)
+		assert.Equal(t, "1", rejected.Header().Get("Retry-After"))
+		assert.JSONEq(t, `{"error": "Too many concurrent analytics requests", "code": "OVERLOADED"}`, rejected.Body.String())
+	}
+
+	// Lookups and other groups are not throttled.
+	assert.Equal(t, http.StatusOK, serve("/users/1").Code)
+	assert.Equal(t, http.StatusOK, serve("/actions/recent").Code)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, http.StatusOK, serve("/actions/transition-graph").Code)
+}
+
+// TestLogRequestsSampling checks that failed requests are logged regardless of the
+// sample rate, while successful ones are subject to it.
+func TestLogRequestsSampling(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         Config
+		path        string
+		expectedLog bool
+	}{
+		{name: "Server error with tiny sample rate", cfg: Config{LogSampleRate: 0.000001}, path: "/fail", expectedLog: true},
+		{name: "Client error with tiny sample rate", cfg: Config{LogSampleRate: 0.000001}, path: "/missing", expectedLog: true},
+		{name: "Success with tiny sample rate", cfg: Config{LogSampleRate: 0.000001}, path: "/ok", expectedLog: false},
+		{name: "Success without sampling", cfg: Config{}, path: "/ok", expectedLog: true},
+		{name: "Slow success with tiny sample rate", cfg: Config{LogSampleRate: 0.000001, SlowRequestThreshold: time.Nanosecond}, path: "/ok", expectedLog: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			server := &Server{cfg: tt.cfg}
+			var logs bytes.Buffer
+
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			// A fixed request ID outside the tiny sample keeps the test deterministic.
+			router.Use(requestStart(), func(c *gin.Context) {
+				c.Set(requestIDKey, "not-sampled")
+				c.Next()
+			}, server.logRequests(&logs))
+			router.GET("/ok", func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+			router.GET("/fail", func(c *gin.Context) {
+				c.Status(http.StatusInternalServerError)
+			})
+
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, tt.expectedLog, strings.Contains(logs.String(), tt.path))
+		})
+	}
+}
+
+// TestLogRequestsJSON checks that each request is logged as one JSON line, carrying
+// the request ID returned in the X-Request-ID header.
+func TestLogRequestsJSON(t *testing.T) {
+	server := &Server{cfg: Config{JSONLogs: true}}
+	var logs bytes.Buffer
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(requestStart(), requestID(), server.logRequests(&logs))
+	router.GET("/users/:id", func(c *gin.Context) {
+		c.Status(http.StatusNotFound)
+	})
+
+	req, _ := http.NewRequest("GET", "/users/7?expand=true", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	assert.Len(t, lines, 1)
+
+	var line map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &line))
+	assert.Equal(t, "GET", line["method"])
+	assert.Equal(t, "/users/7?expand=true", line["path"])
+	assert.Equal(t, float64(http.StatusNotFound), line["status"])
+	assert.Equal(t, "192.0.2.1", line["client_ip"])
+	assert.Contains(t, line, "latency_ms")
+	assert.Contains(t, line, "time")
+
+	requestID := response.Header().Get("X-Request-ID")
+	assert.Len(t, requestID, 16)
+	assert.Equal(t, requestID, line["request_id"])
+}
+
+// TestAllowOrigins checks the CORS headers and preflight handling. An API key is
+// required throughout, as browsers send preflight requests without credentials.
+func TestAllowOrigins(t *testing.T) {
+	const dashboard = "https://dashboard.example.com"
+
+	tests := []struct {
+		name           string
+		origins        []string
+		method         string
+		headers        map[string]string
+		expectedStatus int
+		expectedOrigin string
+	}{
+		{
+			name:           "Preflight from allowed origin",
+			origins:        []string{"https://admin.example.com", dashboard},
+			method:         "OPTIONS",
+			headers:        map[string]string{"Origin": dashboard, "Access-Control-Request-Method": "GET"},
+			expectedStatus: http.StatusNoContent,
+			expectedOrigin: dashboard,
+		},
+		{
+			name:           "Request from allowed origin",
+			origins:        []string{dashboard},
+			method:         "GET",
+			headers:        map[string]string{"Origin": dashboard, "Authorization": "Bearer secret"},
+			expectedStatus: http.StatusBadRequest,
+			expectedOrigin: dashboard,
+		},
+		{
+			// Without CORS headers the preflight falls through to authentication.
+			name:           "Preflight from disallowed origin",
+			origins:        []string{dashboard},
+			method:         "OPTIONS",
+			headers:        map[string]string{"Origin": "https://evil.example.com", "Access-Control-Request-Method": "GET"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Request from disallowed origin",
+			origins:        []string{dashboard},
+			method:         "GET",
+			headers:        map[string]string{"Origin": "https://evil.example.com", "Authorization": "Bearer secret"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Request without origin",
+			origins:        []string{dashboard},
+			method:         "GET",
+			headers:        map[string]string{"Authorization": "Bearer secret"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Wildcard preflight",
+			origins:        []string{"*"},
+			method:         "OPTIONS",
+			headers:        map[string]string{"Origin": "https://anywhere.example.com", "Access-Control-Request-Method": "POST"},
+			expectedStatus: http.StatusNoContent,
+			expectedOrigin: "*",
+		},
+		{
+			name:           "Wildcard request",
+			origins:        []string{"*"},
+			method:         "GET",
+			headers:        map[string]string{"Origin": "https://anywhere.example.com", "Authorization": "Bearer secret"},
+			expectedStatus: http.StatusBadRequest,
+			expectedOrigin: "*",
+		},
+		{
+			name:           "CORS disabled",
+			method:         "OPTIONS",
+			headers:        map[string]string{"Origin": dashboard, "Access-Control-Request-Method": "GET"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			gin.SetMode(gin.TestMode)
+			server := NewServer("", new(MockStorage), Config{APIKey: "secret", CORSOrigins: tt.origins})
+
+			req, _ := http.NewRequest(tt.method, "/users/abc", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			response := httptest.NewRecorder()
+
+			server.router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.Equal(t, tt.expectedOrigin, response.Header().Get("Access-Control-Allow-Origin"))
+			if tt.expectedStatus == http.StatusNoContent {
+				assert.Equal(t, "Authorization, Content-Type, X-API-Key", response.Header().Get("Access-Control-Allow-Headers"))
+				assert.Contains(t, response.Header().Get("Access-Control-Allow-Methods"), tt.headers["Access-Control-Request-Method"])
+			}
+			// Responses varying by origin must not be cached across origins.
+			if tt.expectedOrigin != "" && tt.expectedOrigin != "*" {
+				assert.Equal(t, "Origin", response.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+// TestRecordMetrics issues a few requests and checks that the HTTP metrics scraped
+// from /metrics moved, labelled by route template rather than URL.
+func TestRecordMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := NewServer("", new(MockStorage), Config{})
+
+	counter := func(method, path, status string) float64 {
+		return testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues(method, path, status))
+	}
+	healthz := counter("GET", "/healthz", "200")
+	invalidUser := counter("GET", "/users/:id", "400")
+	unmatched := counter("GET", metrics.UnmatchedRoute, "404")
+
+	for _, path := range []string{"/healthz", "/healthz", "/users/abc", "/users/xyz", "/no/such/route"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		server.router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(t, healthz+2, counter("GET", "/healthz", "200"))
+	assert.Equal(t, invalidUser+2, counter("GET", "/users/:id", "400"))
+	assert.Equal(t, unmatched+1, counter("GET", metrics.UnmatchedRoute, "404"))
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	response := httptest.NewRecorder()
+	server.router.ServeHTTP(response, req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	body := response.Body.String()
+	assert.Contains(t, body, `http_requests_total{method="GET",path="/users/:id",status="400"}`)
+	assert.Contains(t, body, `http_request_duration_seconds_count{path="/healthz"}`)
+	assert.NotContains(t, body, "/users/abc")
+	assert.NotContains(t, body, "/no/such/route")
+}
+
+func TestSampled(t *testing.T) {
+	// The decision is deterministic per request ID.
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("request-%d", i)
+		assert.Equal(t, sampled(id, 0.5), sampled(id, 0.5))
+	}
+
+	// Roughly the requested fraction of requests is sampled.
+	count := 0
+	for i := 0; i < 10000; i++ {
+		if sampled(fmt.Sprintf("request-%d", i), 0.1) {
+			count++
+		}
+	}
+	assert.InDelta(t, 1000, count, 150)
+
+	// Rates outside (0, 1) sample everything.
+	assert.True(t, sampled("request", 0))
+	assert.True(t, sampled("request", 1))
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name           string
+		apiKey         string
+		path           string
+		headers        map[string]string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Bearer token",
+			apiKey:         "secret",
+			path:           "/users/abc",
+			headers:        map[string]string{"Authorization": "Bearer secret"},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid user ID", "code": "INVALID_USER_ID"}`,
+		},
+		{
+			name:           "X-API-Key header",
+			apiKey:         "secret",
+			path:           "/users/abc",
+			headers:        map[string]string{"X-API-Key": "secret"},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid user ID", "code": "INVALID_USER_ID"}`,
+		},
+		{
+			name:           "Missing key",
+			apiKey:         "secret",
+			path:           "/users/abc",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error": "Missing API key", "code": "UNAUTHORIZED"}`,
+		},
+		{
+			name:           "Wrong key",
+			apiKey:         "secret",
+			path:           "/users/abc",
+			headers:        map[string]string{"Authorization": "Bearer guess"},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error": "Invalid API key", "code": "UNAUTHORIZED"}`,
+		},
+		{
+			name:           "Other authorization scheme",
+			apiKey:         "secret",
+			path:           "/users/abc",
+			headers:        map[string]string{"Authorization": "Basic secret"},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error": "Invalid API key", "code": "UNAUTHORIZED"}`,
+		},
+		{
+			name:           "Health probe without key",
+			apiKey:         "secret",
+			path:           "/healthz",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status": "ok"}`,
+		},
+		{
+			name:           "Authentication disabled",
+			path:           "/users/abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid user ID", "code": "INVALID_USER_ID"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			gin.SetMode(gin.TestMode)
+			server := NewServer("", new(MockStorage), Config{APIKey: tt.apiKey})
+
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			response := httptest.NewRecorder()
+
+			server.router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
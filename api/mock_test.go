@@ -0,0 +1,131 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klemis/user-actions-api/storage"
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMockResponsesMatchSchemas serves every endpoint from the mock backend and checks
+// that each response decodes into its response type without unknown fields.
+func TestMockResponsesMatchSchemas(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.New("mock", storage.Config{})
+	assert.NoError(t, err)
+	server := NewServer("", store, Config{})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		schema func() any
+	}{
+		{"User", "GET", "/users/1", "", func() any { return &types.User{} }},
+		{"CreateUser", "POST", "/users", `{"name": "New User"}`, func() any { return &types.User{} }},
+		{"UserProfile", "GET", "/users/1/profile", "", func() any { return &types.UserProfile{} }},
+		{"UserCadence", "GET", "/users/1/actions/cadence", "", func() any { return &types.UserCadence{} }},
+		{"UserVelocity", "GET", "/users/1/velocity", "", func() any { return &types.UserVelocity{} }},
+		{"UserNextActionProbability", "GET", "/users/1/next-probability-for?type=ADD_CONTACT", "", func() any { return &types.UserNextActionProbability{} }},
+		{"UserActions", "GET", "/users/1/actions?limit=5", "", func() any { return &[]types.Action{} }},
+		{"ActionCount", "GET", "/users/1/actions/count", "", func() any { return &struct{ Count int }{} }},
+		{"IndexedUserActions", "GET", "/users/1/actions/indexed", "", func() any { return &[]types.IndexedAction{} }},
+		{"ReferralDetail", "GET", "/users/1/referrals/detail", "", func() any { return &[]types.ReferralDetail{} }},
+		{"ReferralIndex", "GET", "/users/referral-index", "", func() any { return &types.ReferralIndex{} }},
+		{"ExpandedReferralIndex", "GET", "/users/referral-index?expand=true", "", func() any { return &types.ExpandedReferralIndex{} }},
+		{"ReferralIndexWithCycles", "GET", "/users/referral-index?reportCycles=true", "", func() any { return &types.ReferralIndexWithCycles{} }},
+		{"UsersAboveReferralIndex", "GET", "/users/referrals/above?min=0", "", func() any { return &[]types.UserReferralIndex{} }},
+		{"LiveReferralIndex", "GET", "/users/referrals/live", "", func() any { return &types.ReferralIndex{} }},
+		{"ReferralEdges", "GET", "/users/referrals/edges", "", func() any { return &[]types.ReferralEdge{} }},
+		{"ReferralFanout", "GET", "/users/referrals/fanout", "", func() any { return &types.ReferralFanout{} }},
+		{"NewestUsers", "GET", "/users/newest?limit=5", "", func() any { return &[]types.User{} }},
+		{"OldestUsers", "GET", "/users/oldest?limit=5", "", func() any { return &[]types.User{} }},
+		{"InactiveUsers", "GET", "/users/inactive", "", func() any { return &[]types.User{} }},
+		{"ReferralTrees", "POST", "/users/referral-trees", `{"userIds": [1, 2]}`, func() any { return &[]types.ReferralTree{} }},
+		{"Action", "GET", "/actions/1", "", func() any { return &types.Action{} }},
+		{"CreateAction", "POST", "/actions", `{"type": "WELCOME", "userId": 1}`, func() any { return &types.Action{} }},
+		{"ActionsByTime", "GET", "/actions?orderBy=time&limit=10", "", func() any { return &[]types.Action{} }},
+		{"BatchGetActions", "POST", "/actions/batch-get", `{"ids": [1, 2]}`, func() any { return &[]*types.Action{} }},
+		{"NextActionProbability", "GET", "/actions/WELCOME/next-probability", "", func() any { return &types.ActionsProbalibity{} }},
+		{"NextActionProbabilityArray", "GET", "/actions/WELCOME/next-probability?as=array", "", func() any { return &[]types.ActionProbability{} }},
+		{"DetailedNextActionProbability", "GET", "/actions/WELCOME/next-probability?detailed=true", "", func() any { return &[]types.DetailedActionProbability{} }},
+		{"ExplainedNextActionProbability", "GET", "/actions/WELCOME/next-probability?explain=true", "", func() any { return &types.ExplainedActionsProbability{} }},
+		{"NextActionAlternatives", "GET", "/actions/WELCOME/alternatives?top=3", "", func() any { return &[]types.ActionProbability{} }},
+		{"ConditionalNextActionProbability", "GET", "/actions/next-probability?current=ADD_CONTACT&previous=WELCOME", "", func() any { return &types.ActionsProbalibity{} }},
+		{"ActionTypeCount", "GET", "/actions/WELCOME/count", "", func() any { return &struct{ Count int }{} }},
+		{"GapStats", "GET", "/actions/WELCOME/gap-stats", "", func() any { return &types.GapStats{} }},
+		{"ExpectedNextAction", "GET", "/actions/WELCOME/expected-next", "", func() any { return &types.ExpectedNextAction{} }},
+		{"ActionsSample", "GET", "/actions/sample?seed=1", "", func() any { return &[]types.Action{} }},
+		{"FirstActionTypes", "GET", "/actions/first", "", func() any { return &[]types.ActionTypeCount{} }},
+		{"RecentActions", "GET", "/actions/recent", "", func() any { return &[]types.Action{} }},
+		{"CompareNextActions", "GET", "/actions/compare-next?a=WELCOME&b=ADD_CONTACT", "", func() any { return &types.ActionsComparison{} }},
+		{"TransitionGraph", "GET", "/actions/transition-graph", "", func() any { return &[]types.TransitionEdge{} }},
+		{"TransitionMatrix", "GET", "/actions/transition-matrix", "", func() any { return &types.TransitionMatrix{} }},
+		{"CommonSequences", "GET", "/actions/common-sequences", "", func() any { return &[]types.ActionSequence{} }},
+		{"Funnel", "POST", "/actions/funnel", `{"steps": ["WELCOME", "CONNECT_CRM", "ADD_CONTACT"]}`, func() any { return &types.Funnel{} }},
+		{"TransitionEntropy", "GET", "/actions/entropy", "", func() any { return &[]types.ActionTypeEntropy{} }},
+		{"TypeShare", "GET", "/actions/type-share", "", func() any { return &[]types.TypeShareBucket{} }},
+		{"SelfTargetingActions", "GET", "/actions/self-targeting", "", func() any { return &[]types.Action{} }},
+		{"Stats", "GET", "/stats", "", func() any { return &types.Stats{} }},
+		{"InsertPosition", "GET", "/admin/debug/insert-position?userId=1&createdAt=2021-07-01T12:00:00Z", "", func() any { return &struct{ Index int }{} }},
+		{"Consistency", "GET", "/admin/consistency", "", func() any { return &types.ConsistencyReport{} }},
+		{"Resort", "POST", "/admin/resort", "", func() any { return &struct{ Moved int }{} }},
+		{"ReferralConversion", "GET", "/metrics/referral-conversion", "", func() any { return &types.ReferralConversion{} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			response := httptest.NewRecorder()
+			server.router.ServeHTTP(response, req)
+
+			// Creating endpoints answer 201, everything else 200.
+			assert.Contains(t, []int{http.StatusOK, http.StatusCreated}, response.Code, response.Body.String())
+			decoder := json.NewDecoder(response.Body)
+			decoder.DisallowUnknownFields()
+			assert.NoError(t, decoder.Decode(tt.schema()))
+		})
+	}
+
+	t.Run("ExportTimelines", func(t *testing.T) {
+		t.Parallel() // Enable parallel execution
+
+		req, _ := http.NewRequest("GET", "/export/timelines", nil)
+		response := httptest.NewRecorder()
+		server.router.ServeHTTP(response, req)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		lines := 0
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+			decoder.DisallowUnknownFields()
+			assert.NoError(t, decoder.Decode(&types.UserTimeline{}))
+			lines++
+		}
+		assert.NotZero(t, lines)
+	})
+}
+
+// TestMockDeterministic checks that the mock backend generates the same data every time.
+func TestMockDeterministic(t *testing.T) {
+	first, err := storage.New("mock", storage.Config{})
+	assert.NoError(t, err)
+	second, err := storage.New("mock", storage.Config{})
+	assert.NoError(t, err)
+
+	assert.NotEmpty(t, first.GetActions())
+	assert.Equal(t, first.GetActions(), second.GetActions())
+	assert.Equal(t, first.GetUser(1), second.GetUser(1))
+}
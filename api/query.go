@@ -0,0 +1,239 @@
+package api
+
+import (
+	"cmp"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klemis/user-actions-api/types"
+)
+
+// filterBySource narrows actions to those from the ?source= query parameter, if set.
+func filterBySource(c *gin.Context, actions []types.Action) []types.Action {
+	source := c.Query("source")
+	if source == "" {
+		return actions
+	}
+
+	filtered := []types.Action{}
+	for _, action := range actions {
+		if action.Source == source {
+			filtered = append(filtered, action)
+		}
+	}
+
+	return filtered
+}
+
+// maxActionTypeLength is the longest action type accepted in a request.
+const maxActionTypeLength = 64
+
+// strictActionType is the form action types must take when Config.StrictActionTypes is set.
+var strictActionType = regexp.MustCompile(`^[A-Z_]+$`)
+
+// parseActionType validates an action type taken from the request. Empty and overly long
+// values, and values with slashes, whitespace or control characters (e.g. from URL-encoded
+// path segments), are rejected with a 400, in which case ok is false. In strict mode the
+// type must also consist of upper-case letters and underscores only.
+func (s *Server) parseActionType(c *gin.Context, value string) (actionType types.ActionType, ok bool) {
+	switch {
+	case value == "":
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidActionType, "Action type is required")
+		return "", false
+	case len(value) > maxActionTypeLength:
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidActionType, "Action type is too long")
+		return "", false
+	case strings.ContainsFunc(value, func(r rune) bool {
+		return r == '/' || unicode.IsSpace(r) || !unicode.IsPrint(r)
+	}):
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidActionType, "Invalid action type")
+		return "", false
+	case s.cfg.StrictActionTypes && !strictActionType.MatchString(value):
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidActionType, "Invalid action type")
+		return "", false
+	}
+
+	return types.ActionType(value), true
+}
+
+// parseQueriedActionType is parseActionType for the probability and transition
+// endpoints, which in strict mode also require the type to be one of
+// Config.AllowedActionTypes if any are configured.
+func (s *Server) parseQueriedActionType(c *gin.Context, value string) (actionType types.ActionType, ok bool) {
+	actionType, ok = s.parseActionType(c, value)
+	if !ok {
+		return "", false
+	}
+	if s.cfg.StrictActionTypes && len(s.cfg.AllowedActionTypes) > 0 &&
+		!slices.Contains(s.cfg.AllowedActionTypes, actionType) {
+		s.respondError(c, http.StatusBadRequest, types.CodeActionTypeNotAllowed, "Action type not allowed")
+		return "", false
+	}
+
+	return actionType, true
+}
+
+const (
+	// defaultPageLimit is the page size used when ?limit= is not given.
+	defaultPageLimit = 50
+	// maxPageLimit is the largest page size a client can request.
+	maxPageLimit = 500
+)
+
+// page is the window of a list requested with ?limit= and ?offset=.
+type page struct {
+	limit  int
+	offset int
+}
+
+// parsePage reads the pagination query parameters. Limits above maxPageLimit are
+// clamped, while negative or unparsable values (including offsets too large to fit
+// in an int) are rejected with a 400, in which case ok is false.
+func (s *Server) parsePage(c *gin.Context) (p page, ok bool) {
+	p = page{limit: defaultPageLimit}
+
+	if value, exists := c.GetQuery("limit"); exists {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid limit")
+			return page{}, false
+		}
+		p.limit = min(limit, maxPageLimit)
+	}
+
+	if value, exists := c.GetQuery("offset"); exists {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid offset")
+			return page{}, false
+		}
+		p.offset = offset
+	}
+
+	return p, true
+}
+
+// paginate returns the items within the page. An offset past the end yields an empty
+// page rather than an out-of-range slice.
+func paginate[T any](items []T, p page) []T {
+	if p.offset >= len(items) {
+		return []T{}
+	}
+
+	end := len(items)
+	if p.limit < end-p.offset {
+		end = p.offset + p.limit
+	}
+
+	return items[p.offset:end]
+}
+
+// actionOrders compares actions for each ?sort= field of GET /actions. Ties are broken
+// by the fields after it, and finally by ID, so pages never overlap.
+var actionOrders = map[string]func(a, b types.Action) int{
+	"createdAt": func(a, b types.Action) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	},
+	// userId matches the order of the storage.
+	"userId": func(a, b types.Action) int {
+		if c := cmp.Compare(a.UserID, b.UserID); c != 0 {
+			return c
+		}
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	},
+	"id": func(a, b types.Action) int {
+		return cmp.Compare(a.ID, b.ID)
+	},
+}
+
+// timeRange is the half-open interval [from, to) requested with ?from= and ?to=.
+// A zero bound leaves that side of the range open.
+type timeRange struct {
+	from time.Time
+	to   time.Time
+}
+
+// contains reports whether t falls within the range.
+func (r timeRange) contains(t time.Time) bool {
+	if !r.from.IsZero() && t.Before(r.from) {
+		return false
+	}
+	if !r.to.IsZero() && !t.Before(r.to) {
+		return false
+	}
+
+	return true
+}
+
+// bounded reports whether either end of the range is set.
+func (r timeRange) bounded() bool {
+	return !r.from.IsZero() || !r.to.IsZero()
+}
+
+// filter returns the actions created within the range, keeping their order.
+func (r timeRange) filter(actions []types.Action) []types.Action {
+	filtered := make([]types.Action, 0, len(actions))
+	for _, action := range actions {
+		if r.contains(action.CreatedAt) {
+			filtered = append(filtered, action)
+		}
+	}
+
+	return filtered
+}
+
+// parseTimeRange reads the ?from= and ?to= RFC 3339 timestamps. Unparsable values, or
+// a range ending before it starts, are rejected with a 400, in which case ok is false.
+func (s *Server) parseTimeRange(c *gin.Context) (r timeRange, ok bool) {
+	if value, exists := c.GetQuery("from"); exists {
+		from, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid from timestamp")
+			return timeRange{}, false
+		}
+		r.from = from
+	}
+
+	if value, exists := c.GetQuery("to"); exists {
+		to, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid to timestamp")
+			return timeRange{}, false
+		}
+		r.to = to
+	}
+
+	if !r.from.IsZero() && !r.to.IsZero() && r.to.Before(r.from) {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid time range")
+		return timeRange{}, false
+	}
+
+	return r, true
+}
+
+// parseRoundingMode returns the rounding mode for probabilities requested with
+// ?roundingMode=, falling back to the configured mode and then to RoundHalfUp. It
+// writes a 400 and reports false for an unknown mode.
+func (s *Server) parseRoundingMode(c *gin.Context) (string, bool) {
+	switch mode := c.DefaultQuery("roundingMode", s.config().RoundingMode); mode {
+	case "", RoundHalfUp:
+		return RoundHalfUp, true
+	case RoundHalfEven:
+		return RoundHalfEven, true
+	default:
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid rounding mode, expected half-up or half-even")
+		return "", false
+	}
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaginate(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+
+	tests := []struct {
+		name     string
+		page     page
+		expected []int
+	}{
+		{name: "First page", page: page{limit: 2, offset: 0}, expected: []int{1, 2}},
+		{name: "Middle page", page: page{limit: 2, offset: 2}, expected: []int{3, 4}},
+		{name: "Last partial page", page: page{limit: 2, offset: 4}, expected: []int{5}},
+		{name: "Offset at end", page: page{limit: 2, offset: 5}, expected: []int{}},
+		{name: "Offset far beyond end", page: page{limit: 2, offset: 9999999999}, expected: []int{}},
+		{name: "Limit and offset near overflow", page: page{limit: maxPageLimit, offset: int(^uint(0) >> 1)}, expected: []int{}},
+		{name: "Limit larger than remaining", page: page{limit: maxPageLimit, offset: 1}, expected: []int{2, 3, 4, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			assert.Equal(t, tt.expected, paginate(items, tt.page))
+		})
+	}
+}
+
+// TestListEndpointsLargeOffset tests that every paginated list endpoint copes with
+// offsets far beyond the data size.
+func TestListEndpointsLargeOffset(t *testing.T) {
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/users/referrals/above", server.handleGetUsersAboveReferralIndex)
+
+	mockStore.On("GetActions").Return([]types.Action{
+		{ID: 1, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(2)},
+		{ID: 2, UserID: 2, Type: "REFER_USER", TargetUser: targetUser(3)},
+	})
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Referrals above, offset beyond data",
+			path:           "/users/referrals/above?min=0&offset=9999999999",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			name:           "Referrals above, offset overflowing int",
+			path:           "/users/referrals/above?min=0&offset=99999999999999999999999",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid offset", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Referrals above, negative offset",
+			path:           "/users/referrals/above?min=0&offset=-1",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid offset", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Referrals above, invalid limit",
+			path:           "/users/referrals/above?min=0&limit=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid limit", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Referrals above, page within data",
+			path:           "/users/referrals/above?min=0&limit=1&offset=1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"userId": 2, "referralIndex": 1}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestParseActionType tests the validation of action types taken from requests.
+func TestParseActionType(t *testing.T) {
+	tests := []struct {
+		name           string
+		cfg            Config
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Empty type",
+			path:           "/actions//next-probability",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Action type is required", "code": "INVALID_ACTION_TYPE"}`,
+		},
+		{
+			name:           "Overly long type",
+			path:           "/actions/" + strings.Repeat("A", maxActionTypeLength+1) + "/next-probability",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Action type is too long", "code": "INVALID_ACTION_TYPE"}`,
+		},
+		{
+			name:           "Encoded whitespace",
+			path:           "/actions/WELCOME%20/next-probability",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid action type", "code": "INVALID_ACTION_TYPE"}`,
+		},
+		{
+			name:           "Encoded slash in query",
+			path:           "/actions/compare-next?a=WELCOME&b=ADD%2FCONTACT",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid action type", "code": "INVALID_ACTION_TYPE"}`,
+		},
+		{
+			name:           "Lower-case type is accepted by default",
+			path:           "/actions/welcome/next-probability",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"typeSeen": false}`,
+		},
+		{
+			name:           "Lower-case type is rejected in strict mode",
+			cfg:            Config{StrictActionTypes: true},
+			path:           "/actions/welcome/next-probability",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid action type", "code": "INVALID_ACTION_TYPE"}`,
+		},
+		{
+			name:           "Conforming type in strict mode",
+			cfg:            Config{StrictActionTypes: true},
+			path:           "/actions/WELCOME/next-probability",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"CONNECT_CRM": 1}`,
+		},
+		{
+			name:           "Allowed type in strict mode",
+			cfg:            Config{StrictActionTypes: true, AllowedActionTypes: []types.ActionType{"WELCOME", "CONNECT_CRM"}},
+			path:           "/actions/WELCOME/next-probability",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"CONNECT_CRM": 1}`,
+		},
+		{
+			name:           "Disallowed type in strict mode",
+			cfg:            Config{StrictActionTypes: true, AllowedActionTypes: []types.ActionType{"WELCOME", "CONNECT_CRM"}},
+			path:           "/actions/compare-next?a=WELCOME&b=ADD_CONTACT",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Action type not allowed", "code": "ACTION_TYPE_NOT_ALLOWED"}`,
+		},
+		{
+			name:           "Allowlist does not apply to counts",
+			cfg:            Config{StrictActionTypes: true, AllowedActionTypes: []types.ActionType{"CONNECT_CRM"}},
+			path:           "/actions/WELCOME/count",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"count": 1}`,
+		},
+		{
+			name:           "Allowlist is not enforced outside strict mode",
+			cfg:            Config{AllowedActionTypes: []types.ActionType{"CONNECT_CRM"}},
+			path:           "/actions/WELCOME/next-probability",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"CONNECT_CRM": 1}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetActions").Return([]types.Action{
+				{ID: 1, UserID: 1, Type: "WELCOME"},
+				{ID: 2, UserID: 1, Type: "CONNECT_CRM"},
+			})
+			mockStore.On("CountActionsByType", types.ActionType("WELCOME")).Return(1)
+			server := &Server{store: mockStore, cfg: tt.cfg}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/actions/:type/next-probability", server.handleGetNextActionProbability)
+			router.GET("/actions/compare-next", server.handleCompareNextActions)
+			router.GET("/actions/:type/count", server.handleGetActionCountByType)
+
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
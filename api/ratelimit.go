@@ -0,0 +1,104 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klemis/user-actions-api/types"
+)
+
+// rateLimitCleanupInterval is how often the buckets of idle clients are dropped.
+const rateLimitCleanupInterval = time.Minute
+
+// rateLimiter is a token bucket per client. Each bucket holds up to burst tokens and
+// is refilled at rate tokens per second; every request takes a token. The rate and
+// burst are passed on each call, so a reload applies to the existing buckets.
+type rateLimiter struct {
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+// tokenBucket is the state of a single client.
+type tokenBucket struct {
+	tokens float64
+	// last is when the bucket was last refilled.
+	last time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token from the client's bucket. When the bucket is empty it reports
+// false and how long until the next token.
+func (l *rateLimiter) allow(client string, rate float64, burst int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	capacity := float64(burst)
+	now := l.now()
+	if now.Sub(l.lastCleanup) >= rateLimitCleanupInterval {
+		l.cleanup(now, rate, capacity)
+		l.lastCleanup = now
+	}
+
+	bucket, exists := l.buckets[client]
+	if !exists {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+
+	return true, 0
+}
+
+// cleanup drops the buckets that have refilled completely. A new bucket starts full,
+// so dropping them does not change how their clients are limited.
+func (l *rateLimiter) cleanup(now time.Time, rate, capacity float64) {
+	refill := time.Duration(capacity / rate * float64(time.Second))
+	for client, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// limitRate rejects requests of clients that exceed their rate with a 429. Clients are
+// told apart by IP, as the API key is shared by all of them. Health and monitoring
+// endpoints are not limited. The limits are read from the current config, so a zero
+// RateLimit turns limiting off until a reload sets one.
+func (s *Server) limitRate(limiter *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := s.config()
+		if cfg.RateLimit <= 0 || concurrencyExemptPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		if allowed, wait := limiter.allow(c.ClientIP(), cfg.RateLimit, cfg.RateBurst); !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			s.respondError(c, http.StatusTooManyRequests, types.CodeRateLimited, "Rate limit exceeded")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
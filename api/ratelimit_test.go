@@ -0,0 +1,158 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLimitRate exhausts a client's bucket, checks further requests are rejected with
+// a 429, and that the client recovers as the bucket refills.
+func TestLimitRate(t *testing.T) {
+	now := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter()
+	limiter.now = func() time.Time { return now }
+	server := &Server{cfg: Config{RateLimit: 1, RateBurst: 2}}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(server.limitRate(limiter))
+	router.GET("/users/:id", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.GET("/healthz", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	get := func(path, ip string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip + ":1234"
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	// The burst is served at once.
+	assert.Equal(t, http.StatusOK, get("/users/1", "192.0.2.1").Code)
+	assert.Equal(t, http.StatusOK, get("/users/1", "192.0.2.1").Code)
+
+	response := get("/users/1", "192.0.2.1")
+	assert.Equal(t, http.StatusTooManyRequests, response.Code)
+	assert.Equal(t, "1", response.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error": "Rate limit exceeded", "code": "RATE_LIMITED"}`, response.Body.String())
+
+	// Other clients and the health probes are not affected.
+	assert.Equal(t, http.StatusOK, get("/users/1", "192.0.2.2").Code)
+	assert.Equal(t, http.StatusOK, get("/healthz", "192.0.2.1").Code)
+
+	// Half a token is not enough for a request.
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, http.StatusTooManyRequests, get("/users/1", "192.0.2.1").Code)
+
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, get("/users/1", "192.0.2.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, get("/users/1", "192.0.2.1").Code)
+
+	// After a long pause the bucket is full again, but holds no more than the burst.
+	now = now.Add(time.Hour)
+	assert.Equal(t, http.StatusOK, get("/users/1", "192.0.2.1").Code)
+	assert.Equal(t, http.StatusOK, get("/users/1", "192.0.2.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, get("/users/1", "192.0.2.1").Code)
+}
+
+// TestLimitRateBeforeAuth checks that clients sharing the API key are limited by IP,
+// and that requests failing authentication are limited too.
+func TestLimitRateBeforeAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockStore := new(MockStorage)
+	server := NewServer("", mockStore, Config{APIKey: "secret", RateLimit: 1, RateBurst: 1})
+	mockStore.On("GetUser", 1).Return(&types.User{ID: 1, Name: "Tom"})
+
+	get := func(ip, key string) int {
+		req, _ := http.NewRequest("GET", "/users/1", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("Authorization", "Bearer "+key)
+		response := httptest.NewRecorder()
+		server.router.ServeHTTP(response, req)
+		return response.Code
+	}
+
+	// Two IPs with the same key have separate buckets.
+	assert.Equal(t, http.StatusOK, get("192.0.2.1", "secret"))
+	assert.Equal(t, http.StatusOK, get("192.0.2.2", "secret"))
+	assert.Equal(t, http.StatusTooManyRequests, get("192.0.2.1", "secret"))
+
+	// Guessing keys uses up the bucket as well.
+	assert.Equal(t, http.StatusUnauthorized, get("192.0.2.3", "guess"))
+	assert.Equal(t, http.StatusTooManyRequests, get("192.0.2.3", "guess"))
+}
+
+// TestRateLimiterCleanup checks that the buckets of idle clients are dropped, while
+// those of active clients are kept.
+func TestRateLimiterCleanup(t *testing.T) {
+	now := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter()
+	limiter.now = func() time.Time { return now }
+
+	limiter.allow("idle", 10, 20)
+	now = now.Add(rateLimitCleanupInterval - time.Second)
+	limiter.allow("active", 10, 20)
+	assert.Len(t, limiter.buckets, 2)
+
+	// The idle bucket has refilled by the next cleanup, the active one has not.
+	now = now.Add(time.Second)
+	limiter.allow("active", 10, 20)
+	assert.Len(t, limiter.buckets, 1)
+	assert.Contains(t, limiter.buckets, "active")
+}
+
+// TestLimitRateReload checks that reloading the config turns rate limiting on and
+// changes the burst of clients already seen.
+func TestLimitRateReload(t *testing.T) {
+	now := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter()
+	limiter.now = func() time.Time { return now }
+	server := &Server{}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(server.limitRate(limiter))
+	router.GET("/users/:id", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	get := func() int {
+		req, _ := http.NewRequest("GET", "/users/1", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response.Code
+	}
+
+	// No limit is configured at startup.
+	for range 5 {
+		assert.Equal(t, http.StatusOK, get())
+	}
+
+	server.Reload(Config{RateLimit: 1, RateBurst: 2})
+	assert.Equal(t, http.StatusOK, get())
+	assert.Equal(t, http.StatusOK, get())
+	assert.Equal(t, http.StatusTooManyRequests, get())
+
+	// A larger burst lets the client build up more tokens.
+	server.Reload(Config{RateLimit: 1, RateBurst: 3})
+	now = now.Add(3 * time.Second)
+	for range 3 {
+		assert.Equal(t, http.StatusOK, get())
+	}
+	assert.Equal(t, http.StatusTooManyRequests, get())
+
+	// Setting the limit to zero turns limiting off again.
+	server.Reload(Config{})
+	assert.Equal(t, http.StatusOK, get())
+}
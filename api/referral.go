@@ -0,0 +1,300 @@
+package api
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/klemis/user-actions-api/types"
+)
+
+// errTraversalLimit is returned when the referral graph traversal visits more
+// users than the configured limit allows.
+var errTraversalLimit = errors.New("referral traversal limit exceeded")
+
+// buildReferrals creates a mapping of users to the IDs of users they referred. Referrals
+// to user 0 are only counted when zeroTargetValid is set (see Action.ReferralTarget).
+func buildReferrals(actions []types.Action, zeroTargetValid bool) types.Referral {
+	return buildReferralsWithin(actions, timeRange{}, zeroTargetValid)
+}
+
+// buildReferralsWithin is buildReferrals restricted to referrals made within the range.
+func buildReferralsWithin(actions []types.Action, within timeRange, zeroTargetValid bool) types.Referral {
+	referrals := make(types.Referral)
+	for _, action := range actions {
+		target, ok := action.ReferralTarget(zeroTargetValid)
+		if ok && within.contains(action.CreatedAt) {
+			referrals[action.UserID] = append(referrals[action.UserID], target)
+		}
+	}
+
+	return referrals
+}
+
+// withoutUser returns a copy of the referrals with the user removed from the graph:
+// their own referrals are dropped, and so are referrals pointing at them. Users who
+// were only reachable through the removed user are not reattached to anyone.
+func withoutUser(referrals types.Referral, userID int) types.Referral {
+	remaining := make(types.Referral, len(referrals))
+	for referrer, referred := range referrals {
+		if referrer == userID {
+			continue
+		}
+
+		var kept []int
+		for _, user := range referred {
+			if user != userID {
+				kept = append(kept, user)
+			}
+		}
+		if len(kept) > 0 {
+			remaining[referrer] = kept
+		}
+	}
+
+	return remaining
+}
+
+// referredUsers returns the set of users who were referred by someone.
+func referredUsers(actions []types.Action, zeroTargetValid bool) map[int]bool {
+	referred := make(map[int]bool)
+	for _, action := range actions {
+		if target, ok := action.ReferralTarget(zeroTargetValid); ok {
+			referred[target] = true
+		}
+	}
+
+	return referred
+}
+
+// computeReferralIndex calculates the referral index of each referrer: the number of
+// distinct users reachable through their referrals, other than the referrer. A
+// referrer on a referral cycle reaches themselves, but is not counted, so cycles do
+// not inflate the index. The traversal is iterative, and maxVisits caps the total
+// number of users visited across all referrers (0 means no limit) so a pathological
+// graph cannot keep the server busy indefinitely.
+func computeReferralIndex(referrals types.Referral, maxVisits int) (types.ReferralIndex, error) {
+	referralIndex := make(types.ReferralIndex)
+	visits := 0
+
+	for userId := range referrals {
+		// A referrer who only referred themselves still has an index, of 0.
+		referralIndex[userId] = 0
+		visited := map[int]bool{userId: true}
+
+		// Start DFS on each referred user in the referrals list for userId.
+		stack := append([]int(nil), referrals[userId]...)
+		for len(stack) > 0 {
+			user := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if visited[user] {
+				continue
+			}
+
+			visits++
+			if maxVisits > 0 && visits > maxVisits {
+				return nil, errTraversalLimit
+			}
+
+			visited[user] = true
+			referralIndex[userId]++
+
+			// Traverse each referral made by the current user.
+			stack = append(stack, referrals[user]...)
+		}
+	}
+
+	return referralIndex, nil
+}
+
+// referralCycles returns the referrals that lie on a referral cycle, as [referrer,
+// referred] pairs sorted by referrer and then referred user. A referral lies on a
+// cycle when the referred user reaches the referrer again, i.e. both are in the same
+// strongly connected component of the referral graph; self-referrals are cycles too.
+// Repeated referrals are listed once.
+func referralCycles(referrals types.Referral) [][2]int {
+	component := referralComponents(referrals)
+
+	seen := make(map[[2]int]bool)
+	cycles := [][2]int{}
+	for referrer, referred := range referrals {
+		for _, user := range referred {
+			pair := [2]int{referrer, user}
+			if component[referrer] == component[user] && !seen[pair] {
+				seen[pair] = true
+				cycles = append(cycles, pair)
+			}
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool {
+		if cycles[i][0] == cycles[j][0] {
+			return cycles[i][1] < cycles[j][1]
+		}
+		return cycles[i][0] < cycles[j][0]
+	})
+
+	return cycles
+}
+
+// referralComponents assigns each user in the referral graph the number of their
+// strongly connected component, using Kosaraju's algorithm with iterative traversals:
+// the users are ordered by when a depth-first search finishes with them, and the
+// reversed graph is then searched from each user in reverse finishing order, which
+// reaches exactly the users of their component.
+func referralComponents(referrals types.Referral) map[int]int {
+	referredBy := make(types.Referral)
+	for referrer, referred := range referrals {
+		for _, user := range referred {
+			referredBy[user] = append(referredBy[user], referrer)
+		}
+	}
+
+	// frame is a user on the search stack and the index of their next referral.
+	type frame struct {
+		user int
+		next int
+	}
+	visited := make(map[int]bool)
+	finished := make([]int, 0, len(referrals))
+	for root := range referrals {
+		if visited[root] {
+			continue
+		}
+		visited[root] = true
+		stack := []frame{{user: root}}
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if top.next < len(referrals[top.user]) {
+				user := referrals[top.user][top.next]
+				top.next++
+				if !visited[user] {
+					visited[user] = true
+					stack = append(stack, frame{user: user})
+				}
+				continue
+			}
+			finished = append(finished, top.user)
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	component := make(map[int]int, len(visited))
+	for i := len(finished) - 1; i >= 0; i-- {
+		root := finished[i]
+		if _, ok := component[root]; ok {
+			continue
+		}
+		component[root] = root
+		stack := []int{root}
+		for len(stack) > 0 {
+			user := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, referrer := range referredBy[user] {
+				if _, ok := component[referrer]; !ok {
+					component[referrer] = root
+					stack = append(stack, referrer)
+				}
+			}
+		}
+	}
+
+	return component
+}
+
+// referralTree builds the tree of users reachable from root through referrals, down
+// to maxDepth levels below the root (0 means no limit). The tree is built breadth
+// first, so a user referred through several paths appears once, at its shallowest
+// position, and cycles back to users already in the tree are cut.
+func referralTree(referrals types.Referral, root, maxDepth int) *types.ReferralTree {
+	tree := &types.ReferralTree{UserID: root, Referrals: []*types.ReferralTree{}}
+	visited := map[int]bool{root: true}
+
+	level := []*types.ReferralTree{tree}
+	for depth := 1; len(level) > 0 && (maxDepth == 0 || depth <= maxDepth); depth++ {
+		var next []*types.ReferralTree
+		for _, node := range level {
+			referred := append([]int(nil), referrals[node.UserID]...)
+			sort.Ints(referred)
+
+			for _, user := range referred {
+				if visited[user] {
+					continue
+				}
+				visited[user] = true
+
+				child := &types.ReferralTree{UserID: user, Referrals: []*types.ReferralTree{}}
+				node.Referrals = append(node.Referrals, child)
+				next = append(next, child)
+			}
+		}
+		level = next
+	}
+
+	return tree
+}
+
+// referralFanout counts the referrers by how many distinct users they directly
+// referred. Referring the same user more than once counts once.
+func referralFanout(referrals types.Referral) types.ReferralFanout {
+	fanout := make(types.ReferralFanout)
+	for _, referred := range referrals {
+		distinct := make(map[int]bool, len(referred))
+		for _, user := range referred {
+			distinct[user] = true
+		}
+		fanout[len(distinct)]++
+	}
+
+	return fanout
+}
+
+// referralEdges returns an edge for every referral made within the range, ordered by
+// time. Repeated referrals of the same user are kept as separate edges.
+func referralEdges(actions []types.Action, within timeRange, zeroTargetValid bool) []types.ReferralEdge {
+	edges := []types.ReferralEdge{}
+	for _, action := range actions {
+		target, ok := action.ReferralTarget(zeroTargetValid)
+		if ok && within.contains(action.CreatedAt) {
+			edges = append(edges, types.ReferralEdge{From: action.UserID, To: target, At: action.CreatedAt})
+		}
+	}
+	sort.SliceStable(edges, func(i, j int) bool {
+		return edges[i].At.Before(edges[j].At)
+	})
+
+	return edges
+}
+
+// expandReferralIndex adds the name of each referrer to the referral index, looked up
+// with getUser.
+func expandReferralIndex(referralIndex types.ReferralIndex, getUser func(int) *types.User) types.ExpandedReferralIndex {
+	expanded := make(types.ExpandedReferralIndex, len(referralIndex))
+	for userID, index := range referralIndex {
+		entry := types.ReferralIndexEntry{ReferralIndex: index}
+		if user := getUser(userID); user != nil {
+			entry.Name = &user.Name
+		}
+		expanded[userID] = entry
+	}
+
+	return expanded
+}
+
+// rankReferralIndex returns the users with a referral index of at least minIndex,
+// sorted by index descending and then by user ID.
+func rankReferralIndex(referralIndex types.ReferralIndex, minIndex int) []types.UserReferralIndex {
+	ranked := []types.UserReferralIndex{}
+	for userID, index := range referralIndex {
+		if index >= minIndex {
+			ranked = append(ranked, types.UserReferralIndex{UserID: userID, ReferralIndex: index})
+		}
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].ReferralIndex == ranked[j].ReferralIndex {
+			return ranked[i].UserID < ranked[j].UserID
+		}
+		return ranked[i].ReferralIndex > ranked[j].ReferralIndex
+	})
+
+	return ranked
+}
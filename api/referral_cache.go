@@ -0,0 +1,138 @@
+package api
+
+import (
+	"sync"
+
+	"github.com/klemis/user-actions-api/storage"
+	"github.com/klemis/user-actions-api/types"
+)
+
+// referralIndexCache maintains the referral index of every referrer incrementally, for
+// serving a live leaderboard without recomputing the index on each request. It keeps
+// the set of users reachable from each referrer, so merging subtrees counts shared
+// users once, at the cost of memory quadratic in the size of the referral chains.
+//
+// The cache subscribes to the storage on first use. A new referral u→v adds v and
+// everything reachable from v to u and to every user who reaches u, propagating up the
+// referrer chain and stopping at users who already reached v.
+type referralIndexCache struct {
+	store           storage.Storage
+	zeroTargetValid bool
+
+	once sync.Once
+	mu   sync.RWMutex
+	// reach maps each referrer to the users reachable through their referrals.
+	reach map[int]map[int]bool
+	// referredBy maps each referred user to the users who directly referred them.
+	referredBy map[int]map[int]bool
+}
+
+func newReferralIndexCache(store storage.Storage, zeroTargetValid bool) *referralIndexCache {
+	return &referralIndexCache{store: store, zeroTargetValid: zeroTargetValid}
+}
+
+// index returns a copy of the current referral index. A referrer on a referral cycle
+// reaches themselves, but is not counted, as in computeReferralIndex.
+func (c *referralIndexCache) index() types.ReferralIndex {
+	c.once.Do(c.start)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	index := make(types.ReferralIndex, len(c.reach))
+	for user, reachable := range c.reach {
+		index[user] = len(reachable)
+		if reachable[user] {
+			index[user]--
+		}
+	}
+
+	return index
+}
+
+// start subscribes to the storage and builds the index from its actions. Subscribing
+// first means no referral is missed; one seen both in the actions and as an event is
+// harmlessly added twice.
+func (c *referralIndexCache) start() {
+	c.store.Subscribe(c.observe)
+	c.rebuild()
+}
+
+// observe updates the index for a storage mutation.
+func (c *referralIndexCache) observe(event types.StorageEvent) {
+	switch event.Type {
+	case types.EventActionCreated:
+		if target, ok := event.Action.ReferralTarget(c.zeroTargetValid); ok {
+			c.mu.Lock()
+			c.addReferral(event.Action.UserID, target)
+			c.mu.Unlock()
+		}
+	case types.EventUserCreated, types.EventUserUpdated, types.EventActionsResorted:
+		// The referral graph is unchanged.
+	case types.EventActionDeleted:
+		// Removing a referral can cut off users reached only through it, which the
+		// incremental path cannot undo.
+		c.rebuild()
+	default:
+		// Mutations the incremental path does not understand, e.g. removed actions,
+		// fall back to a full rebuild.
+		c.rebuild()
+	}
+}
+
+// rebuild replaces the index with one built from all actions. The actions are read
+// under the lock, so events for mutations after the read are applied on top.
+func (c *referralIndexCache) rebuild() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reach = make(map[int]map[int]bool)
+	c.referredBy = make(map[int]map[int]bool)
+	for _, action := range c.store.GetActions() {
+		if target, ok := action.ReferralTarget(c.zeroTargetValid); ok {
+			c.addReferral(action.UserID, target)
+		}
+	}
+}
+
+// addReferral adds the referral from referrer to referred. The caller must hold the
+// write lock.
+func (c *referralIndexCache) addReferral(referrer, referred int) {
+	if c.referredBy[referred] == nil {
+		c.referredBy[referred] = make(map[int]bool)
+	}
+	c.referredBy[referred][referrer] = true
+
+	// Everything reachable from the referred user, copied as the referred user's own
+	// set changes below when the referral closes a cycle.
+	added := map[int]bool{referred: true}
+	for user := range c.reach[referred] {
+		added[user] = true
+	}
+
+	// Walk up from the referrer to everyone who reaches them. Users who already reach
+	// the referred user reach everything added, and so do the users above them.
+	queue := []int{referrer}
+	seen := map[int]bool{referrer: true}
+	for len(queue) > 0 {
+		user := queue[0]
+		queue = queue[1:]
+		if c.reach[user][referred] {
+			continue
+		}
+
+		if c.reach[user] == nil {
+			c.reach[user] = make(map[int]bool, len(added))
+		}
+		for reachable := range added {
+			c.reach[user][reachable] = true
+		}
+
+		for ancestor := range c.referredBy[user] {
+			if !seen[ancestor] {
+				seen[ancestor] = true
+				queue = append(queue, ancestor)
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klemis/user-actions-api/storage"
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// referralStorage returns a storage with users 1 to n and the given referrals, each a
+// pair of referrer and referred user.
+func referralStorage(n int, referrals [][2]int) *storage.InMemoryStorage {
+	createdAt := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+
+	users := make(map[int]types.User, n)
+	for id := 1; id <= n; id++ {
+		users[id] = types.User{ID: id, Name: "User", CreatedAt: createdAt}
+	}
+	actions := make([]types.Action, len(referrals))
+	for i, referral := range referrals {
+		actions[i] = types.Action{ID: i + 1, UserID: referral[0], Type: types.ActionReferUser, TargetUser: targetUser(referral[1]), CreatedAt: createdAt}
+	}
+
+	return storage.NewInMemoryStorageFromData(users, actions)
+}
+
+// createReferral creates a referral action in the storage.
+func createReferral(t *testing.T, store storage.Storage, referrer, referred int) {
+	_, err := store.CreateAction(types.Action{UserID: referrer, Type: types.ActionReferUser, TargetUser: targetUser(referred), CreatedAt: time.Now().UTC()})
+	assert.NoError(t, err)
+}
+
+// assertMatchesFullRecompute checks the cached index against a full recompute over
+// the stored actions.
+func assertMatchesFullRecompute(t *testing.T, store storage.Storage, cache *referralIndexCache) {
+	expected, err := computeReferralIndex(buildReferrals(store.GetActions(), cache.zeroTargetValid), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, cache.index())
+}
+
+func TestReferralIndexCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		initial  [][2]int
+		created  [][2]int
+		expected types.ReferralIndex
+	}{
+		{
+			name:     "Extending a chain",
+			initial:  [][2]int{{1, 2}, {2, 3}},
+			created:  [][2]int{{3, 4}, {4, 5}},
+			expected: types.ReferralIndex{1: 4, 2: 3, 3: 2, 4: 1},
+		},
+		{
+			// 1 → 2 → 3 and 4 → 5 → 6 become one chain through 3 → 4.
+			name:     "Merging two subtrees",
+			initial:  [][2]int{{1, 2}, {2, 3}, {4, 5}, {5, 6}},
+			created:  [][2]int{{3, 4}},
+			expected: types.ReferralIndex{1: 5, 2: 4, 3: 3, 4: 2, 5: 1},
+		},
+		{
+			// User 4 is reachable from 1 through both 2 and 3, and counts once.
+			name:     "Merging subtrees sharing users",
+			initial:  [][2]int{{1, 2}, {2, 4}, {3, 4}, {4, 5}},
+			created:  [][2]int{{1, 3}},
+			expected: types.ReferralIndex{1: 4, 2: 2, 3: 2, 4: 1},
+		},
+		{
+			name:     "Closing a cycle",
+			initial:  [][2]int{{1, 2}, {2, 3}},
+			created:  [][2]int{{3, 1}},
+			expected: types.ReferralIndex{1: 2, 2: 2, 3: 2},
+		},
+		{
+			name:     "Repeated referral",
+			initial:  [][2]int{{1, 2}, {2, 3}},
+			created:  [][2]int{{1, 2}, {1, 3}},
+			expected: types.ReferralIndex{1: 2, 2: 1},
+		},
+		{
+			name:     "New referrer",
+			created:  [][2]int{{2, 3}, {1, 2}},
+			expected: types.ReferralIndex{1: 2, 2: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			store := referralStorage(6, tt.initial)
+			cache := newReferralIndexCache(store, false)
+			assertMatchesFullRecompute(t, store, cache)
+
+			for _, referral := range tt.created {
+				createReferral(t, store, referral[0], referral[1])
+				assertMatchesFullRecompute(t, store, cache)
+			}
+			assert.Equal(t, tt.expected, cache.index())
+		})
+	}
+}
+
+// TestReferralIndexCacheRandom grows random referral graphs, full of merges and
+// cycles, and checks the cache against a full recompute after every referral.
+func TestReferralIndexCacheRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for graph := 0; graph < 10; graph++ {
+		store := referralStorage(30, nil)
+		cache := newReferralIndexCache(store, false)
+
+		for i := 0; i < 60; i++ {
+			createReferral(t, store, rng.Intn(30)+1, rng.Intn(30)+1)
+			assertMatchesFullRecompute(t, store, cache)
+		}
+	}
+}
+
+// TestHandleGetLiveReferralIndex tests the handleGetLiveReferralIndex endpoint.
+func TestHandleGetLiveReferralIndex(t *testing.T) {
+	store := referralStorage(4, nil)
+	server := &Server{store: store, referrals: newReferralIndexCache(store, false)}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/users/referrals/live", server.handleGetLiveReferralIndex)
+
+	serve := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/users/referrals/live", nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	response := serve()
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	assert.JSONEq(t, `{"error": "No referrals found", "code": "NO_REFERRALS"}`, response.Body.String())
+
+	// Referrals created after the first request are reflected.
+	createReferral(t, store, 2, 3)
+	createReferral(t, store, 1, 2)
+
+	response = serve()
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"1": 2, "2": 1}`, response.Body.String())
+}
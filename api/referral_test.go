@@ -0,0 +1,1126 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// targetUser returns a pointer to the ID, for setting Action.TargetUser.
+func targetUser(id int) *int {
+	return &id
+}
+
+// referralChain returns a chain of n referrals: user 1 refers 2, 2 refers 3, and so on.
+func referralChain(n int) []types.Action {
+	actions := make([]types.Action, 0, n)
+	for i := 1; i <= n; i++ {
+		actions = append(actions, types.Action{ID: i, UserID: i, Type: types.ActionReferUser, TargetUser: targetUser(i + 1)})
+	}
+	return actions
+}
+
+func TestComputeReferralIndexLimit(t *testing.T) {
+	// Every user in the chain reaches all users after them, so the full traversal
+	// visits n*(n+1)/2 users.
+	referrals := buildReferrals(referralChain(1000), false)
+
+	tests := []struct {
+		name      string
+		maxVisits int
+		expectErr bool
+	}{
+		{name: "No limit", maxVisits: 0, expectErr: false},
+		{name: "Limit above total visits", maxVisits: 500500, expectErr: false},
+		{name: "Tight limit", maxVisits: 1000, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			index, err := computeReferralIndex(referrals, tt.maxVisits)
+
+			if tt.expectErr {
+				assert.ErrorIs(t, err, errTraversalLimit)
+				assert.Nil(t, index)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Len(t, index, 1000)
+			assert.Equal(t, 1000, index[1])
+			assert.Equal(t, 1, index[1000])
+		})
+	}
+}
+
+// TestHandleGetReferralIndexLimit tests that the endpoint gives up on a graph that
+// exceeds the traversal limit.
+func TestHandleGetReferralIndexLimit(t *testing.T) {
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore, cfg: Config{MaxReferralVisits: 1000}}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/users/referal-index", server.handleGetReferralIndex)
+
+	mockStore.On("GetActions").Return(referralChain(1000))
+
+	req, _ := http.NewRequest("GET", "/users/referal-index", nil)
+	response := httptest.NewRecorder()
+
+	router.ServeHTTP(response, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.JSONEq(t, `{"error": "Referral graph too large to compute the referral index", "code": "REFERRAL_LIMIT_EXCEEDED"}`, response.Body.String())
+}
+
+// TestHandleGetUsersAboveReferralIndex tests the handleGetUsersAboveReferralIndex endpoint.
+func TestHandleGetUsersAboveReferralIndex(t *testing.T) {
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/users/referrals/above", server.handleGetUsersAboveReferralIndex)
+
+	// Referral index: {"1": 4, "2": 2, "3": 1, "6": 2}.
+	mockStore.On("GetActions").Return([]types.Action{
+		{ID: 1, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(2)},
+		{ID: 2, UserID: 2, Type: "REFER_USER", TargetUser: targetUser(3)},
+		{ID: 3, UserID: 3, Type: "REFER_USER", TargetUser: targetUser(4)},
+		{ID: 4, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(5)},
+		{ID: 5, UserID: 6, Type: "REFER_USER", TargetUser: targetUser(7)},
+		{ID: 6, UserID: 6, Type: "REFER_USER", TargetUser: targetUser(8)},
+	})
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "At boundary",
+			query:          "?min=2",
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"userId": 1, "referralIndex": 4},
+				{"userId": 2, "referralIndex": 2},
+				{"userId": 6, "referralIndex": 2}
+			]`,
+		},
+		{
+			name:           "Below boundary",
+			query:          "?min=1",
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"userId": 1, "referralIndex": 4},
+				{"userId": 2, "referralIndex": 2},
+				{"userId": 6, "referralIndex": 2},
+				{"userId": 3, "referralIndex": 1}
+			]`,
+		},
+		{
+			name:           "Above every index",
+			query:          "?min=5",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			name:           "Invalid minimum",
+			query:          "?min=abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid minimum referral index", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/users/referrals/above"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetReferralTrees tests the handleGetReferralTrees endpoint.
+func TestHandleGetReferralTrees(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		extraActions   []types.Action
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Two roots",
+			body:           `{"userIds": [1, 3]}`,
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"userId": 1, "referrals": [
+					{"userId": 2, "referrals": [
+						{"userId": 3, "referrals": [
+							{"userId": 4, "referrals": []}
+						]}
+					]},
+					{"userId": 5, "referrals": []}
+				]},
+				{"userId": 3, "referrals": [
+					{"userId": 4, "referrals": []}
+				]}
+			]`,
+		},
+		{
+			name:           "Shared max depth",
+			body:           `{"userIds": [1, 2], "maxDepth": 1}`,
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"userId": 1, "referrals": [
+					{"userId": 2, "referrals": []},
+					{"userId": 5, "referrals": []}
+				]},
+				{"userId": 2, "referrals": [
+					{"userId": 3, "referrals": []}
+				]}
+			]`,
+		},
+		{
+			name: "Cycle is cut",
+			body: `{"userIds": [4]}`,
+			extraActions: []types.Action{
+				{ID: 5, UserID: 4, Type: types.ActionReferUser, TargetUser: targetUser(1)},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"userId": 4, "referrals": [
+					{"userId": 1, "referrals": [
+						{"userId": 2, "referrals": [
+							{"userId": 3, "referrals": []}
+						]},
+						{"userId": 5, "referrals": []}
+					]}
+				]}
+			]`,
+		},
+		{
+			name:           "No user IDs",
+			body:           `{"userIds": []}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "At least one user ID is required", "code": "INVALID_REQUEST_BODY"}`,
+		},
+		{
+			name:           "Too many user IDs",
+			body:           `{"userIds": [` + strings.Repeat("1, ", maxReferralTreeRoots) + `1]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Too many user IDs, at most 100 are allowed", "code": "INVALID_REQUEST_BODY"}`,
+		},
+		{
+			name:           "Negative max depth",
+			body:           `{"userIds": [1], "maxDepth": -1}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid maximum depth", "code": "INVALID_REQUEST_BODY"}`,
+		},
+		{
+			name:           "Malformed body",
+			body:           `{"userIds": "1"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid request body", "code": "INVALID_REQUEST_BODY"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.POST("/users/referral-trees", server.handleGetReferralTrees)
+
+			// The sample graph from TestHandleGetReferralIndex.
+			actions := []types.Action{
+				{ID: 1, UserID: 1, Type: types.ActionReferUser, TargetUser: targetUser(2)},
+				{ID: 2, UserID: 2, Type: types.ActionReferUser, TargetUser: targetUser(3)},
+				{ID: 3, UserID: 3, Type: types.ActionReferUser, TargetUser: targetUser(4)},
+				{ID: 4, UserID: 1, Type: types.ActionReferUser, TargetUser: targetUser(5)},
+			}
+			mockStore.On("GetActions").Return(append(actions, tt.extraActions...))
+
+			req, _ := http.NewRequest("POST", "/users/referral-trees", strings.NewReader(tt.body))
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetReferralIndexTimeRange compares referral indices computed within a
+// time range against the all-time index.
+func TestHandleGetReferralIndexTimeRange(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2021, time.July, d, 12, 0, 0, 0, time.UTC)
+	}
+
+	// All-time index: {"1": 4, "2": 2, "3": 1}.
+	actions := []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionReferUser, TargetUser: targetUser(2), CreatedAt: day(1)},
+		{ID: 2, UserID: 2, Type: types.ActionReferUser, TargetUser: targetUser(3), CreatedAt: day(2)},
+		{ID: 3, UserID: 3, Type: types.ActionReferUser, TargetUser: targetUser(4), CreatedAt: day(3)},
+		{ID: 4, UserID: 1, Type: types.ActionReferUser, TargetUser: targetUser(5), CreatedAt: day(4)},
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "All time",
+			query:          "",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 4, "2": 2, "3": 1}`,
+		},
+		{
+			name:           "Range covering everything",
+			query:          "?from=2021-07-01T00:00:00Z&to=2021-08-01T00:00:00Z",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 4, "2": 2, "3": 1}`,
+		},
+		{
+			// Without the first referral, user 1 only reaches user 5.
+			name:           "From only",
+			query:          "?from=2021-07-02T00:00:00Z",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 1, "2": 2, "3": 1}`,
+		},
+		{
+			// The end of the range is exclusive.
+			name:           "To only",
+			query:          "?to=2021-07-03T12:00:00Z",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 2, "2": 1}`,
+		},
+		{
+			name:           "Range without referrals",
+			query:          "?from=2021-08-01T00:00:00Z",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "No referrals found", "code": "NO_REFERRALS"}`,
+		},
+		{
+			name:           "Invalid from",
+			query:          "?from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid from timestamp", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Invalid to",
+			query:          "?to=2021-07-01",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid to timestamp", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Range ending before it starts",
+			query:          "?from=2021-07-03T00:00:00Z&to=2021-07-02T00:00:00Z",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid time range", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetActions").Return(actions)
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/users/referal-index", server.handleGetReferralIndex)
+
+			req, _ := http.NewRequest("GET", "/users/referal-index"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetReferralConversion tests the handleGetReferralConversion endpoint.
+func TestHandleGetReferralConversion(t *testing.T) {
+	tests := []struct {
+		name           string
+		mockActions    []types.Action
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			// Users 2 and 3 have actions of their own, users 4 and 5 do not.
+			name: "Sample referrals",
+			mockActions: []types.Action{
+				{ID: 1, UserID: 1, Type: types.ActionReferUser, TargetUser: targetUser(2)},
+				{ID: 2, UserID: 2, Type: types.ActionReferUser, TargetUser: targetUser(3)},
+				{ID: 3, UserID: 3, Type: types.ActionReferUser, TargetUser: targetUser(4)},
+				{ID: 4, UserID: 1, Type: types.ActionReferUser, TargetUser: targetUser(5)},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"referredUsers": 4, "activeUsers": 2, "rate": 0.5}`,
+		},
+		{
+			// A user referred twice is counted once.
+			name: "Repeated referral",
+			mockActions: []types.Action{
+				{ID: 1, UserID: 1, Type: types.ActionReferUser, TargetUser: targetUser(2)},
+				{ID: 2, UserID: 3, Type: types.ActionReferUser, TargetUser: targetUser(2)},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"referredUsers": 1, "activeUsers": 0, "rate": 0}`,
+		},
+		{
+			name: "No referrals",
+			mockActions: []types.Action{
+				{ID: 1, UserID: 1, Type: types.ActionWelcome},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"referredUsers": 0, "activeUsers": 0, "rate": 0}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetActions").Return(tt.mockActions)
+			counts := make(map[int]int)
+			for _, action := range tt.mockActions {
+				counts[action.UserID]++
+			}
+			for userID := 1; userID <= 5; userID++ {
+				mockStore.On("CountActionsByUserID", userID).Return(counts[userID])
+			}
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/metrics/referral-conversion", server.handleGetReferralConversion)
+
+			req, _ := http.NewRequest("GET", "/metrics/referral-conversion", nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetReferralDetail tests the handleGetReferralDetail endpoint.
+func TestHandleGetReferralDetail(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2021, time.July, d, 12, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name           string
+		userID         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Referrer",
+			userID:         "1",
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"userId": 2, "actionId": 2, "referredAt": "2021-07-02T12:00:00Z", "active": true},
+				{"userId": 5, "actionId": 4, "referredAt": "2021-07-04T12:00:00Z", "active": false}
+			]`,
+		},
+		{
+			name:           "Non-referrer",
+			userID:         "2",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			name:           "Unknown user",
+			userID:         "9",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			name:           "Invalid user ID",
+			userID:         "abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid user ID", "code": "INVALID_USER_ID"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetUserActions", 1).Return([]types.Action{
+				{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: day(1)},
+				{ID: 2, UserID: 1, Type: types.ActionReferUser, TargetUser: targetUser(2), CreatedAt: day(2)},
+				{ID: 3, UserID: 1, Type: types.ActionAddContact, CreatedAt: day(3)},
+				{ID: 4, UserID: 1, Type: types.ActionReferUser, TargetUser: targetUser(5), CreatedAt: day(4)},
+			})
+			mockStore.On("GetUserActions", 2).Return([]types.Action{
+				{ID: 5, UserID: 2, Type: types.ActionWelcome, CreatedAt: day(3)},
+			})
+			mockStore.On("GetUserActions", 9).Return([]types.Action{})
+			mockStore.On("CountActionsByUserID", 2).Return(1)
+			mockStore.On("CountActionsByUserID", 5).Return(0)
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/users/:id/referrals/detail", server.handleGetReferralDetail)
+
+			req, _ := http.NewRequest("GET", "/users/"+tt.userID+"/referrals/detail", nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetReferralIndexEmptyAs200 tests both modes of reporting an empty referral index.
+func TestHandleGetReferralIndexEmptyAs200(t *testing.T) {
+	noReferrals := []types.Action{{ID: 1, UserID: 1, Type: types.ActionWelcome}}
+
+	tests := []struct {
+		name           string
+		cfg            Config
+		query          string
+		mockActions    []types.Action
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "No actions, 404 by default",
+			mockActions:    []types.Action{},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "No actions found", "code": "NO_ACTIONS"}`,
+		},
+		{
+			name:           "No referrals, 404 by default",
+			mockActions:    noReferrals,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "No referrals found", "code": "NO_REFERRALS"}`,
+		},
+		{
+			name:           "No actions, 200 by query",
+			query:          "?emptyAs200=true",
+			mockActions:    []types.Action{},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{}`,
+		},
+		{
+			name:           "No referrals, 200 by query",
+			query:          "?emptyAs200=true",
+			mockActions:    noReferrals,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{}`,
+		},
+		{
+			name:           "No referrals, 200 by config",
+			cfg:            Config{EmptyReferralIndexAs200: true},
+			mockActions:    noReferrals,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{}`,
+		},
+		{
+			name:           "No referrals, 404 by query despite config",
+			cfg:            Config{EmptyReferralIndexAs200: true},
+			query:          "?emptyAs200=false",
+			mockActions:    noReferrals,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "No referrals found", "code": "NO_REFERRALS"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetActions").Return(tt.mockActions)
+			server := &Server{store: mockStore, cfg: tt.cfg}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/users/referal-index", server.handleGetReferralIndex)
+
+			req, _ := http.NewRequest("GET", "/users/referal-index"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetReferralIndexExclude compares the full referral index against the index
+// with a user removed from the graph.
+func TestHandleGetReferralIndexExclude(t *testing.T) {
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/users/referrals/index", server.handleGetReferralIndex)
+
+	// 1 -> 2 -> 3, 1 -> 4, 5 -> 3 and 6 -> 2.
+	mockStore.On("GetActions").Return([]types.Action{
+		{ID: 1, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(2)},
+		{ID: 2, UserID: 2, Type: "REFER_USER", TargetUser: targetUser(3)},
+		{ID: 3, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(4)},
+		{ID: 4, UserID: 5, Type: "REFER_USER", TargetUser: targetUser(3)},
+		{ID: 5, UserID: 6, Type: "REFER_USER", TargetUser: targetUser(2)},
+	})
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Full index",
+			query:          "",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 3, "2": 1, "5": 1, "6": 2}`,
+		},
+		{
+			// User 3 is only reachable through user 2, so user 1 loses both and user 6
+			// loses everything. User 5 referred 3 directly and is unaffected.
+			name:           "Exclude a referrer",
+			query:          "?exclude=2",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 1, "5": 1}`,
+		},
+		{
+			name:           "Exclude a leaf",
+			query:          "?exclude=3",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 2, "6": 1}`,
+		},
+		{
+			name:           "Exclude a user outside the graph",
+			query:          "?exclude=99",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 3, "2": 1, "5": 1, "6": 2}`,
+		},
+		{
+			name:           "Invalid user ID",
+			query:          "?exclude=abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid user ID to exclude", "code": "INVALID_USER_ID"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/users/referrals/index"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetReferralFanout tests the handleGetReferralFanout endpoint.
+func TestHandleGetReferralFanout(t *testing.T) {
+	tests := []struct {
+		name         string
+		actions      []types.Action
+		expectedBody string
+	}{
+		{
+			name: "Varying fan-outs",
+			actions: []types.Action{
+				// User 1 referred three users, users 2 and 3 one each and user 4 two.
+				{ID: 1, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(2)},
+				{ID: 2, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(3)},
+				{ID: 3, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(4)},
+				{ID: 4, UserID: 2, Type: "REFER_USER", TargetUser: targetUser(5)},
+				{ID: 5, UserID: 3, Type: "REFER_USER", TargetUser: targetUser(6)},
+				{ID: 6, UserID: 4, Type: "REFER_USER", TargetUser: targetUser(7)},
+				{ID: 7, UserID: 4, Type: "REFER_USER", TargetUser: targetUser(8)},
+				// Referring the same user again does not widen the fan-out.
+				{ID: 8, UserID: 4, Type: "REFER_USER", TargetUser: targetUser(8)},
+				{ID: 9, UserID: 5, Type: "ADD_CONTACT"},
+			},
+			expectedBody: `{"1": 2, "2": 1, "3": 1}`,
+		},
+		{
+			name: "No referrals",
+			actions: []types.Action{
+				{ID: 1, UserID: 1, Type: "WELCOME"},
+			},
+			expectedBody: `{}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetActions").Return(tt.actions)
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/users/referrals/fanout", server.handleGetReferralFanout)
+
+			req, _ := http.NewRequest("GET", "/users/referrals/fanout", nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, http.StatusOK, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetReferralEdges tests the handleGetReferralEdges endpoint.
+func TestHandleGetReferralEdges(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2021, time.July, d, 12, 0, 0, 0, time.UTC)
+	}
+
+	// The actions are ordered by user, as the storage returns them.
+	actions := []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionReferUser, TargetUser: targetUser(2), CreatedAt: day(1)},
+		{ID: 4, UserID: 1, Type: types.ActionReferUser, TargetUser: targetUser(5), CreatedAt: day(4)},
+		{ID: 5, UserID: 1, Type: types.ActionAddContact, CreatedAt: day(5)},
+		{ID: 2, UserID: 2, Type: types.ActionReferUser, TargetUser: targetUser(3), CreatedAt: day(2)},
+		{ID: 3, UserID: 3, Type: types.ActionReferUser, TargetUser: targetUser(4), CreatedAt: day(3)},
+		// Referring the same user again is a separate edge.
+		{ID: 6, UserID: 3, Type: types.ActionReferUser, TargetUser: targetUser(4), CreatedAt: day(6)},
+	}
+
+	tests := []struct {
+		name           string
+		actions        []types.Action
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "All edges in time order",
+			actions:        actions,
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"from": 1, "to": 2, "at": "2021-07-01T12:00:00Z"},
+				{"from": 2, "to": 3, "at": "2021-07-02T12:00:00Z"},
+				{"from": 3, "to": 4, "at": "2021-07-03T12:00:00Z"},
+				{"from": 1, "to": 5, "at": "2021-07-04T12:00:00Z"},
+				{"from": 3, "to": 4, "at": "2021-07-06T12:00:00Z"}
+			]`,
+		},
+		{
+			// The end of the range is exclusive.
+			name:           "Time range",
+			actions:        actions,
+			query:          "?from=2021-07-02T00:00:00Z&to=2021-07-04T12:00:00Z",
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"from": 2, "to": 3, "at": "2021-07-02T12:00:00Z"},
+				{"from": 3, "to": 4, "at": "2021-07-03T12:00:00Z"}
+			]`,
+		},
+		{
+			name:           "Range without referrals",
+			actions:        actions,
+			query:          "?from=2021-08-01T00:00:00Z",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			name: "No referrals",
+			actions: []types.Action{
+				{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: day(1)},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			name:           "Invalid from",
+			actions:        actions,
+			query:          "?from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid from timestamp", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetActions").Return(tt.actions)
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/users/referrals/edges", server.handleGetReferralEdges)
+
+			req, _ := http.NewRequest("GET", "/users/referrals/edges"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetReferralIndexIncludeZero tests the includeZero option of the
+// handleGetReferralIndex endpoint.
+func TestHandleGetReferralIndexIncludeZero(t *testing.T) {
+	// Users 3 and 5 were only referred, user 4 referred nobody and was not referred.
+	referralActions := []types.Action{
+		{ID: 1, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(2)},
+		{ID: 2, UserID: 2, Type: "REFER_USER", TargetUser: targetUser(3)},
+		{ID: 3, UserID: 2, Type: "REFER_USER", TargetUser: targetUser(5)},
+		{ID: 4, UserID: 4, Type: "ADD_CONTACT"},
+	}
+
+	tests := []struct {
+		name           string
+		actions        []types.Action
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Every user appears",
+			actions:        referralActions,
+			query:          "?includeZero=true",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 3, "2": 2, "3": 0, "4": 0, "5": 0}`,
+		},
+		{
+			name:           "Unchanged without the flag",
+			actions:        referralActions,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 3, "2": 2}`,
+		},
+		{
+			name:           "Unchanged with the flag off",
+			actions:        referralActions,
+			query:          "?includeZero=false",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 3, "2": 2}`,
+		},
+		{
+			name: "No referrals",
+			actions: []types.Action{
+				{ID: 1, UserID: 1, Type: "WELCOME"},
+			},
+			query:          "?includeZero=true",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 0, "2": 0, "3": 0, "4": 0, "5": 0}`,
+		},
+		{
+			name: "No referrals without the flag",
+			actions: []types.Action{
+				{ID: 1, UserID: 1, Type: "WELCOME"},
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "No referrals found", "code": "NO_REFERRALS"}`,
+		},
+		{
+			name:           "Invalid includeZero flag",
+			actions:        referralActions,
+			query:          "?includeZero=maybe",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid includeZero flag", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetActions").Return(tt.actions)
+			mockStore.On("UserIDs").Return([]int{1, 2, 3, 4, 5})
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/users/referral-index", server.handleGetReferralIndex)
+
+			req, _ := http.NewRequest("GET", "/users/referral-index"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// referralPairs returns a REFER_USER action for each [referrer, referred] pair.
+func referralPairs(pairs ...[2]int) []types.Action {
+	actions := make([]types.Action, 0, len(pairs))
+	for i, pair := range pairs {
+		actions = append(actions, types.Action{ID: i + 1, UserID: pair[0], Type: types.ActionReferUser, TargetUser: targetUser(pair[1])})
+	}
+	return actions
+}
+
+// TestHandleGetReferralIndexCycles tests the counting of users on referral cycles and
+// the reportCycles option of the handleGetReferralIndex endpoint.
+func TestHandleGetReferralIndexCycles(t *testing.T) {
+	tests := []struct {
+		name           string
+		actions        []types.Action
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			// Each user reaches the other and themselves, but only the other counts.
+			name:           "Two-user cycle",
+			actions:        referralPairs([2]int{1, 2}, [2]int{2, 1}),
+			query:          "?reportCycles=true",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"index": {"1": 1, "2": 1}, "cycles": [[1, 2], [2, 1]]}`,
+		},
+		{
+			// 2 → 3 → 4 → 2 is a cycle, 1 → 2 leads into it and 4 → 5 out of it.
+			name:           "Longer cycle",
+			actions:        referralPairs([2]int{1, 2}, [2]int{2, 3}, [2]int{3, 4}, [2]int{4, 2}, [2]int{4, 5}),
+			query:          "?reportCycles=true",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"index": {"1": 4, "2": 3, "3": 3, "4": 3}, "cycles": [[2, 3], [3, 4], [4, 2]]}`,
+		},
+		{
+			name:           "Longer cycle without reporting",
+			actions:        referralPairs([2]int{1, 2}, [2]int{2, 3}, [2]int{3, 4}, [2]int{4, 2}, [2]int{4, 5}),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 4, "2": 3, "3": 3, "4": 3}`,
+		},
+		{
+			name:           "Self-referral",
+			actions:        referralPairs([2]int{1, 1}, [2]int{1, 2}, [2]int{1, 1}),
+			query:          "?reportCycles=true",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"index": {"1": 1}, "cycles": [[1, 1]]}`,
+		},
+		{
+			name:           "No cycles",
+			actions:        referralPairs([2]int{1, 2}, [2]int{2, 3}, [2]int{1, 3}),
+			query:          "?reportCycles=true",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"index": {"1": 2, "2": 1}, "cycles": []}`,
+		},
+		{
+			name:           "Combined with expand",
+			actions:        referralPairs([2]int{1, 2}),
+			query:          "?reportCycles=true&expand=true",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "reportCycles cannot be combined with expand", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Invalid reportCycles flag",
+			actions:        referralPairs([2]int{1, 2}),
+			query:          "?reportCycles=maybe",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid reportCycles flag", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetActions").Return(tt.actions)
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/users/referral-index", server.handleGetReferralIndex)
+
+			req, _ := http.NewRequest("GET", "/users/referral-index"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetReferralIndexExpand tests the expand option of the
+// handleGetReferralIndex endpoint.
+func TestHandleGetReferralIndexExpand(t *testing.T) {
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/users/referal-index", server.handleGetReferralIndex)
+
+	// User 9 referred someone but is missing from the users.
+	mockStore.On("GetActions").Return([]types.Action{
+		{ID: 1, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(2)},
+		{ID: 2, UserID: 2, Type: "REFER_USER", TargetUser: targetUser(3)},
+		{ID: 3, UserID: 9, Type: "REFER_USER", TargetUser: targetUser(4)},
+	})
+	mockStore.On("GetUser", 1).Return(&types.User{ID: 1, Name: "Alice"})
+	mockStore.On("GetUser", 2).Return(&types.User{ID: 2, Name: "Bob"})
+	mockStore.On("GetUser", 9).Return(nil)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Expanded with names",
+			query:          "?expand=true",
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"1": {"name": "Alice", "referralIndex": 2},
+				"2": {"name": "Bob", "referralIndex": 1},
+				"9": {"name": null, "referralIndex": 1}
+			}`,
+		},
+		{
+			name:           "Bare by default",
+			query:          "",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 2, "2": 1, "9": 1}`,
+		},
+		{
+			name:           "Invalid expand flag",
+			query:          "?expand=maybe",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid expand flag", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/users/referal-index"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetReferralIndexZeroTarget tests that referrals to user 0 are only counted
+// when Config.ZeroTargetUserValid is set, and referrals without a target never are.
+func TestHandleGetReferralIndexZeroTarget(t *testing.T) {
+	// 1 -> 0 -> 2 and 3 -> 4, plus a referral by user 5 without a target.
+	actions := []types.Action{
+		{ID: 1, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(0)},
+		{ID: 2, UserID: 0, Type: "REFER_USER", TargetUser: targetUser(2)},
+		{ID: 3, UserID: 3, Type: "REFER_USER", TargetUser: targetUser(4)},
+		{ID: 4, UserID: 5, Type: "REFER_USER"},
+	}
+
+	tests := []struct {
+		name            string
+		zeroTargetValid bool
+		expectedBody    string
+	}{
+		{
+			name:            "Zero target treated as absent",
+			zeroTargetValid: false,
+			expectedBody:    `{"0": 1, "3": 1}`,
+		},
+		{
+			name:            "Zero target counted",
+			zeroTargetValid: true,
+			expectedBody:    `{"0": 1, "1": 2, "3": 1}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			server := &Server{store: mockStore, cfg: Config{ZeroTargetUserValid: tt.zeroTargetValid}}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/users/referral-index", server.handleGetReferralIndex)
+
+			mockStore.On("GetActions").Return(actions)
+
+			req, _ := http.NewRequest("GET", "/users/referral-index", nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, http.StatusOK, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klemis/user-actions-api/storage"
+	"github.com/klemis/user-actions-api/types"
+)
+
+// requestStartKey is the context key holding the time a request was received.
+const requestStartKey = "requestStart"
+
+// envelope wraps a successful response body together with its metadata.
+type envelope struct {
+	Data any  `json:"data"`
+	Meta meta `json:"meta"`
+}
+
+// errorEnvelope is the error counterpart of envelope.
+type errorEnvelope struct {
+	types.APIError
+	Meta meta `json:"meta"`
+}
+
+// meta describes the data a response was computed from.
+type meta struct {
+	Version    uint64    `json:"version"`
+	DurationMs float64   `json:"durationMs"`
+	Page       *pageMeta `json:"page,omitempty"`
+}
+
+// pageMeta describes a page of a paginated list.
+type pageMeta struct {
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// requestStart records when the request was received so responses can report timing.
+func requestStart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(requestStartKey, time.Now())
+		c.Next()
+	}
+}
+
+// respond writes a successful response, wrapping it in an envelope when requested.
+func (s *Server) respond(c *gin.Context, status int, obj any) {
+	if !s.wantsEnvelope(c) {
+		c.JSON(status, obj)
+		return
+	}
+
+	c.JSON(status, envelope{Data: obj, Meta: s.meta(c)})
+}
+
+// respondPage writes a page of a list of total items, adding the page to the envelope
+// metadata when the response is wrapped.
+func (s *Server) respondPage(c *gin.Context, items any, p page, total int) {
+	if !s.wantsEnvelope(c) {
+		c.JSON(http.StatusOK, items)
+		return
+	}
+
+	m := s.meta(c)
+	m.Page = &pageMeta{Total: total, Limit: p.limit, Offset: p.offset}
+	c.JSON(http.StatusOK, envelope{Data: items, Meta: m})
+}
+
+// respondError writes an error response with the code and message, wrapping it in an
+// envelope when requested.
+func (s *Server) respondError(c *gin.Context, status int, code types.ErrorCode, message string) {
+	apiErr := types.APIError{Code: code, Message: message}
+	if !s.wantsEnvelope(c) {
+		c.JSON(status, apiErr)
+		return
+	}
+
+	c.JSON(status, errorEnvelope{APIError: apiErr, Meta: s.meta(c)})
+}
+
+// respondStorageError writes the response for a failed storage mutation: 405 when the
+// storage is read-only, 500 otherwise.
+func (s *Server) respondStorageError(c *gin.Context, err error) {
+	if errors.Is(err, storage.ErrReadOnly) {
+		s.respondError(c, http.StatusMethodNotAllowed, types.CodeReadOnly, "Server is read-only")
+		return
+	}
+
+	s.respondError(c, http.StatusInternalServerError, types.CodeInternal, "Internal server error")
+}
+
+// wantsEnvelope reports whether the response should be wrapped. The ?envelope query
+// parameter takes precedence over the configured default.
+func (s *Server) wantsEnvelope(c *gin.Context) bool {
+	if value, ok := c.GetQuery("envelope"); ok {
+		if envelope, err := strconv.ParseBool(value); err == nil {
+			return envelope
+		}
+	}
+
+	return s.config().EnvelopeResponses
+}
+
+// emptyAs200 reports whether an empty referral index should be returned as 200 rather
+// than 404. The ?emptyAs200 query parameter takes precedence over the configured default.
+func (s *Server) emptyAs200(c *gin.Context) bool {
+	if value, ok := c.GetQuery("emptyAs200"); ok {
+		if emptyAs200, err := strconv.ParseBool(value); err == nil {
+			return emptyAs200
+		}
+	}
+
+	return s.config().EmptyReferralIndexAs200
+}
+
+// meta builds the envelope metadata for the current request.
+func (s *Server) meta(c *gin.Context) meta {
+	m := meta{Version: s.store.Version()}
+	if start := c.GetTime(requestStartKey); !start.IsZero() {
+		m.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+	}
+
+	return m
+}
+
+// respondWithETag writes a single resource with an ETag derived from its content,
+// or 304 Not Modified when the client already holds the current version.
+func (s *Server) respondWithETag(c *gin.Context, obj any) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, types.CodeInternal, "Failed to encode response")
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Header("ETag", etag)
+
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	s.respond(c, http.StatusOK, obj)
+}
+
+// etagMatches reports whether an If-None-Match header value matches the ETag.
+// Weak validators compare equal to their strong counterparts.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestResponseEnvelope tests wrapped and unwrapped responses.
+func TestResponseEnvelope(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		cfg            Config
+		path           string
+		expectedStatus int
+		expectedBody   string
+		expectEnvelope bool
+	}{
+		{
+			name:           "Bare response by default",
+			path:           "/users/2",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id": 2, "name": "Alice", "createdAt": "2021-07-04T12:47:09.888Z"}`,
+		},
+		{
+			name:           "Envelope requested by query",
+			path:           "/users/2?envelope=true",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"data": {"id": 2, "name": "Alice", "createdAt": "2021-07-04T12:47:09.888Z"}}`,
+			expectEnvelope: true,
+		},
+		{
+			name:           "Envelope enabled by config",
+			cfg:            Config{EnvelopeResponses: true},
+			path:           "/users/2",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"data": {"id": 2, "name": "Alice", "createdAt": "2021-07-04T12:47:09.888Z"}}`,
+			expectEnvelope: true,
+		},
+		{
+			name:           "Envelope disabled by query despite config",
+			cfg:            Config{EnvelopeResponses: true},
+			path:           "/users/2?envelope=false",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id": 2, "name": "Alice", "createdAt": "2021-07-04T12:47:09.888Z"}`,
+		},
+		{
+			name:           "Bare error by default",
+			path:           "/users/55",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "User not found", "code": "USER_NOT_FOUND"}`,
+		},
+		{
+			name:           "Error envelope",
+			path:           "/users/55?envelope=true",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "User not found", "code": "USER_NOT_FOUND"}`,
+			expectEnvelope: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetUser", 2).Return(&types.User{ID: 2, Name: "Alice", CreatedAt: mockTime})
+			mockStore.On("GetUser", 55).Return(nil)
+			mockStore.On("Version").Return(uint64(7))
+
+			gin.SetMode(gin.TestMode)
+			server := NewServer("", mockStore, tt.cfg)
+
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			response := httptest.NewRecorder()
+
+			server.router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			var body map[string]any
+			if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			if !tt.expectEnvelope {
+				assert.NotContains(t, body, "meta")
+				assert.JSONEq(t, tt.expectedBody, response.Body.String())
+				return
+			}
+
+			// Compare everything except the timing, which varies between runs.
+			assert.Contains(t, body, "meta")
+			meta := body["meta"].(map[string]any)
+			assert.Equal(t, float64(7), meta["version"])
+			assert.Contains(t, meta, "durationMs")
+
+			delete(body, "meta")
+			stripped, _ := json.Marshal(body)
+			assert.JSONEq(t, tt.expectedBody, string(stripped))
+		})
+	}
+}
+
+// TestConditionalGetUser tests the 200-then-304 flow for a single user.
+func TestConditionalGetUser(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	mockStore := &MockStorage{}
+	mockStore.On("GetUser", 2).Return(&types.User{ID: 2, Name: "Alice", CreatedAt: mockTime}).Once()
+	mockStore.On("GetUser", 2).Return(&types.User{ID: 2, Name: "Alice", CreatedAt: mockTime}).Once()
+	mockStore.On("GetUser", 2).Return(&types.User{ID: 2, Name: "Alicia", CreatedAt: mockTime}).Once()
+
+	gin.SetMode(gin.TestMode)
+	server := NewServer("", mockStore, Config{})
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/users/2", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		response := httptest.NewRecorder()
+
+		server.router.ServeHTTP(response, req)
+
+		return response
+	}
+
+	// The first request returns the user and its ETag.
+	first := get("")
+	assert.Equal(t, http.StatusOK, first.Code)
+	etag := first.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	// Repeating the request with the ETag returns 304 without a body.
+	second := get(etag)
+	assert.Equal(t, http.StatusNotModified, second.Code)
+	assert.Empty(t, second.Body.String())
+	assert.Equal(t, etag, second.Header().Get("ETag"))
+
+	// Once the user changes, the old ETag no longer matches.
+	third := get(etag)
+	assert.Equal(t, http.StatusOK, third.Code)
+	assert.NotEqual(t, etag, third.Header().Get("ETag"))
+	assert.JSONEq(t, `{"id": 2, "name": "Alicia", "createdAt": "2021-07-04T12:47:09.888Z"}`, third.Body.String())
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected bool
+	}{
+		{name: "Empty header", header: "", expected: false},
+		{name: "Exact match", header: `"abc"`, expected: true},
+		{name: "Weak match", header: `W/"abc"`, expected: true},
+		{name: "Match in list", header: `"xyz", "abc"`, expected: true},
+		{name: "Wildcard", header: `*`, expected: true},
+		{name: "No match", header: `"xyz"`, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			assert.Equal(t, tt.expected, etagMatches(tt.header, `"abc"`))
+		})
+	}
+}
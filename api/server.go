@@ -1,149 +1,1484 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"math"
+	"math/rand"
 	"net/http"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/klemis/user-actions-api/diagnostics"
 	"github.com/klemis/user-actions-api/storage"
 	"github.com/klemis/user-actions-api/types"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Server struct {
-	listenAddr string
+	httpServer *http.Server
 	router     *gin.Engine
 	store      storage.Storage
+	cfg        Config
+	// reloaded holds the config after the last Reload. Nil until then.
+	reloaded atomic.Pointer[Config]
+	reloadMu sync.Mutex
+	// referrals serves the live referral index.
+	referrals *referralIndexCache
+	// limiter holds the rate limiting state of each client.
+	limiter *rateLimiter
+	// ready reports whether the server should receive traffic, see SetReady.
+	ready atomic.Bool
 }
 
-func NewServer(listenAddr string, store storage.Storage) *Server {
-	return &Server{
-		listenAddr: listenAddr,
-		router:     gin.Default(),
-		store:      store,
+func NewServer(listenAddr string, store storage.Storage, cfg Config) *Server {
+	s := &Server{
+		router:    gin.New(),
+		store:     store,
+		cfg:       cfg,
+		referrals: newReferralIndexCache(store, cfg.ZeroTargetUserValid),
+		limiter:   newRateLimiter(),
 	}
+	s.httpServer = &http.Server{Addr: listenAddr, Handler: s.router}
+	s.registerRoutes()
+
+	return s
 }
 
-func (s *Server) Start() error {
+// registerRoutes sets up the middleware and routes served by the API.
+func (s *Server) registerRoutes() {
+	s.router.Use(requestStart(), requestID(), s.logRequests(gin.DefaultWriter), recordMetrics(), gin.Recovery())
+	if s.cfg.CompressMinSize > 0 {
+		s.router.Use(compress(s.cfg.CompressMinSize))
+	}
+	// Preflight requests carry no credentials, so they are answered before authentication.
+	if len(s.cfg.CORSOrigins) > 0 {
+		s.router.Use(allowOrigins(s.cfg.CORSOrigins))
+	}
+	// Rate limiting comes before authentication, so requests with a wrong key are limited
+	// too. It is always installed, as a reload can turn it on.
+	s.router.Use(s.limitRate(s.limiter))
+	if s.cfg.APIKey != "" {
+		s.router.Use(s.authenticate(s.cfg.APIKey))
+	}
+	if s.cfg.MaxConcurrentRequests > 0 {
+		s.router.Use(s.limitConcurrency(s.cfg.MaxConcurrentRequests))
+	}
+
+	// Expensive endpoints additionally share a concurrency limit per group.
+	analytics := s.limitGroup(GroupAnalytics)
+	export := s.limitGroup(GroupExport)
+
 	s.router.GET("/users/:id", s.handleGetUserByID)
-	s.router.GET("/users/referal-index", s.handleGetReferralIndex)
+	s.router.POST("/users", s.handleCreateUser)
+	s.router.PATCH("/users/:id", s.handlePatchUser)
+	s.router.GET("/users/referral-index", analytics, s.handleGetReferralIndex)
+	// The misspelled path is kept for existing clients.
+	s.router.GET("/users/referal-index", analytics, s.handleGetReferralIndex)
+	s.router.GET("/users/referrals/index", analytics, s.handleGetReferralIndex)
+	s.router.GET("/users/referrals/above", analytics, s.handleGetUsersAboveReferralIndex)
+	s.router.GET("/users/referrals/fanout", analytics, s.handleGetReferralFanout)
+	s.router.GET("/users/referrals/edges", export, s.handleGetReferralEdges)
+	s.router.GET("/users/referrals/live", s.handleGetLiveReferralIndex)
+	s.router.GET("/users/inactive", s.handleGetInactiveUsers)
+	s.router.GET("/users/newest", s.handleGetNewestUsers)
+	s.router.GET("/users/oldest", s.handleGetOldestUsers)
+	s.router.POST("/users/referral-trees", analytics, s.handleGetReferralTrees)
+	s.router.GET("/users/:id/actions", s.handleGetActionsByUserID)
 	s.router.GET("/users/:id/actions/count", s.handleGetActionCountByUserID)
-	s.router.GET("/actions/:type/next-probalility", s.handleGetNextActionProbability)
+	s.router.GET("/users/:id/actions/indexed", s.handleGetIndexedUserActions)
+	s.router.GET("/users/:id/referrals/detail", s.handleGetReferralDetail)
+	s.router.GET("/users/:id/profile", analytics, s.handleGetUserProfile)
+	s.router.GET("/users/:id/velocity", analytics, s.handleGetUserVelocity)
+	s.router.GET("/users/:id/actions/cadence", s.handleGetUserCadence)
+	s.router.GET("/users/:id/next-probability-for", analytics, s.handleGetUserNextActionProbability)
+	// Routes under /actions share the :type wildcard name, as gin requires for a path
+	// segment, so the single action route reads its ID from it.
+	s.router.GET("/actions/:type", s.handleGetActionByID)
+	s.router.GET("/actions/:type/next-probability", analytics, s.handleGetNextActionProbability)
+	s.router.GET("/actions/:type/expected-next", analytics, s.handleGetExpectedNextAction)
+	s.router.GET("/actions/:type/alternatives", analytics, s.handleGetNextActionAlternatives)
+	s.router.GET("/actions/:type/gap-stats", analytics, s.handleGetGapStats)
+	s.router.GET("/actions/:type/count", s.handleGetActionCountByType)
+	s.router.GET("/actions/next-probability", analytics, s.handleGetConditionalNextActionProbability)
+	s.router.GET("/actions/sample", s.handleGetActionsSample)
+	s.router.GET("/actions/first", analytics, s.handleGetFirstActionTypes)
+	s.router.GET("/actions/recent", s.handleGetRecentActions)
+	s.router.GET("/actions/compare-next", analytics, s.handleCompareNextActions)
+	s.router.GET("/actions/transition-graph", analytics, s.handleGetTransitionGraph)
+	s.router.GET("/actions/transition-matrix", analytics, s.handleGetTransitionMatrix)
+	s.router.GET("/actions/common-sequences", analytics, s.handleGetCommonSequences)
+	s.router.POST("/actions/funnel", analytics, s.handleGetFunnel)
+	s.router.GET("/actions/type-share", analytics, s.handleGetTypeShare)
+	s.router.GET("/actions/entropy", analytics, s.handleGetTransitionEntropy)
+	s.router.GET("/actions/self-targeting", s.handleGetSelfTargetingActions)
+	s.router.GET("/actions", s.handleGetActions)
+	s.router.POST("/actions", s.handleCreateAction)
+	s.router.DELETE("/actions/:id", s.handleDeleteAction)
+	s.router.POST("/actions/batch-get", s.handleBatchGetActions)
+	s.router.GET("/stats", s.handleGetStats)
+	s.router.GET("/export/timelines", export, s.handleExportTimelines)
+	s.router.GET("/actions.csv", export, s.handleExportActionsCSV)
+	s.router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	s.router.GET("/healthz", s.handleHealthz)
+	s.router.GET("/readyz", s.handleReadyz)
+	s.router.GET("/metrics/referral-conversion", analytics, s.handleGetReferralConversion)
+	s.router.GET("/admin/debug/insert-position", s.handleGetInsertPosition)
+	s.router.POST("/admin/resort", s.handleResort)
+	s.router.GET("/admin/consistency", s.handleCheckConsistency)
+}
+
+// Start serves the API until the server is shut down. After Shutdown it returns
+// http.ErrServerClosed.
+func (s *Server) Start() error {
+	return s.httpServer.ListenAndServe()
+}
 
-	return s.router.Run(s.listenAddr)
+// SetReady marks the server as ready to receive traffic, once its storage has loaded,
+// or as no longer ready, e.g. when shutting down. A new server is not ready.
+func (s *Server) SetReady(ready bool) {
+	s.ready.Store(ready)
+}
+
+// Shutdown stops the server from accepting new connections and waits for the requests
+// in flight to complete, or for ctx to be done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
 }
 
 // handleGetUserByID handles getting a user
 func (s *Server) handleGetUserByID(c *gin.Context) {
 	userID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidUserID, "Invalid user ID")
 		return
 	}
 
 	// Retrieve user data from the store.
 	user := s.store.GetUser(userID)
 	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		s.respondError(c, http.StatusNotFound, types.CodeUserNotFound, "User not found")
+		return
+	}
+
+	s.respondWithETag(c, user)
+}
+
+// handleCreateUser handles creating a user. The ID is assigned unless the body gives
+// one, and createdAt is always the current time.
+func (s *Server) handleCreateUser(c *gin.Context) {
+	var request types.CreateUserRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Invalid request body")
+		return
+	}
+	if request.Name == "" {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Name must not be empty")
+		return
+	}
+
+	user := types.User{Name: request.Name, CreatedAt: time.Now().UTC()}
+	if request.ID != nil {
+		// A zero ID would ask the storage to assign one.
+		if *request.ID <= 0 {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidUserID, "Invalid user ID")
+			return
+		}
+		user.ID = *request.ID
+	}
+
+	created, err := s.store.CreateUser(user)
+	if errors.Is(err, storage.ErrUserExists) {
+		s.respondError(c, http.StatusConflict, types.CodeUserExists, "User already exists")
+		return
+	}
+	if err != nil {
+		s.respondStorageError(c, err)
+		return
+	}
+
+	s.respond(c, http.StatusCreated, created)
+}
+
+// immutableUserFields are the user fields a patch may not change.
+var immutableUserFields = []string{"id", "createdAt"}
+
+// handlePatchUser handles partially updating a user. Only the fields present in the
+// body are changed.
+func (s *Server) handlePatchUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidUserID, "Invalid user ID")
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Invalid request body")
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Invalid request body")
+		return
+	}
+	for _, field := range immutableUserFields {
+		if _, exists := fields[field]; exists {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Field "+field+" cannot be changed")
+			return
+		}
+	}
+
+	var patch types.UserPatch
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Invalid request body")
+		return
+	}
+	if patch.Name != nil && *patch.Name == "" {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Name must not be empty")
+		return
+	}
+
+	user, err := s.store.UpdateUser(userID, patch)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		s.respondError(c, http.StatusNotFound, types.CodeUserNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		s.respondStorageError(c, err)
+		return
+	}
+
+	s.respond(c, http.StatusOK, user)
+}
+
+// profileTopActionTypes is the number of most frequent action types in a user profile.
+const profileTopActionTypes = 3
+
+// handleGetUserProfile handles getting a user together with statistics derived from
+// their actions, saving clients the round trips to assemble it.
+func (s *Server) handleGetUserProfile(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidUserID, "Invalid user ID")
+		return
+	}
+
+	user := s.store.GetUser(userID)
+	if user == nil {
+		s.respondError(c, http.StatusNotFound, types.CodeUserNotFound, "User not found")
+		return
+	}
+
+	referralIndex, err := computeReferralIndex(buildReferrals(s.store.GetActions(), s.cfg.ZeroTargetUserValid), s.config().MaxReferralVisits)
+	if errors.Is(err, errTraversalLimit) {
+		s.respondError(c, http.StatusServiceUnavailable, types.CodeReferralLimitExceeded, "Referral graph too large to compute the referral index")
+		return
+	}
+
+	// The user's actions are ordered by createdAt.
+	actions := s.store.GetUserActions(userID)
+	profile := types.UserProfile{
+		User:           *user,
+		ActionCount:    s.store.CountActionsByUserID(userID),
+		TopActionTypes: topActionTypes(actions, profileTopActionTypes),
+		ReferralIndex:  referralIndex[userID],
+	}
+	if len(actions) > 0 {
+		profile.FirstActivity = &actions[0].CreatedAt
+		profile.LastActivity = &actions[len(actions)-1].CreatedAt
+	}
+
+	s.respond(c, http.StatusOK, profile)
+}
+
+// defaultVelocityWindowDays is the velocity window used when ?windowDays= is not given.
+const defaultVelocityWindowDays = 7
+
+// handleGetUserVelocity handles getting the number of actions per day a user performed
+// over the trailing ?windowDays= days. The window ends at the newest action in the data
+// rather than now, so the result does not depend on when the data was loaded.
+func (s *Server) handleGetUserVelocity(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidUserID, "Invalid user ID")
+		return
+	}
+
+	windowDays, err := strconv.Atoi(c.DefaultQuery("windowDays", strconv.Itoa(defaultVelocityWindowDays)))
+	if err != nil || windowDays < 1 {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid window")
+		return
+	}
+
+	if s.store.GetUser(userID) == nil {
+		s.respondError(c, http.StatusNotFound, types.CodeUserNotFound, "User not found")
+		return
+	}
+
+	windowEnd := newestActionTime(s.store.GetActions())
+	count := countWithin(s.store.GetUserActions(userID), windowEnd, time.Duration(windowDays)*24*time.Hour)
+
+	s.respond(c, http.StatusOK, types.UserVelocity{
+		UserID:        userID,
+		WindowDays:    windowDays,
+		WindowEnd:     windowEnd,
+		Actions:       count,
+		ActionsPerDay: float64(count) / float64(windowDays),
+	})
+}
+
+// handleGetUserCadence handles getting the distribution of the time between a user's
+// consecutive actions.
+func (s *Server) handleGetUserCadence(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidUserID, "Invalid user ID")
+		return
+	}
+
+	if s.store.GetUser(userID) == nil {
+		s.respondError(c, http.StatusNotFound, types.CodeUserNotFound, "User not found")
+		return
+	}
+
+	stats := gapStats(consecutiveActions(s.store.GetUserActions(userID)))
+	s.respond(c, http.StatusOK, types.UserCadence{UserID: userID, GapStats: stats, Formatted: formatGapStats(stats)})
+}
+
+// handleGetUserNextActionProbability handles getting the probability that the user's
+// next action has the ?type= type, applying the global next-action probabilities to the
+// type of the user's last action.
+func (s *Server) handleGetUserNextActionProbability(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidUserID, "Invalid user ID")
+		return
+	}
+
+	actionType, ok := s.parseQueriedActionType(c, c.Query("type"))
+	if !ok {
+		return
+	}
+	mode, ok := s.parseRoundingMode(c)
+	if !ok {
+		return
+	}
+
+	if s.store.GetUser(userID) == nil {
+		s.respondError(c, http.StatusNotFound, types.CodeUserNotFound, "User not found")
+		return
+	}
+
+	result := types.UserNextActionProbability{UserID: userID, Type: actionType}
+
+	// The user's actions are ordered by createdAt.
+	if actions := s.store.GetUserActions(userID); len(actions) > 0 {
+		last := actions[len(actions)-1].Type
+		probability := roundProbability(nextActionProbability(groupedByUser(s.store.GetActions()), last)[actionType], mode)
+		result.LastActionType = &last
+		result.Probability = &probability
+	}
+
+	s.respond(c, http.StatusOK, result)
+}
+
+// handleGetActionByID handles getting an action.
+func (s *Server) handleGetActionByID(c *gin.Context) {
+	actionID, err := strconv.Atoi(c.Param("type"))
+	if err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidActionID, "Invalid action ID")
+		return
+	}
+
+	action := s.store.GetAction(actionID)
+	if action == nil {
+		s.respondError(c, http.StatusNotFound, types.CodeActionNotFound, "Action not found")
+		return
+	}
+
+	s.respondWithETag(c, action)
+}
+
+// handleDeleteAction handles deleting an action, e.g. to correct bad data.
+func (s *Server) handleDeleteAction(c *gin.Context) {
+	actionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidActionID, "Invalid action ID")
+		return
+	}
+
+	err = s.store.DeleteAction(actionID)
+	if errors.Is(err, storage.ErrActionNotFound) {
+		s.respondError(c, http.StatusNotFound, types.CodeActionNotFound, "Action not found")
+		return
+	}
+	if err != nil {
+		s.respondStorageError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// maxBatchGetActions caps the number of actions a single batch request can ask for.
+const maxBatchGetActions = 100
+
+// handleBatchGetActions handles getting several actions by ID at once. The response
+// lists the actions in the order requested, with null in place of missing ones.
+func (s *Server) handleBatchGetActions(c *gin.Context) {
+	var request types.ActionsBatchGetRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Invalid request body")
+		return
+	}
+	if len(request.IDs) == 0 {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "At least one action ID is required")
+		return
+	}
+	if len(request.IDs) > maxBatchGetActions {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Too many action IDs, at most "+strconv.Itoa(maxBatchGetActions)+" are allowed")
+		return
+	}
+
+	actions := make([]*types.Action, 0, len(request.IDs))
+	for _, id := range request.IDs {
+		actions = append(actions, s.store.GetAction(id))
+	}
+
+	s.respond(c, http.StatusOK, actions)
+}
+
+// handleGetActions handles listing the actions of all users, as a global audit feed.
+// They are ordered by user and then createdAt by default, like the storage, or by
+// ?sort= and ?order=. The older ?orderBy=time is still accepted and orders them by
+// createdAt. ?from= and ?to= restrict the feed to a time range.
+func (s *Server) handleGetActions(c *gin.Context) {
+	orderBy, byTime := c.GetQuery("orderBy")
+	if byTime && orderBy != "time" {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid orderBy, expected time")
+		return
+	}
+
+	sortBy := c.Query("sort")
+	switch {
+	case sortBy != "":
+	case byTime:
+		sortBy = "createdAt"
+	default:
+		sortBy = "userId"
+	}
+	compare, ok := actionOrders[sortBy]
+	if !ok {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid sort, expected createdAt, userId or id")
+		return
+	}
+
+	var descending bool
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		descending = true
+	default:
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid order, expected asc or desc")
+		return
+	}
+
+	within, ok := s.parseTimeRange(c)
+	if !ok {
+		return
+	}
+
+	// Unlike the other lists, the feed rejects limits above the maximum instead of
+	// clamping them.
+	if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > maxPageLimit {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid limit")
+		return
+	}
+	p, ok := s.parsePage(c)
+	if !ok {
+		return
+	}
+
+	// The storage keeps a time-ordered index, so the time order needs no sorting.
+	if !within.bounded() && sortBy == "createdAt" && !descending {
+		s.respondPage(c, s.store.ActionsByTime(p.offset, p.limit), p, s.store.Stats().Actions)
+		return
+	}
+
+	var actions []types.Action
+	if within.bounded() {
+		actions = s.store.GetActionsBetween(within.from, within.to)
+	} else {
+		actions = s.store.GetActions()
+	}
+
+	switch {
+	case descending:
+		slices.SortFunc(actions, func(a, b types.Action) int { return compare(b, a) })
+	case sortBy == "userId" && !within.bounded():
+		// All actions come in the storage order already.
+	case sortBy != "createdAt":
+		slices.SortFunc(actions, compare)
+	}
+
+	s.respondPage(c, paginate(actions, p), p, len(actions))
+}
+
+// handleCreateAction handles creating an action for an existing user. The storage
+// assigns its ID, and createdAt defaults to the current time. Types other than the
+// well-known ones are rejected unless Config.AllowUnknownActionTypes is set.
+func (s *Server) handleCreateAction(c *gin.Context) {
+	var request types.CreateActionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Invalid request body")
+		return
+	}
+
+	actionType, ok := s.parseActionType(c, string(request.Type))
+	if !ok {
+		return
+	}
+	if !actionType.Valid() && !s.cfg.AllowUnknownActionTypes {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidActionType, "Unknown action type")
+		return
+	}
+	if s.store.GetUser(request.UserID) == nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeUserNotFound, "User does not exist")
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	createdAt := time.Now().UTC()
+	if request.CreatedAt != nil {
+		createdAt = *request.CreatedAt
+	}
+
+	action, err := s.store.CreateAction(types.Action{
+		Type:       actionType,
+		UserID:     request.UserID,
+		TargetUser: request.TargetUser,
+		CreatedAt:  createdAt,
+		Metadata:   request.Metadata,
+		Source:     types.SourceAPI,
+	})
+	if err != nil {
+		s.respondStorageError(c, err)
+		return
+	}
+
+	s.respond(c, http.StatusCreated, action)
 }
 
-// handleGetActionCountByUserID handles getting the total number of actions for a given user ID.
+// handleGetActionCountByUserID handles getting the total number of actions for a given
+// user ID. ?from= and ?to= count only the actions within a time range.
 func (s *Server) handleGetActionCountByUserID(c *gin.Context) {
 	userID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidUserID, "Invalid user ID")
+		return
+	}
+
+	within, ok := s.parseTimeRange(c)
+	if !ok {
 		return
 	}
 
+	humanize := false
+	if value, ok := c.GetQuery("humanize"); ok {
+		if humanize, err = strconv.ParseBool(value); err != nil {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid humanize flag")
+			return
+		}
+	}
+
 	// Retrieve action count.
 	count := s.store.CountActionsByUserID(userID)
+	if within.bounded() {
+		count = len(within.filter(s.store.GetUserActions(userID)))
+	}
 
-	c.JSON(http.StatusOK, gin.H{"count": count})
+	if humanize {
+		s.respond(c, http.StatusOK, gin.H{"count": count, "formatted": humanizeCount(count)})
+		return
+	}
+	s.respond(c, http.StatusOK, gin.H{"count": count})
 }
 
-func (s *Server) handleGetNextActionProbability(c *gin.Context) {
-	actionType := c.Param("type")
-	if actionType == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Action type is required"})
+// handleGetActionCountByType handles getting the number of actions of a type across
+// all users. ?from= and ?to= count only the actions within a time range.
+func (s *Server) handleGetActionCountByType(c *gin.Context) {
+	actionType, ok := s.parseActionType(c, c.Param("type"))
+	if !ok {
 		return
 	}
 
-	// Retrieve all actions sorted by user and createdAt.
-	actions := s.store.GetActions()
+	within, ok := s.parseTimeRange(c)
+	if !ok {
+		return
+	}
 
-	actionCounts := make(map[string]int)
-	totalNextActions := 0
+	if !within.bounded() {
+		s.respond(c, http.StatusOK, gin.H{"count": s.store.CountActionsByType(actionType)})
+		return
+	}
 
-	// Count next actions after each specified action type.
-	for i := 0; i < len(actions)-1; i++ {
-		if actions[i].Type == actionType && actions[i].UserID == actions[i+1].UserID {
-			nextAction := actions[i+1].Type
-			actionCounts[nextAction]++
-			totalNextActions++
+	count := 0
+	for _, action := range s.store.GetActionsBetween(within.from, within.to) {
+		if action.Type == actionType {
+			count++
 		}
 	}
+	s.respond(c, http.StatusOK, gin.H{"count": count})
+}
+
+// handleGetActionsByUserID handles listing a page of a user's actions, ordered by
+// createdAt. ?from= and ?to= restrict the list to a time range.
+func (s *Server) handleGetActionsByUserID(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidUserID, "Invalid user ID")
+		return
+	}
+
+	within, ok := s.parseTimeRange(c)
+	if !ok {
+		return
+	}
+
+	p, ok := s.parsePage(c)
+	if !ok {
+		return
+	}
 
-	// Calculate probabilities.
-	var result = make(types.ActionsProbalibity)
-	for action, count := range actionCounts {
-		probability := float64(count) / float64(totalNextActions)
-		result[action] = math.Round(probability*100) / 100
+	if s.store.GetUser(userID) == nil {
+		s.respondError(c, http.StatusNotFound, types.CodeUserNotFound, "User not found")
+		return
 	}
 
-	c.JSON(http.StatusOK, result)
+	s.respond(c, http.StatusOK, paginate(within.filter(s.store.GetUserActions(userID)), p))
 }
 
-func (s *Server) handleGetReferralIndex(c *gin.Context) {
-	// Retrieve all actions.
-	actions := s.store.GetActions()
-	if len(actions) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No actions found"})
+// handleGetIndexedUserActions handles listing a user's actions in order, each with its
+// position in the user's timeline.
+func (s *Server) handleGetIndexedUserActions(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidUserID, "Invalid user ID")
+		return
+	}
+
+	actions := s.store.GetUserActions(userID)
+	indexed := make([]types.IndexedAction, len(actions))
+	for i, action := range actions {
+		indexed[i] = types.IndexedAction{Action: action, Position: i + 1}
+	}
+
+	s.respond(c, http.StatusOK, indexed)
+}
+
+// handleGetFirstActionTypes handles counting which action types users perform first,
+// most common first.
+func (s *Server) handleGetFirstActionTypes(c *gin.Context) {
+	first := firstActions(groupedByUser(s.store.GetActions()))
+
+	s.respond(c, http.StatusOK, topActionTypes(first, len(first)))
+}
+
+func (s *Server) handleGetNextActionProbability(c *gin.Context) {
+	actionType, ok := s.parseQueriedActionType(c, c.Param("type"))
+	if !ok {
+		return
+	}
+
+	mode, ok := s.parseRoundingMode(c)
+	if !ok {
 		return
 	}
 
-	// Create a mapping of users to the IDs of users they referred.
-	referrals := make(types.Referral)
-	for _, action := range actions {
-		if action.Type == "REFER_USER" && action.TargetUser != 0 {
-			referrals[action.UserID] = append(referrals[action.UserID], action.TargetUser)
+	explain := false
+	if value, ok := c.GetQuery("explain"); ok {
+		var err error
+		if explain, err = strconv.ParseBool(value); err != nil {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid explain flag")
+			return
 		}
 	}
 
-	if len(referrals) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No referrals found"})
+	strict := false
+	if value, ok := c.GetQuery("strict"); ok {
+		var err error
+		if strict, err = strconv.ParseBool(value); err != nil {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid strict flag")
+			return
+		}
+	}
+
+	asArray := false
+	switch c.DefaultQuery("as", "map") {
+	case "map":
+	case "array":
+		asArray = true
+	default:
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid format, expected map or array")
+		return
+	}
+	if asArray && explain {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "The array format cannot be combined with explain")
 		return
 	}
 
-	// Calculate referral index for each user.
-	referralIndex := make(types.ReferralIndex)
-	for userId := range referrals {
-		visited := make(map[int]bool)
-
-		var dfs func(int)
-		dfs = func(user int) {
-			if visited[user] {
-				return
-			}
+	detailed := false
+	if value, ok := c.GetQuery("detailed"); ok {
+		var err error
+		if detailed, err = strconv.ParseBool(value); err != nil {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid detailed flag")
+			return
+		}
+	}
+	if detailed && (asArray || explain) {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "The detailed format cannot be combined with the array format or explain")
+		return
+	}
 
-			visited[user] = true
-			// Traverse each referral made by the current user.
-			for _, referredUser := range referrals[user] {
-				dfs(referredUser)
-			}
+	// Retrieve all actions sorted by user and createdAt. Backends are not relied on to
+	// return them in that order.
+	actions := groupedByUser(s.store.GetActions())
 
-			referralIndex[userId]++
+	// Tell a type that never occurs apart from one that occurs but is never followed
+	// by another action, which yields an empty distribution as well. The header marks
+	// it in every format, while the body keeps the shape of the requested format; the
+	// default map format also says so in the body, as it always has.
+	if !actionTypeSeen(actions, actionType) {
+		if strict {
+			s.respondError(c, http.StatusNotFound, types.CodeActionTypeNotFound, "Action type not found")
+			return
 		}
-		// Start DFS on each referred user in the referrals list for userId.
-		for _, referredUser := range referrals[userId] {
-			dfs(referredUser)
+		c.Header("X-Action-Type-Seen", "false")
+		if !detailed && !asArray && !explain {
+			s.respond(c, http.StatusOK, gin.H{"typeSeen": false})
+			return
 		}
 	}
 
-	// TODO: display also users with 0 value?
+	if detailed {
+		s.respond(c, http.StatusOK, detailedProbabilities(nextActions(actions, actionType), mode))
+		return
+	}
+
+	result := roundProbabilities(nextActionProbability(actions, actionType), mode)
+	if explain {
+		s.respond(c, http.StatusOK, types.ExplainedActionsProbability{
+			Probabilities: result,
+			Transitions:   explainTransitions(nextActions(actions, actionType)),
+		})
+		return
+	}
+	if asArray {
+		s.respond(c, http.StatusOK, sortedProbabilities(result))
+		return
+	}
+
+	s.respond(c, http.StatusOK, result)
+}
+
+// handleGetConditionalNextActionProbability handles getting the next-action
+// distribution after an action of type current that directly followed an action of
+// type previous.
+func (s *Server) handleGetConditionalNextActionProbability(c *gin.Context) {
+	if c.Query("current") == "" || c.Query("previous") == "" {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidActionType, "Action types current and previous are required")
+		return
+	}
+	current, ok := s.parseQueriedActionType(c, c.Query("current"))
+	if !ok {
+		return
+	}
+	previous, ok := s.parseQueriedActionType(c, c.Query("previous"))
+	if !ok {
+		return
+	}
+	mode, ok := s.parseRoundingMode(c)
+	if !ok {
+		return
+	}
+
+	probabilities := conditionalNextActionProbability(groupedByUser(s.store.GetActions()), previous, current)
+
+	s.respond(c, http.StatusOK, roundProbabilities(probabilities, mode))
+}
+
+// handleGetNextActionAlternatives handles getting the top next actions after an action
+// type, most likely first, for recommendation fallbacks.
+func (s *Server) handleGetNextActionAlternatives(c *gin.Context) {
+	actionType, ok := s.parseQueriedActionType(c, c.Param("type"))
+	if !ok {
+		return
+	}
+
+	top, err := strconv.Atoi(c.DefaultQuery("top", "2"))
+	if err != nil || top < 1 {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid number of alternatives")
+		return
+	}
+	mode, ok := s.parseRoundingMode(c)
+	if !ok {
+		return
+	}
+
+	probabilities := roundProbabilities(nextActionProbability(groupedByUser(s.store.GetActions()), actionType), mode)
+	alternatives := sortedProbabilities(probabilities)
+	if len(alternatives) > top {
+		alternatives = alternatives[:top]
+	}
+
+	s.respond(c, http.StatusOK, alternatives)
+}
+
+// handleGetTransitionGraph handles getting the raw transition counts between action
+// types as an adjacency list.
+func (s *Server) handleGetTransitionGraph(c *gin.Context) {
+	counts := transitionCounts(groupedByUser(s.store.GetActions()))
+
+	s.respond(c, http.StatusOK, transitionEdges(counts))
+}
+
+// handleGetTransitionMatrix handles getting the probability of each action type
+// following each other one, the whole transition matrix of which
+// handleGetNextActionProbability serves one row. With ?minCount= rarely observed
+// transitions are dropped.
+func (s *Server) handleGetTransitionMatrix(c *gin.Context) {
+	minCount, err := strconv.Atoi(c.DefaultQuery("minCount", "1"))
+	if err != nil || minCount < 1 {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid minCount")
+		return
+	}
+
+	mode, ok := s.parseRoundingMode(c)
+	if !ok {
+		return
+	}
+
+	counts := transitionCounts(groupedByUser(s.store.GetActions()))
+	s.respond(c, http.StatusOK, transitionMatrix(counts, minCount, mode))
+}
+
+// handleGetCommonSequences handles listing the most frequent sequences of ?length=
+// consecutive action types performed by the same user, up to ?limit= of them.
+func (s *Server) handleGetCommonSequences(c *gin.Context) {
+	length, err := strconv.Atoi(c.DefaultQuery("length", "3"))
+	if err != nil || length < 2 || length > maxSequenceLength {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid length, expected 2 to 5")
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid limit")
+		return
+	}
+
+	s.respond(c, http.StatusOK, commonSequences(groupedByUser(s.store.GetActions()), length, limit))
+}
+
+// maxFunnelSteps caps the number of steps of a funnel.
+const maxFunnelSteps = 20
+
+// handleGetFunnel handles measuring how many users progress through an ordered funnel
+// of action types, and the conversion between its steps.
+func (s *Server) handleGetFunnel(c *gin.Context) {
+	var request types.FunnelRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Invalid request body")
+		return
+	}
+	if len(request.Steps) < 2 {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "At least two steps are required")
+		return
+	}
+	if len(request.Steps) > maxFunnelSteps {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Too many steps, at most "+strconv.Itoa(maxFunnelSteps)+" are allowed")
+		return
+	}
+	for _, step := range request.Steps {
+		if _, ok := s.parseActionType(c, string(step)); !ok {
+			return
+		}
+	}
+
+	mode, ok := s.parseRoundingMode(c)
+	if !ok {
+		return
+	}
+
+	s.respond(c, http.StatusOK, funnel(groupedByUser(s.store.GetActions()), request.Steps, mode))
+}
+
+// handleGetTransitionEntropy handles getting the entropy of the next-action
+// distribution of every action type, telling predictable types from varied ones.
+func (s *Server) handleGetTransitionEntropy(c *gin.Context) {
+	s.respond(c, http.StatusOK, transitionEntropy(groupedByUser(s.store.GetActions())))
+}
+
+// handleGetTypeShare handles getting the share of each action type per time bucket.
+func (s *Server) handleGetTypeShare(c *gin.Context) {
+	granularity := c.DefaultQuery("granularity", "day")
+	if _, ok := bucketStart(time.Time{}, granularity); !ok {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid granularity")
+		return
+	}
+
+	s.respond(c, http.StatusOK, typeShares(s.store.GetActions(), granularity))
+}
+
+// handleGetReferralIndex handles computing the referral index of every referrer. With
+// ?exclude= the given user is removed from the referral graph first, for measuring
+// their contribution, with ?expand=true each entry includes the referrer's name, and
+// with ?includeZero=true every user who referred nobody is listed with 0.
+func (s *Server) handleGetReferralIndex(c *gin.Context) {
+	within, ok := s.parseTimeRange(c)
+	if !ok {
+		return
+	}
+
+	expand := false
+	if value, ok := c.GetQuery("expand"); ok {
+		var err error
+		if expand, err = strconv.ParseBool(value); err != nil {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid expand flag")
+			return
+		}
+	}
+
+	includeZero := false
+	if value, ok := c.GetQuery("includeZero"); ok {
+		var err error
+		if includeZero, err = strconv.ParseBool(value); err != nil {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid includeZero flag")
+			return
+		}
+	}
+
+	reportCycles := false
+	if value, ok := c.GetQuery("reportCycles"); ok {
+		var err error
+		if reportCycles, err = strconv.ParseBool(value); err != nil {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid reportCycles flag")
+			return
+		}
+	}
+	if reportCycles && expand {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "reportCycles cannot be combined with expand")
+		return
+	}
+
+	// Retrieve all actions. With includeZero every user is listed, so there is always
+	// something to return.
+	actions := s.store.GetActions()
+	if len(actions) == 0 && !includeZero {
+		s.respondEmptyReferralIndex(c, types.CodeNoActions, "No actions found")
+		return
+	}
+
+	// Create a mapping of users to the IDs of users they referred within the range.
+	referrals := buildReferralsWithin(actions, within, s.cfg.ZeroTargetUserValid)
+	if value, ok := c.GetQuery("exclude"); ok {
+		excluded, err := strconv.Atoi(value)
+		if err != nil {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidUserID, "Invalid user ID to exclude")
+			return
+		}
+		referrals = withoutUser(referrals, excluded)
+	}
+	if len(referrals) == 0 && !includeZero {
+		s.respondEmptyReferralIndex(c, types.CodeNoReferrals, "No referrals found")
+		return
+	}
+
+	// Calculate referral index for each user.
+	referralIndex, err := computeReferralIndex(referrals, s.config().MaxReferralVisits)
+	if errors.Is(err, errTraversalLimit) {
+		s.respondError(c, http.StatusServiceUnavailable, types.CodeReferralLimitExceeded, "Referral graph too large to compute the referral index")
+		return
+	}
+
+	if includeZero {
+		for _, userID := range s.store.UserIDs() {
+			if _, ok := referralIndex[userID]; !ok {
+				referralIndex[userID] = 0
+			}
+		}
+	}
+
+	if expand {
+		s.respond(c, http.StatusOK, expandReferralIndex(referralIndex, s.store.GetUser))
+		return
+	}
+	if reportCycles {
+		s.respond(c, http.StatusOK, types.ReferralIndexWithCycles{Index: referralIndex, Cycles: referralCycles(referrals)})
+		return
+	}
+	s.respond(c, http.StatusOK, referralIndex)
+}
+
+// respondEmptyReferralIndex writes the response for a referral index with nothing in
+// it: a 404 with the given error by default, or an empty index when requested.
+func (s *Server) respondEmptyReferralIndex(c *gin.Context, code types.ErrorCode, message string) {
+	if s.emptyAs200(c) {
+		s.respond(c, http.StatusOK, types.ReferralIndex{})
+		return
+	}
+
+	s.respondError(c, http.StatusNotFound, code, message)
+}
+
+// handleGetUsersAboveReferralIndex handles listing the users whose referral index is
+// at least the given minimum, highest first.
+func (s *Server) handleGetUsersAboveReferralIndex(c *gin.Context) {
+	minIndex, err := strconv.Atoi(c.Query("min"))
+	if err != nil || minIndex < 0 {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid minimum referral index")
+		return
+	}
+
+	p, ok := s.parsePage(c)
+	if !ok {
+		return
+	}
+
+	referralIndex, err := computeReferralIndex(buildReferrals(s.store.GetActions(), s.cfg.ZeroTargetUserValid), s.config().MaxReferralVisits)
+	if errors.Is(err, errTraversalLimit) {
+		s.respondError(c, http.StatusServiceUnavailable, types.CodeReferralLimitExceeded, "Referral graph too large to compute the referral index")
+		return
+	}
+
+	s.respond(c, http.StatusOK, paginate(rankReferralIndex(referralIndex, minIndex), p))
+}
+
+// handleGetLiveReferralIndex handles getting the referral index of every referrer from
+// the incrementally maintained cache, for live leaderboards polling it frequently.
+func (s *Server) handleGetLiveReferralIndex(c *gin.Context) {
+	referralIndex := s.referrals.index()
+	if len(referralIndex) == 0 {
+		s.respondEmptyReferralIndex(c, types.CodeNoReferrals, "No referrals found")
+		return
+	}
+
+	s.respond(c, http.StatusOK, referralIndex)
+}
+
+// handleGetReferralEdges handles listing the raw edges of the referral graph, for
+// importing it elsewhere. ?from= and ?to= restrict the referrals to a time range.
+func (s *Server) handleGetReferralEdges(c *gin.Context) {
+	within, ok := s.parseTimeRange(c)
+	if !ok {
+		return
+	}
+
+	s.respond(c, http.StatusOK, referralEdges(s.store.GetActions(), within, s.cfg.ZeroTargetUserValid))
+}
+
+// handleGetReferralFanout handles getting the histogram of how many users each
+// referrer directly referred.
+func (s *Server) handleGetReferralFanout(c *gin.Context) {
+	s.respond(c, http.StatusOK, referralFanout(buildReferrals(s.store.GetActions(), s.cfg.ZeroTargetUserValid)))
+}
+
+// handleGetReferralDetail handles listing the users referred by a user, with when each
+// referral was made and whether the referred user became active, oldest first.
+func (s *Server) handleGetReferralDetail(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidUserID, "Invalid user ID")
+		return
+	}
+
+	details := []types.ReferralDetail{}
+	for _, action := range s.store.GetUserActions(userID) {
+		target, ok := action.ReferralTarget(s.cfg.ZeroTargetUserValid)
+		if !ok {
+			continue
+		}
+
+		details = append(details, types.ReferralDetail{
+			UserID:     target,
+			ActionID:   action.ID,
+			ReferredAt: action.CreatedAt,
+			Active:     s.store.CountActionsByUserID(target) > 0,
+		})
+	}
+
+	s.respond(c, http.StatusOK, details)
+}
+
+// handleGetReferralConversion handles getting the fraction of referred users who
+// performed at least one action.
+func (s *Server) handleGetReferralConversion(c *gin.Context) {
+	conversion := types.ReferralConversion{}
+	for userID := range referredUsers(s.store.GetActions(), s.cfg.ZeroTargetUserValid) {
+		conversion.ReferredUsers++
+		if s.store.CountActionsByUserID(userID) > 0 {
+			conversion.ActiveUsers++
+		}
+	}
+	if conversion.ReferredUsers > 0 {
+		conversion.Rate = float64(conversion.ActiveUsers) / float64(conversion.ReferredUsers)
+	}
+
+	s.respond(c, http.StatusOK, conversion)
+}
+
+// maxReferralTreeRoots caps the number of trees a single batch request can ask for.
+const maxReferralTreeRoots = 100
+
+// handleGetReferralTrees handles getting the referral trees of several users at once,
+// returned in the order the users were requested.
+func (s *Server) handleGetReferralTrees(c *gin.Context) {
+	var request types.ReferralTreesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Invalid request body")
+		return
+	}
+	if len(request.UserIDs) == 0 {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "At least one user ID is required")
+		return
+	}
+	if len(request.UserIDs) > maxReferralTreeRoots {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Too many user IDs, at most "+strconv.Itoa(maxReferralTreeRoots)+" are allowed")
+		return
+	}
+	if request.MaxDepth < 0 {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidRequestBody, "Invalid maximum depth")
+		return
+	}
+
+	referrals := buildReferrals(s.store.GetActions(), s.cfg.ZeroTargetUserValid)
+
+	trees := make([]*types.ReferralTree, 0, len(request.UserIDs))
+	for _, userID := range request.UserIDs {
+		trees = append(trees, referralTree(referrals, userID, request.MaxDepth))
+	}
+
+	s.respond(c, http.StatusOK, trees)
+}
+
+// handleGetInactiveUsers handles listing the users who have no actions at all.
+func (s *Server) handleGetInactiveUsers(c *gin.Context) {
+	p, ok := s.parsePage(c)
+	if !ok {
+		return
+	}
+
+	var inactive []int
+	for _, userID := range s.store.UserIDs() {
+		if s.store.CountActionsByUserID(userID) == 0 {
+			inactive = append(inactive, userID)
+		}
+	}
+
+	// Only the users on the requested page are looked up.
+	users := []types.User{}
+	for _, userID := range paginate(inactive, p) {
+		if user := s.store.GetUser(userID); user != nil {
+			users = append(users, *user)
+		}
+	}
+
+	s.respond(c, http.StatusOK, users)
+}
+
+// handleGetNewestUsers handles listing users by creation time, newest first.
+func (s *Server) handleGetNewestUsers(c *gin.Context) {
+	s.respondUsersBySignup(c, true)
+}
+
+// handleGetOldestUsers handles listing users by creation time, oldest first.
+func (s *Server) handleGetOldestUsers(c *gin.Context) {
+	s.respondUsersBySignup(c, false)
+}
+
+// respondUsersBySignup writes the requested page of users sorted by creation time.
+func (s *Server) respondUsersBySignup(c *gin.Context, newestFirst bool) {
+	p, ok := s.parsePage(c)
+	if !ok {
+		return
+	}
+
+	// Users are kept in a map, so the whole list is materialized to sort it.
+	users := []types.User{}
+	for _, userID := range s.store.UserIDs() {
+		if user := s.store.GetUser(userID); user != nil {
+			users = append(users, *user)
+		}
+	}
+	sortUsersBySignup(users, newestFirst)
+
+	s.respond(c, http.StatusOK, paginate(users, p))
+}
+
+// handleExportTimelines handles streaming the ordered action timeline of every user
+// with at least ?minActions= actions as JSON Lines, one user per line. Timelines are
+// fetched and written one user at a time, so the dataset is never buffered as a whole.
+func (s *Server) handleExportTimelines(c *gin.Context) {
+	minActions := 0
+	if value, exists := c.GetQuery("minActions"); exists {
+		var err error
+		if minActions, err = strconv.Atoi(value); err != nil || minActions < 0 {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid minimum action count")
+			return
+		}
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	for _, userID := range s.store.ActiveUserIDs() {
+		// Stop early once the client has gone away.
+		if c.Request.Context().Err() != nil {
+			return
+		}
+		if s.store.CountActionsByUserID(userID) < minActions {
+			continue
+		}
+
+		if err := encoder.Encode(userTimeline(userID, s.store.GetUserActions(userID))); err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// csvExportPageSize is the number of actions fetched from the storage at a time while
+// exporting them as CSV.
+const csvExportPageSize = 1000
+
+// handleExportActionsCSV handles streaming every action as CSV, ordered by createdAt and
+// then by ID. ?from= and ?to= restrict the export to a time range. Actions are fetched
+// and written a page at a time, so the dataset is never buffered as a whole.
+func (s *Server) handleExportActionsCSV(c *gin.Context) {
+	within, ok := s.parseTimeRange(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="actions.csv"`)
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"id", "type", "userId", "targetUser", "createdAt"})
+	// Pages continue after the last action exported, so actions created or deleted
+	// meanwhile do not shift the pages.
+	afterCreatedAt, afterID := within.from, math.MinInt
+	for {
+		// Stop early once the client has gone away.
+		if c.Request.Context().Err() != nil {
+			return
+		}
+
+		actions := s.store.ActionsByTimeAfter(afterCreatedAt, afterID, within.to, csvExportPageSize)
+		for _, action := range actions {
+			targetUser := ""
+			if action.TargetUser != nil {
+				targetUser = strconv.Itoa(*action.TargetUser)
+			}
+			writer.Write([]string{
+				strconv.Itoa(action.ID),
+				string(action.Type),
+				strconv.Itoa(action.UserID),
+				targetUser,
+				action.CreatedAt.Format(time.RFC3339Nano),
+			})
+		}
+
+		// Stop once a write fails, as the rest could not be delivered either.
+		writer.Flush()
+		if writer.Error() != nil || len(actions) < csvExportPageSize {
+			return
+		}
+		c.Writer.Flush()
+
+		last := actions[len(actions)-1]
+		afterCreatedAt, afterID = last.CreatedAt, last.ID
+	}
+}
+
+// handleGetSelfTargetingActions handles listing the actions whose target is the acting
+// user, a diagnostic for bugs in the source data.
+func (s *Server) handleGetSelfTargetingActions(c *gin.Context) {
+	s.respond(c, http.StatusOK, diagnostics.SelfTargeting(s.store.GetActions()))
+}
+
+// handleGetStats handles getting summary statistics about the loaded data.
+func (s *Server) handleGetStats(c *gin.Context) {
+	s.respond(c, http.StatusOK, s.store.Stats())
+}
+
+// handleHealthz handles the liveness probe, which succeeds as long as the server
+// answers. Probes are never wrapped in the response envelope.
+func (s *Server) handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReadyz handles the readiness probe, which succeeds only while the server is
+// marked ready, so load balancers route traffic to it once its data is loaded.
+func (s *Server) handleReadyz(c *gin.Context) {
+	if !s.ready.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleGetInsertPosition handles reporting where an action of the given user and
+// creation time would be inserted into the sorted actions, for debugging the ordering.
+// Nothing is inserted.
+func (s *Server) handleGetInsertPosition(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Query("userId"))
+	if err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidUserID, "Invalid user ID")
+		return
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, c.Query("createdAt"))
+	if err != nil {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid createdAt timestamp")
+		return
+	}
+
+	s.respond(c, http.StatusOK, gin.H{"index": s.store.InsertPosition(userID, createdAt)})
+}
+
+// handleResort handles restoring the order of the stored actions, reporting how many
+// of them moved.
+func (s *Server) handleResort(c *gin.Context) {
+	moved, err := s.store.Resort()
+	if err != nil {
+		s.respondStorageError(c, err)
+		return
+	}
+
+	s.respond(c, http.StatusOK, gin.H{"moved": moved})
+}
+
+// handleCheckConsistency handles checking the storage indices against a rebuild from
+// scratch. Drift is a bug, so it is reported with a 500 for monitoring to alert on.
+func (s *Server) handleCheckConsistency(c *gin.Context) {
+	report := s.store.CheckConsistency()
+	if !report.Consistent {
+		s.respond(c, http.StatusInternalServerError, report)
+		return
+	}
+
+	s.respond(c, http.StatusOK, report)
+}
+
+// handleGetExpectedNextAction handles getting the probability-weighted time until the
+// action following the given action type.
+func (s *Server) handleGetExpectedNextAction(c *gin.Context) {
+	actionType, ok := s.parseQueriedActionType(c, c.Param("type"))
+	if !ok {
+		return
+	}
+
+	transitions := nextActions(groupedByUser(s.store.GetActions()), actionType)
+
+	s.respond(c, http.StatusOK, expectedNextAction(transitions))
+}
+
+// handleGetGapStats handles getting the distribution of the time from actions of the
+// given type to the next action of the same user.
+func (s *Server) handleGetGapStats(c *gin.Context) {
+	actionType, ok := s.parseQueriedActionType(c, c.Param("type"))
+	if !ok {
+		return
+	}
+
+	s.respond(c, http.StatusOK, gapStats(nextActions(groupedByUser(s.store.GetActions()), actionType)))
+}
+
+// handleGetActionsSample handles getting a random sample of actions. The sample is
+// reproducible when a seed is given.
+func (s *Server) handleGetActionsSample(c *gin.Context) {
+	n, err := strconv.Atoi(c.DefaultQuery("n", "20"))
+	if err != nil || n < 1 {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid sample size")
+		return
+	}
+
+	seed := time.Now().UnixNano()
+	if value, ok := c.GetQuery("seed"); ok {
+		seed, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid seed")
+			return
+		}
+	}
+
+	actions := filterBySource(c, s.store.GetActions())
+	sample := sampleActions(actions, n, rand.New(rand.NewSource(seed)))
+
+	s.respond(c, http.StatusOK, sample)
+}
+
+// handleGetRecentActions handles getting the most recent actions across all users.
+func (s *Server) handleGetRecentActions(c *gin.Context) {
+	n, err := strconv.Atoi(c.DefaultQuery("n", "50"))
+	if err != nil || n < 1 {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidParameter, "Invalid number of actions")
+		return
+	}
+	n = min(n, maxPageLimit)
+
+	actions := filterBySource(c, s.store.GetActions())
+
+	s.respond(c, http.StatusOK, recentActions(actions, n))
+}
+
+// handleCompareNextActions handles comparing the distributions of actions following
+// two action types.
+func (s *Server) handleCompareNextActions(c *gin.Context) {
+	if c.Query("a") == "" || c.Query("b") == "" {
+		s.respondError(c, http.StatusBadRequest, types.CodeInvalidActionType, "Action types a and b are required")
+		return
+	}
+	a, ok := s.parseQueriedActionType(c, c.Query("a"))
+	if !ok {
+		return
+	}
+	b, ok := s.parseQueriedActionType(c, c.Query("b"))
+	if !ok {
+		return
+	}
+	mode, ok := s.parseRoundingMode(c)
+	if !ok {
+		return
+	}
+
+	actions := groupedByUser(s.store.GetActions())
+	distributionA := nextActionProbability(actions, a)
+	distributionB := nextActionProbability(actions, b)
+
+	result := types.ActionsComparison{
+		A: types.ActionDistribution{Type: a, Probabilities: roundProbabilities(distributionA, mode)},
+		B: types.ActionDistribution{Type: b, Probabilities: roundProbabilities(distributionB, mode)},
+	}
+	// The distance is undefined when either type has no transitions to compare.
+	if len(distributionA) > 0 && len(distributionB) > 0 {
+		distance := roundProbability(totalVariationDistance(distributionA, distributionB), mode)
+		result.TotalVariationDistance = &distance
+	}
 
-	c.JSON(http.StatusOK, referralIndex)
+	s.respond(c, http.StatusOK, result)
 }
@@ -1,13 +1,21 @@
 package api
 
 import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/klemis/user-actions-api/storage"
 	"github.com/klemis/user-actions-api/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -26,12 +34,49 @@ func (m *MockStorage) GetUser(id int) *types.User {
 	return nil
 }
 
+// CreateUser is a mocked method that stores a new user.
+func (m *MockStorage) CreateUser(user types.User) (*types.User, error) {
+	args := m.Called(user)
+	if created := args.Get(0); created != nil {
+		return created.(*types.User), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// UpdateUser is a mocked method that applies a partial update to a user.
+func (m *MockStorage) UpdateUser(id int, patch types.UserPatch) (*types.User, error) {
+	args := m.Called(id, patch)
+	if user := args.Get(0); user != nil {
+		return user.(*types.User), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// GetAction is a mocked method that retrieves an action by ID.
+func (m *MockStorage) GetAction(id int) *types.Action {
+	args := m.Called(id)
+	if action := args.Get(0); action != nil {
+		return action.(*types.Action)
+	}
+	return nil
+}
+
 // CountActionsByUserID is a mocked method that counts actions for a specific user ID.
 func (m *MockStorage) CountActionsByUserID(userID int) int {
 	args := m.Called(userID)
 	return args.Int(0)
 }
 
+func (m *MockStorage) DeleteAction(id int) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CountActionsByType(actionType types.ActionType) int {
+	args := m.Called(actionType)
+	return args.Int(0)
+}
+
 // GetActions is a mocked method that retrieves all actions.
 func (m *MockStorage) GetActions() []types.Action {
 	args := m.Called()
@@ -41,6 +86,106 @@ func (m *MockStorage) GetActions() []types.Action {
 	return nil
 }
 
+// Version is a mocked method that returns the data version.
+func (m *MockStorage) Version() uint64 {
+	args := m.Called()
+	return args.Get(0).(uint64)
+}
+
+// GetUserActions is a mocked method that retrieves the actions of a user.
+func (m *MockStorage) GetUserActions(userID int) []types.Action {
+	args := m.Called(userID)
+	if actions := args.Get(0); actions != nil {
+		return actions.([]types.Action)
+	}
+	return nil
+}
+
+// ActionsByTime is a mocked method that retrieves a page of actions in time order.
+func (m *MockStorage) ActionsByTime(offset, limit int) []types.Action {
+	args := m.Called(offset, limit)
+	if actions := args.Get(0); actions != nil {
+		return actions.([]types.Action)
+	}
+	return nil
+}
+
+// GetActionsBetween is a mocked method that retrieves the actions within a time range.
+func (m *MockStorage) GetActionsBetween(from, to time.Time) []types.Action {
+	args := m.Called(from, to)
+	if actions := args.Get(0); actions != nil {
+		return actions.([]types.Action)
+	}
+	return nil
+}
+
+// ActionsByTimeAfter is a mocked method that retrieves a page of actions in time order
+// after a cursor.
+func (m *MockStorage) ActionsByTimeAfter(createdAt time.Time, id int, to time.Time, limit int) []types.Action {
+	args := m.Called(createdAt, id, to, limit)
+	if actions := args.Get(0); actions != nil {
+		return actions.([]types.Action)
+	}
+	return nil
+}
+
+// ActiveUserIDs is a mocked method that retrieves the IDs of users with actions.
+func (m *MockStorage) ActiveUserIDs() []int {
+	args := m.Called()
+	if ids := args.Get(0); ids != nil {
+		return ids.([]int)
+	}
+	return nil
+}
+
+// UserIDs is a mocked method that retrieves the IDs of all users.
+func (m *MockStorage) UserIDs() []int {
+	args := m.Called()
+	if ids := args.Get(0); ids != nil {
+		return ids.([]int)
+	}
+	return nil
+}
+
+// CreateAction is a mocked method that stores a new action.
+func (m *MockStorage) CreateAction(action types.Action) (*types.Action, error) {
+	args := m.Called(action)
+	if created := args.Get(0); created != nil {
+		return created.(*types.Action), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// InsertPosition is a mocked method that returns where an action would be inserted.
+func (m *MockStorage) InsertPosition(userID int, createdAt time.Time) int {
+	args := m.Called(userID, createdAt)
+	return args.Int(0)
+}
+
+// Resort is a mocked method that restores the order of the actions.
+func (m *MockStorage) Resort() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
+// CheckConsistency is a mocked method that checks the storage indices.
+func (m *MockStorage) CheckConsistency() types.ConsistencyReport {
+	args := m.Called()
+	return args.Get(0).(types.ConsistencyReport)
+}
+
+// Subscribe is a mocked method that registers an observer of storage mutations.
+func (m *MockStorage) Subscribe(fn func(types.StorageEvent)) func() {
+	args := m.Called(fn)
+	return args.Get(0).(func())
+}
+
+// Stats is a mocked method that returns data statistics.
+func (m *MockStorage) Stats() types.Stats {
+	args := m.Called()
+	return args.Get(0).(types.Stats)
+}
+
 // TestHandleGetUserByID tests the handleGetUserByID endpoint.
 func TestHandleGetUserByID(t *testing.T) {
 	// Set up mock storage.
@@ -76,13 +221,13 @@ func TestHandleGetUserByID(t *testing.T) {
 			name:           "Invalid User ID (non-numeric)",
 			userID:         "abc",
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   `{"error": "Invalid user ID"}`,
+			expectedBody:   `{"error": "Invalid user ID", "code": "INVALID_USER_ID"}`,
 		},
 		{
 			name:           "User Not Found",
 			userID:         "55",
 			expectedStatus: http.StatusNotFound,
-			expectedBody:   `{"error": "User not found"}`,
+			expectedBody:   `{"error": "User not found", "code": "USER_NOT_FOUND"}`,
 		},
 	}
 
@@ -124,9 +269,20 @@ func TestHandleGetActionCountByUserID(t *testing.T) {
 	router := gin.Default()
 	router.GET("/user/:id/actions/count", server.handleGetActionCountByUserID)
 
+	// The actions of user 5 fall before, at the start of, within and at the end of the
+	// range used below.
+	base := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	mockStore.On("GetUserActions", 5).Return([]types.Action{
+		{ID: 1, UserID: 5, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 2, UserID: 5, Type: types.ActionAddContact, CreatedAt: base.Add(time.Hour)},
+		{ID: 3, UserID: 5, Type: types.ActionEditContact, CreatedAt: base.Add(2 * time.Hour)},
+		{ID: 4, UserID: 5, Type: types.ActionEditContact, CreatedAt: base.Add(3 * time.Hour)},
+	})
+
 	tests := []struct {
 		name           string
 		userID         string
+		query          string
 		mockReturn     int
 		expectedStatus int
 		expectedBody   string
@@ -145,11 +301,41 @@ func TestHandleGetActionCountByUserID(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody:   `{"count": 0}`,
 		},
+		{
+			name:           "Humanized count",
+			userID:         "3",
+			query:          "?humanize=true",
+			mockReturn:     1500,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"count": 1500, "formatted": "1.5k"}`,
+		},
+		{
+			name:           "Count within time range",
+			userID:         "5",
+			query:          "?from=2021-07-04T13:00:00Z&to=2021-07-04T15:00:00Z",
+			mockReturn:     3,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"count": 2}`,
+		},
+		{
+			name:           "Invalid time range",
+			userID:         "6",
+			query:          "?from=2021-07-04T15:00:00Z&to=2021-07-04T13:00:00Z",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid time range", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Invalid humanize flag",
+			userID:         "4",
+			query:          "?humanize=maybe",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid humanize flag", "code": "INVALID_PARAMETER"}`,
+		},
 		{
 			name:           "Invalid User ID (non-numeric)",
 			userID:         "abc",
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   `{"error": "Invalid user ID"}`,
+			expectedBody:   `{"error": "Invalid user ID", "code": "INVALID_USER_ID"}`,
 		},
 	}
 
@@ -162,7 +348,7 @@ func TestHandleGetActionCountByUserID(t *testing.T) {
 				mockStore.On("CountActionsByUserID", id).Return(tt.mockReturn)
 			}
 
-			req, _ := http.NewRequest("GET", "/user/"+tt.userID+"/actions/count", nil)
+			req, _ := http.NewRequest("GET", "/user/"+tt.userID+"/actions/count"+tt.query, nil)
 			response := httptest.NewRecorder()
 
 			router.ServeHTTP(response, req)
@@ -174,6 +360,87 @@ func TestHandleGetActionCountByUserID(t *testing.T) {
 	}
 }
 
+func TestHandleGetActionCountByType(t *testing.T) {
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/:type/count", server.handleGetActionCountByType)
+
+	mockStore.On("CountActionsByType", types.ActionType("WELCOME")).Return(3)
+	mockStore.On("CountActionsByType", types.ActionType("CONNECT_CRM")).Return(1)
+	mockStore.On("CountActionsByType", types.ActionType("REFER_USER")).Return(0)
+
+	from := time.Date(2021, time.July, 4, 13, 0, 0, 0, time.UTC)
+	mockStore.On("GetActionsBetween", from, time.Time{}).Return([]types.Action{
+		{ID: 2, UserID: 1, Type: types.ActionWelcome, CreatedAt: from},
+		{ID: 5, UserID: 2, Type: types.ActionConnectCRM, CreatedAt: from.Add(time.Hour)},
+		{ID: 6, UserID: 3, Type: types.ActionWelcome, CreatedAt: from.Add(2 * time.Hour)},
+	})
+
+	tests := []struct {
+		name           string
+		actionType     string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Type occurring multiple times",
+			actionType:     "WELCOME",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"count": 3}`,
+		},
+		{
+			name:           "Type occurring once",
+			actionType:     "CONNECT_CRM",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"count": 1}`,
+		},
+		{
+			name:           "Absent type",
+			actionType:     "REFER_USER",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"count": 0}`,
+		},
+		{
+			name:           "Type within time range",
+			actionType:     "WELCOME",
+			query:          "?from=2021-07-04T13:00:00Z",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"count": 2}`,
+		},
+		{
+			name:           "Malformed from timestamp",
+			actionType:     "WELCOME",
+			query:          "?from=13:00",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid from timestamp", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Empty type",
+			actionType:     "",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Action type is required", "code": "INVALID_ACTION_TYPE"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/actions/"+tt.actionType+"/count"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
 // TestHandleGetNextActionProbability tests the handleGetNextActionProbability endpoint.
 func TestHandleGetNextActionProbability(t *testing.T) {
 	// Set up mock storage.
@@ -198,6 +465,7 @@ func TestHandleGetNextActionProbability(t *testing.T) {
 	tests := []struct {
 		name           string
 		actionType     string
+		query          string
 		expectedStatus int
 		expectedBody   string
 	}{
@@ -217,13 +485,40 @@ func TestHandleGetNextActionProbability(t *testing.T) {
 			name:           "Probability after non-existent action",
 			actionType:     "UNKNOWN_ACTION",
 			expectedStatus: http.StatusOK,
+			expectedBody:   `{"typeSeen": false}`,
+		},
+		{
+			name:           "Probability after action never followed by another",
+			actionType:     "EDIT_CONTACT",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{}`,
+		},
+		{
+			name:           "Non-existent action in strict mode",
+			actionType:     "UNKNOWN_ACTION",
+			query:          "?strict=true",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "Action type not found", "code": "ACTION_TYPE_NOT_FOUND"}`,
+		},
+		{
+			name:           "Action never followed by another in strict mode",
+			actionType:     "EDIT_CONTACT",
+			query:          "?strict=true",
+			expectedStatus: http.StatusOK,
 			expectedBody:   `{}`,
 		},
+		{
+			name:           "Invalid strict flag",
+			actionType:     "WELCOME",
+			query:          "?strict=maybe",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid strict flag", "code": "INVALID_PARAMETER"}`,
+		},
 		{
 			name:           "Missing action type",
 			actionType:     "",
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   `{"error": "Action type is required"}`,
+			expectedBody:   `{"error": "Action type is required", "code": "INVALID_ACTION_TYPE"}`,
 		},
 	}
 
@@ -234,7 +529,7 @@ func TestHandleGetNextActionProbability(t *testing.T) {
 
 			mockStore.On("GetActions").Return(actions)
 
-			req, _ := http.NewRequest("GET", "/actions/"+tt.actionType+"/next-probability", nil)
+			req, _ := http.NewRequest("GET", "/actions/"+tt.actionType+"/next-probability"+tt.query, nil)
 			response := httptest.NewRecorder()
 
 			router.ServeHTTP(response, req)
@@ -246,39 +541,186 @@ func TestHandleGetNextActionProbability(t *testing.T) {
 	}
 }
 
-// TestHandleGetReferralIndex tests the handleGetReferralIndex endpoint.
-func TestHandleGetReferralIndex(t *testing.T) {
+// TestHandleGetNextActionProbabilityUnseenType tests that a never-seen type keeps the
+// shape of the requested format, and is marked by a header.
+func TestHandleGetNextActionProbabilityUnseenType(t *testing.T) {
+	mockStore := &MockStorage{}
+	mockStore.On("GetActions").Return([]types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME"},
+		{ID: 2, UserID: 1, Type: "CONNECT_CRM"},
+	})
+	server := &Server{store: mockStore}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/:type/next-probability", server.handleGetNextActionProbability)
+
 	tests := []struct {
 		name           string
-		mockActions    []types.Action
+		path           string
+		expectedHeader string
+		expectedBody   string
+	}{
+		{name: "Map", path: "/actions/UNKNOWN_ACTION/next-probability", expectedHeader: "false", expectedBody: `{"typeSeen": false}`},
+		{name: "Array", path: "/actions/UNKNOWN_ACTION/next-probability?as=array", expectedHeader: "false", expectedBody: `[]`},
+		{name: "Detailed", path: "/actions/UNKNOWN_ACTION/next-probability?detailed=true", expectedHeader: "false", expectedBody: `[]`},
+		{name: "Explained", path: "/actions/UNKNOWN_ACTION/next-probability?explain=true", expectedHeader: "false", expectedBody: `{"probabilities": {}, "transitions": {}}`},
+		{name: "Seen type", path: "/actions/WELCOME/next-probability?as=array", expectedBody: `[{"type": "CONNECT_CRM", "probability": 1}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, http.StatusOK, response.Code)
+			assert.Equal(t, tt.expectedHeader, response.Header().Get("X-Action-Type-Seen"))
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetNextActionProbabilityUnsorted tests that the probabilities, and the
+// other statistics of consecutive actions, do not depend on the order in which the
+// storage returns the actions.
+func TestHandleGetNextActionProbabilityUnsorted(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	// Sorted by user and createdAt, as the storage returns them. User 1's last two
+	// actions share a timestamp and are ordered by ID.
+	sorted := []types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 2, UserID: 1, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(time.Hour)},
+		{ID: 3, UserID: 1, Type: "WELCOME", CreatedAt: mockTime.Add(2 * time.Hour)},
+		{ID: 4, UserID: 1, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(3 * time.Hour)},
+		{ID: 5, UserID: 1, Type: "EDIT_CONTACT", CreatedAt: mockTime.Add(3 * time.Hour)},
+		{ID: 6, UserID: 2, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 7, UserID: 2, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(time.Minute)},
+		{ID: 8, UserID: 3, Type: "ADD_CONTACT", CreatedAt: mockTime},
+		{ID: 9, UserID: 3, Type: "WELCOME", CreatedAt: mockTime.Add(time.Minute)},
+	}
+
+	serve := func(actions []types.Action, path string) string {
+		mockStore := &MockStorage{}
+		mockStore.On("GetActions").Return(actions)
+		mockStore.On("GetUser", 2).Return(&types.User{ID: 2})
+		mockStore.On("GetUserActions", 2).Return(sorted[5:7])
+		server := &Server{store: mockStore}
+
+		gin.SetMode(gin.TestMode)
+		router := gin.Default()
+		router.GET("/users/:id/next-probability-for", server.handleGetUserNextActionProbability)
+		router.GET("/actions/:type/next-probability", server.handleGetNextActionProbability)
+		router.GET("/actions/:type/expected-next", server.handleGetExpectedNextAction)
+		router.GET("/actions/:type/alternatives", server.handleGetNextActionAlternatives)
+		router.GET("/actions/:type/gap-stats", server.handleGetGapStats)
+		router.GET("/actions/next-probability", server.handleGetConditionalNextActionProbability)
+		router.GET("/actions/first", server.handleGetFirstActionTypes)
+		router.GET("/actions/compare-next", server.handleCompareNextActions)
+		router.GET("/actions/transition-graph", server.handleGetTransitionGraph)
+		router.GET("/actions/entropy", server.handleGetTransitionEntropy)
+
+		req, _ := http.NewRequest("GET", path, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		return response.Body.String()
+	}
+
+	paths := []string{
+		"/actions/WELCOME/next-probability",
+		"/actions/ADD_CONTACT/next-probability",
+		"/actions/WELCOME/next-probability?explain=true",
+		"/actions/WELCOME/expected-next",
+		"/actions/WELCOME/alternatives",
+		"/actions/WELCOME/gap-stats",
+		"/actions/next-probability?previous=WELCOME&current=ADD_CONTACT",
+		"/actions/first",
+		"/actions/compare-next?a=WELCOME&b=ADD_CONTACT",
+		"/actions/transition-graph",
+		"/actions/entropy",
+		"/users/2/next-probability-for?type=WELCOME",
+	}
+	assert.JSONEq(t, `{"ADD_CONTACT": 0.67, "CONNECT_CRM": 0.33}`, serve(sorted, paths[0]))
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		shuffled := append([]types.Action(nil), sorted...)
+		rng.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+
+		for _, path := range paths {
+			assert.JSONEq(t, serve(sorted, path), serve(shuffled, path), path)
+		}
+	}
+}
+
+// TestHandleGetNextActionProbabilityAsArray tests the array format of the
+// handleGetNextActionProbability endpoint.
+func TestHandleGetNextActionProbabilityAsArray(t *testing.T) {
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/:type/next-probability", server.handleGetNextActionProbability)
+
+	// WELCOME is followed by ADD_CONTACT twice and by VIEW_CONTACTS and CONNECT_CRM once.
+	mockStore.On("GetActions").Return([]types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME"},
+		{ID: 2, UserID: 1, Type: "ADD_CONTACT"},
+		{ID: 3, UserID: 2, Type: "WELCOME"},
+		{ID: 4, UserID: 2, Type: "VIEW_CONTACTS"},
+		{ID: 5, UserID: 3, Type: "WELCOME"},
+		{ID: 6, UserID: 3, Type: "ADD_CONTACT"},
+		{ID: 7, UserID: 4, Type: "WELCOME"},
+		{ID: 8, UserID: 4, Type: "CONNECT_CRM"},
+	})
+
+	tests := []struct {
+		name           string
+		query          string
 		expectedStatus int
 		expectedBody   string
 	}{
 		{
-			name:           "No actions",
-			mockActions:    []types.Action{},
-			expectedStatus: http.StatusNotFound,
-			expectedBody:   `{"error": "No actions found"}`,
+			name:           "Sorted by probability then type",
+			query:          "?as=array",
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"type": "ADD_CONTACT", "probability": 0.5},
+				{"type": "CONNECT_CRM", "probability": 0.25},
+				{"type": "VIEW_CONTACTS", "probability": 0.25}
+			]`,
 		},
 		{
-			name: "No referrals",
-			mockActions: []types.Action{
-				{ID: 1, UserID: 1, Type: "WELCOME", TargetUser: 2},
-				{ID: 2, UserID: 2, Type: "ADD_CONTACT", TargetUser: 3},
-			},
-			expectedStatus: http.StatusNotFound,
-			expectedBody:   `{"error": "No referrals found"}`,
+			name:           "Map by default",
+			query:          "",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"ADD_CONTACT": 0.5, "CONNECT_CRM": 0.25, "VIEW_CONTACTS": 0.25}`,
 		},
 		{
-			name: "Referral index calculation",
-			mockActions: []types.Action{
-				{ID: 1, UserID: 1, Type: "REFER_USER", TargetUser: 2},
-				{ID: 2, UserID: 2, Type: "REFER_USER", TargetUser: 3},
-				{ID: 3, UserID: 3, Type: "REFER_USER", TargetUser: 4},
-				{ID: 4, UserID: 1, Type: "REFER_USER", TargetUser: 5},
-			},
-			expectedStatus: http.StatusOK,
-			expectedBody:   `{"1": 4, "2": 2, "3": 1}`,
+			name:           "Invalid format",
+			query:          "?as=list",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid format, expected map or array", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Combined with explain",
+			query:          "?as=array&explain=true",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "The array format cannot be combined with explain", "code": "INVALID_PARAMETER"}`,
 		},
 	}
 
@@ -286,16 +728,7 @@ func TestHandleGetReferralIndex(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel() // Enable parallel execution
 
-			mockStore := &MockStorage{}
-			server := &Server{store: mockStore}
-
-			gin.SetMode(gin.TestMode)
-			router := gin.Default()
-			router.GET("/users/referal-index", server.handleGetReferralIndex)
-
-			mockStore.On("GetActions").Return(tt.mockActions)
-
-			req, _ := http.NewRequest("GET", "/users/referal-index", nil)
+			req, _ := http.NewRequest("GET", "/actions/WELCOME/next-probability"+tt.query, nil)
 			response := httptest.NewRecorder()
 
 			router.ServeHTTP(response, req)
@@ -306,3 +739,3581 @@ func TestHandleGetReferralIndex(t *testing.T) {
 		})
 	}
 }
+
+// TestHandleGetNextActionProbabilityDetailed tests the detailed format of the
+// handleGetNextActionProbability endpoint.
+func TestHandleGetNextActionProbabilityDetailed(t *testing.T) {
+	// WELCOME is followed by ADD_CONTACT three times, and by VIEW_CONTACTS,
+	// EDIT_CONTACT and CONNECT_CRM once each.
+	actions := []types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME"},
+		{ID: 2, UserID: 1, Type: "ADD_CONTACT"},
+		{ID: 3, UserID: 2, Type: "WELCOME"},
+		{ID: 4, UserID: 2, Type: "VIEW_CONTACTS"},
+		{ID: 5, UserID: 3, Type: "WELCOME"},
+		{ID: 6, UserID: 3, Type: "ADD_CONTACT"},
+		{ID: 7, UserID: 3, Type: "WELCOME"},
+		{ID: 8, UserID: 3, Type: "EDIT_CONTACT"},
+		{ID: 9, UserID: 4, Type: "WELCOME"},
+		{ID: 10, UserID: 4, Type: "CONNECT_CRM"},
+		{ID: 11, UserID: 5, Type: "WELCOME"},
+		{ID: 12, UserID: 5, Type: "ADD_CONTACT"},
+	}
+
+	serve := func(query string) *httptest.ResponseRecorder {
+		mockStore := &MockStorage{}
+		mockStore.On("GetActions").Return(actions)
+		server := &Server{store: mockStore}
+
+		gin.SetMode(gin.TestMode)
+		router := gin.Default()
+		router.GET("/actions/:type/next-probability", server.handleGetNextActionProbability)
+
+		req, _ := http.NewRequest("GET", "/actions/WELCOME/next-probability"+query, nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	response := serve("?detailed=true")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `[
+		{"type": "ADD_CONTACT", "count": 3, "probability": 0.5, "rawProbability": 0.5},
+		{"type": "CONNECT_CRM", "count": 1, "probability": 0.17, "rawProbability": 0.16666666666666666},
+		{"type": "EDIT_CONTACT", "count": 1, "probability": 0.17, "rawProbability": 0.16666666666666666},
+		{"type": "VIEW_CONTACTS", "count": 1, "probability": 0.17, "rawProbability": 0.16666666666666666}
+	]`, response.Body.String())
+
+	// The output is byte for byte the same on every run.
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, response.Body.String(), serve("?detailed=true").Body.String())
+	}
+
+	// The counts add up to the WELCOME transitions observed.
+	var detailed []types.DetailedActionProbability
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &detailed))
+	total := 0
+	for _, entry := range detailed {
+		total += entry.Count
+	}
+	assert.Equal(t, 6, total)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Map when off",
+			query:          "?detailed=false",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"ADD_CONTACT": 0.5, "CONNECT_CRM": 0.17, "EDIT_CONTACT": 0.17, "VIEW_CONTACTS": 0.17}`,
+		},
+		{
+			name:           "Invalid detailed flag",
+			query:          "?detailed=maybe",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid detailed flag", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Combined with the array format",
+			query:          "?detailed=true&as=array",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "The detailed format cannot be combined with the array format or explain", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Combined with explain",
+			query:          "?detailed=true&explain=true",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "The detailed format cannot be combined with the array format or explain", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			response := serve(tt.query)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetConditionalNextActionProbability tests the
+// handleGetConditionalNextActionProbability endpoint.
+func TestHandleGetConditionalNextActionProbability(t *testing.T) {
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/next-probability", server.handleGetConditionalNextActionProbability)
+
+	mockStore.On("GetActions").Return([]types.Action{
+		// WELCOME -> CONNECT_CRM -> ADD_CONTACT
+		{ID: 1, UserID: 1, Type: "WELCOME"},
+		{ID: 2, UserID: 1, Type: "CONNECT_CRM"},
+		{ID: 3, UserID: 1, Type: "ADD_CONTACT"},
+		// WELCOME -> CONNECT_CRM -> VIEW_CONTACTS -> CONNECT_CRM -> EDIT_CONTACT
+		{ID: 4, UserID: 2, Type: "WELCOME"},
+		{ID: 5, UserID: 2, Type: "CONNECT_CRM"},
+		{ID: 6, UserID: 2, Type: "VIEW_CONTACTS"},
+		{ID: 7, UserID: 2, Type: "CONNECT_CRM"},
+		{ID: 8, UserID: 2, Type: "EDIT_CONTACT"},
+		// WELCOME -> CONNECT_CRM, with nothing following.
+		{ID: 9, UserID: 3, Type: "WELCOME"},
+		{ID: 10, UserID: 3, Type: "CONNECT_CRM"},
+		// The next user's actions do not continue user 3's triple.
+		{ID: 11, UserID: 4, Type: "ADD_CONTACT"},
+	})
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Conditioned on WELCOME",
+			query:          "?current=CONNECT_CRM&previous=WELCOME",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"ADD_CONTACT": 0.5, "VIEW_CONTACTS": 0.5}`,
+		},
+		{
+			name:           "Conditioned on VIEW_CONTACTS",
+			query:          "?current=CONNECT_CRM&previous=VIEW_CONTACTS",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"EDIT_CONTACT": 1}`,
+		},
+		{
+			name:           "Pair never occurs",
+			query:          "?current=WELCOME&previous=ADD_CONTACT",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{}`,
+		},
+		{
+			name:           "Missing previous",
+			query:          "?current=CONNECT_CRM",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Action types current and previous are required", "code": "INVALID_ACTION_TYPE"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/actions/next-probability"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetNextActionAlternatives tests the handleGetNextActionAlternatives endpoint.
+func TestHandleGetNextActionAlternatives(t *testing.T) {
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/:type/alternatives", server.handleGetNextActionAlternatives)
+
+	// WELCOME is followed by ADD_CONTACT twice and by VIEW_CONTACTS and CONNECT_CRM once.
+	mockStore.On("GetActions").Return([]types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME"},
+		{ID: 2, UserID: 1, Type: "ADD_CONTACT"},
+		{ID: 3, UserID: 2, Type: "WELCOME"},
+		{ID: 4, UserID: 2, Type: "VIEW_CONTACTS"},
+		{ID: 5, UserID: 3, Type: "WELCOME"},
+		{ID: 6, UserID: 3, Type: "ADD_CONTACT"},
+		{ID: 7, UserID: 4, Type: "WELCOME"},
+		{ID: 8, UserID: 4, Type: "CONNECT_CRM"},
+	})
+
+	tests := []struct {
+		name           string
+		actionType     string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Top two",
+			actionType:     "WELCOME",
+			query:          "?top=2",
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"type": "ADD_CONTACT", "probability": 0.5},
+				{"type": "CONNECT_CRM", "probability": 0.25}
+			]`,
+		},
+		{
+			name:           "Defaults to two",
+			actionType:     "WELCOME",
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"type": "ADD_CONTACT", "probability": 0.5},
+				{"type": "CONNECT_CRM", "probability": 0.25}
+			]`,
+		},
+		{
+			name:           "More than available",
+			actionType:     "WELCOME",
+			query:          "?top=10",
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"type": "ADD_CONTACT", "probability": 0.5},
+				{"type": "CONNECT_CRM", "probability": 0.25},
+				{"type": "VIEW_CONTACTS", "probability": 0.25}
+			]`,
+		},
+		{
+			name:           "Never followed by another action",
+			actionType:     "ADD_CONTACT",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			name:           "Invalid top",
+			actionType:     "WELCOME",
+			query:          "?top=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid number of alternatives", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/actions/"+tt.actionType+"/alternatives"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetNextActionProbabilityRoundingMode tests the rounding modes of the
+// handleGetNextActionProbability endpoint at tie boundaries.
+func TestHandleGetNextActionProbabilityRoundingMode(t *testing.T) {
+	// WELCOME is followed by CONNECT_CRM once (0.125), ADD_CONTACT three times (0.375)
+	// and VIEW_CONTACTS four times (0.5).
+	var actions []types.Action
+	for userID, next := range []types.ActionType{
+		"CONNECT_CRM", "ADD_CONTACT", "ADD_CONTACT", "ADD_CONTACT",
+		"VIEW_CONTACTS", "VIEW_CONTACTS", "VIEW_CONTACTS", "VIEW_CONTACTS",
+	} {
+		actions = append(actions,
+			types.Action{ID: 2 * userID, UserID: userID, Type: "WELCOME"},
+			types.Action{ID: 2*userID + 1, UserID: userID, Type: next},
+		)
+	}
+
+	tests := []struct {
+		name           string
+		configMode     string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Half up by default",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"CONNECT_CRM": 0.13, "ADD_CONTACT": 0.38, "VIEW_CONTACTS": 0.5}`,
+		},
+		{
+			name:           "Half even requested",
+			query:          "?roundingMode=half-even",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"CONNECT_CRM": 0.12, "ADD_CONTACT": 0.38, "VIEW_CONTACTS": 0.5}`,
+		},
+		{
+			name:           "Half even configured",
+			configMode:     RoundHalfEven,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"CONNECT_CRM": 0.12, "ADD_CONTACT": 0.38, "VIEW_CONTACTS": 0.5}`,
+		},
+		{
+			name:           "Request overrides configuration",
+			configMode:     RoundHalfEven,
+			query:          "?roundingMode=half-up",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"CONNECT_CRM": 0.13, "ADD_CONTACT": 0.38, "VIEW_CONTACTS": 0.5}`,
+		},
+		{
+			name:           "Invalid mode",
+			query:          "?roundingMode=down",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid rounding mode, expected half-up or half-even", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetActions").Return(actions)
+			server := &Server{store: mockStore, cfg: Config{RoundingMode: tt.configMode}}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/actions/:type/next-probability", server.handleGetNextActionProbability)
+
+			req, _ := http.NewRequest("GET", "/actions/WELCOME/next-probability"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetNextActionProbabilityExplain tests the explain option of the
+// handleGetNextActionProbability endpoint.
+func TestHandleGetNextActionProbabilityExplain(t *testing.T) {
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/:type/next-probability", server.handleGetNextActionProbability)
+
+	actions := []types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME"},
+		{ID: 2, UserID: 1, Type: "CONNECT_CRM"},
+		{ID: 3, UserID: 1, Type: "WELCOME"},
+		{ID: 4, UserID: 1, Type: "CONNECT_CRM"},
+		{ID: 5, UserID: 2, Type: "WELCOME"},
+		{ID: 6, UserID: 2, Type: "VIEW_CONTACTS"},
+		{ID: 7, UserID: 3, Type: "WELCOME"},
+	}
+	mockStore.On("GetActions").Return(actions)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Explain pairs",
+			query:          "?explain=true",
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"probabilities": {"CONNECT_CRM": 0.67, "VIEW_CONTACTS": 0.33},
+				"transitions": {
+					"CONNECT_CRM": {"count": 2, "pairs": [
+						{"sourceActionId": 1, "nextActionId": 2},
+						{"sourceActionId": 3, "nextActionId": 4}
+					]},
+					"VIEW_CONTACTS": {"count": 1, "pairs": [
+						{"sourceActionId": 5, "nextActionId": 6}
+					]}
+				}
+			}`,
+		},
+		{
+			name:           "Explain disabled",
+			query:          "?explain=false",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"CONNECT_CRM": 0.67, "VIEW_CONTACTS": 0.33}`,
+		},
+		{
+			name:           "Invalid explain flag",
+			query:          "?explain=maybe",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid explain flag", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/actions/WELCOME/next-probability"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+
+	// The explained pairs are exactly the transitions the probabilities are based on.
+	var explained types.ExplainedActionsProbability
+	req, _ := http.NewRequest("GET", "/actions/WELCOME/next-probability?explain=true", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &explained))
+
+	total := 0
+	for _, explanation := range explained.Transitions {
+		total += explanation.Count
+		assert.Len(t, explanation.Pairs, explanation.Count)
+	}
+	assert.Equal(t, len(nextActions(actions, "WELCOME")), total)
+}
+
+// TestHandleGetReferralIndex tests the handleGetReferralIndex endpoint.
+func TestHandleGetReferralIndex(t *testing.T) {
+	tests := []struct {
+		name           string
+		mockActions    []types.Action
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "No actions",
+			mockActions:    []types.Action{},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "No actions found", "code": "NO_ACTIONS"}`,
+		},
+		{
+			name: "No referrals",
+			mockActions: []types.Action{
+				{ID: 1, UserID: 1, Type: "WELCOME", TargetUser: targetUser(2)},
+				{ID: 2, UserID: 2, Type: "ADD_CONTACT", TargetUser: targetUser(3)},
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "No referrals found", "code": "NO_REFERRALS"}`,
+		},
+		{
+			name: "Referral index calculation",
+			mockActions: []types.Action{
+				{ID: 1, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(2)},
+				{ID: 2, UserID: 2, Type: "REFER_USER", TargetUser: targetUser(3)},
+				{ID: 3, UserID: 3, Type: "REFER_USER", TargetUser: targetUser(4)},
+				{ID: 4, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(5)},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"1": 4, "2": 2, "3": 1}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/users/referal-index", server.handleGetReferralIndex)
+
+			mockStore.On("GetActions").Return(tt.mockActions)
+
+			req, _ := http.NewRequest("GET", "/users/referal-index", nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetStats tests the handleGetStats endpoint.
+func TestHandleGetStats(t *testing.T) {
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/stats", server.handleGetStats)
+
+	mockStore.On("Stats").Return(types.Stats{Users: 2, Actions: 5, OutOfOrderActions: 3})
+
+	req, _ := http.NewRequest("GET", "/stats", nil)
+	response := httptest.NewRecorder()
+
+	router.ServeHTTP(response, req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"users": 2, "actions": 5, "outOfOrderActions": 3}`, response.Body.String())
+}
+
+// TestHandleGetExpectedNextAction tests the handleGetExpectedNextAction endpoint.
+func TestHandleGetExpectedNextAction(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/:type/expected-next", server.handleGetExpectedNextAction)
+
+	// WELCOME is followed by CONNECT_CRM after 60s, CONNECT_CRM after 120s and
+	// VIEW_CONTACTS after 300s.
+	actions := []types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 2, UserID: 1, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(60 * time.Second)},
+		{ID: 3, UserID: 2, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 4, UserID: 2, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(120 * time.Second)},
+		{ID: 5, UserID: 3, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 6, UserID: 3, Type: "VIEW_CONTACTS", CreatedAt: mockTime.Add(300 * time.Second)},
+	}
+
+	tests := []struct {
+		name           string
+		actionType     string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Weighted time after WELCOME",
+			actionType:     "WELCOME",
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"samples": 3,
+				"expectedSeconds": 160,
+				"transitions": {
+					"CONNECT_CRM": {"count": 2, "probability": 0.6666666666666666, "meanSeconds": 90},
+					"VIEW_CONTACTS": {"count": 1, "probability": 0.3333333333333333, "meanSeconds": 300}
+				}
+			}`,
+		},
+		{
+			name:           "Terminal action type",
+			actionType:     "VIEW_CONTACTS",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"samples": 0, "expectedSeconds": null, "transitions": {}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore.On("GetActions").Return(actions)
+
+			req, _ := http.NewRequest("GET", "/actions/"+tt.actionType+"/expected-next", nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetActionsSample tests the handleGetActionsSample endpoint.
+func TestHandleGetActionsSample(t *testing.T) {
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/sample", server.handleGetActionsSample)
+
+	var actions []types.Action
+	for i := 0; i < 100; i++ {
+		actions = append(actions, types.Action{ID: i, UserID: i / 10, Type: "WELCOME"})
+	}
+	mockStore.On("GetActions").Return(actions)
+
+	get := func(query string) (int, []types.Action) {
+		req, _ := http.NewRequest("GET", "/actions/sample"+query, nil)
+		response := httptest.NewRecorder()
+
+		router.ServeHTTP(response, req)
+
+		var sample []types.Action
+		if response.Code == http.StatusOK {
+			if err := json.Unmarshal(response.Body.Bytes(), &sample); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return response.Code, sample
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedSize   int
+	}{
+		{name: "Default sample size", query: "", expectedStatus: http.StatusOK, expectedSize: 20},
+		{name: "Explicit sample size", query: "?n=5&seed=42", expectedStatus: http.StatusOK, expectedSize: 5},
+		{name: "Sample size above total", query: "?n=500", expectedStatus: http.StatusOK, expectedSize: 100},
+		{name: "Invalid sample size", query: "?n=0", expectedStatus: http.StatusBadRequest},
+		{name: "Invalid seed", query: "?seed=abc", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			status, sample := get(tt.query)
+
+			assert.Equal(t, tt.expectedStatus, status)
+			assert.Len(t, sample, tt.expectedSize)
+		})
+	}
+
+	t.Run("Deterministic with seed", func(t *testing.T) {
+		_, first := get("?n=10&seed=7")
+		_, second := get("?n=10&seed=7")
+		_, other := get("?n=10&seed=8")
+
+		assert.Equal(t, first, second)
+		assert.NotEqual(t, first, other)
+	})
+}
+
+// TestHandleCompareNextActions tests the handleCompareNextActions endpoint.
+func TestHandleCompareNextActions(t *testing.T) {
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/compare-next", server.handleCompareNextActions)
+
+	// Example actions in the storage.
+	actions := []types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME"},
+		{ID: 2, UserID: 1, Type: "CONNECT_CRM"},
+		{ID: 3, UserID: 1, Type: "ADD_CONTACT"},
+		{ID: 4, UserID: 2, Type: "EDIT_CONTACT"},
+		{ID: 5, UserID: 3, Type: "WELCOME"},
+		{ID: 6, UserID: 3, Type: "VIEW_CONTACTS"},
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Overlapping distributions",
+			query:          "?a=WELCOME&b=CONNECT_CRM",
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"a": {"type": "WELCOME", "probabilities": {"CONNECT_CRM": 0.5, "VIEW_CONTACTS": 0.5}},
+				"b": {"type": "CONNECT_CRM", "probabilities": {"ADD_CONTACT": 1}},
+				"totalVariationDistance": 1
+			}`,
+		},
+		{
+			name:           "Identical distributions",
+			query:          "?a=WELCOME&b=WELCOME",
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"a": {"type": "WELCOME", "probabilities": {"CONNECT_CRM": 0.5, "VIEW_CONTACTS": 0.5}},
+				"b": {"type": "WELCOME", "probabilities": {"CONNECT_CRM": 0.5, "VIEW_CONTACTS": 0.5}},
+				"totalVariationDistance": 0
+			}`,
+		},
+		{
+			name:           "Type without transitions",
+			query:          "?a=WELCOME&b=VIEW_CONTACTS",
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"a": {"type": "WELCOME", "probabilities": {"CONNECT_CRM": 0.5, "VIEW_CONTACTS": 0.5}},
+				"b": {"type": "VIEW_CONTACTS", "probabilities": {}},
+				"totalVariationDistance": null
+			}`,
+		},
+		{
+			name:           "Missing type",
+			query:          "?a=WELCOME",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Action types a and b are required", "code": "INVALID_ACTION_TYPE"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore.On("GetActions").Return(actions)
+
+			req, _ := http.NewRequest("GET", "/actions/compare-next"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetActionByID tests the handleGetActionByID endpoint.
+func TestHandleGetActionByID(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/:type", server.handleGetActionByID)
+
+	mockStore.On("GetAction", 3).Return(&types.Action{ID: 3, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(2), CreatedAt: mockTime})
+	mockStore.On("GetAction", 55).Return(nil)
+
+	tests := []struct {
+		name           string
+		actionID       string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Valid action ID",
+			actionID:       "3",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id": 3, "type": "REFER_USER", "userId": 1, "targetUser": 2, "createdAt": "2021-07-04T12:47:09.888Z"}`,
+		},
+		{
+			name:           "Invalid action ID (non-numeric)",
+			actionID:       "abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid action ID", "code": "INVALID_ACTION_ID"}`,
+		},
+		{
+			name:           "Action not found",
+			actionID:       "55",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "Action not found", "code": "ACTION_NOT_FOUND"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/actions/"+tt.actionID, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			if tt.expectedStatus == http.StatusOK {
+				assert.NotEmpty(t, response.Header().Get("ETag"))
+			}
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetTransitionGraph tests the handleGetTransitionGraph endpoint.
+func TestHandleGetTransitionGraph(t *testing.T) {
+	tests := []struct {
+		name           string
+		mockActions    []types.Action
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name: "Edge counts",
+			mockActions: []types.Action{
+				{ID: 1, UserID: 1, Type: "WELCOME"},
+				{ID: 2, UserID: 1, Type: "CONNECT_CRM"},
+				{ID: 3, UserID: 1, Type: "ADD_CONTACT"},
+				{ID: 4, UserID: 2, Type: "EDIT_CONTACT"},
+				{ID: 5, UserID: 3, Type: "WELCOME"},
+				{ID: 6, UserID: 3, Type: "CONNECT_CRM"},
+				{ID: 7, UserID: 3, Type: "WELCOME"},
+				{ID: 8, UserID: 3, Type: "VIEW_CONTACTS"},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"from": "CONNECT_CRM", "to": "ADD_CONTACT", "count": 1},
+				{"from": "CONNECT_CRM", "to": "WELCOME", "count": 1},
+				{"from": "WELCOME", "to": "CONNECT_CRM", "count": 2},
+				{"from": "WELCOME", "to": "VIEW_CONTACTS", "count": 1}
+			]`,
+		},
+		{
+			name:           "No transitions",
+			mockActions:    []types.Action{{ID: 1, UserID: 1, Type: "WELCOME"}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/actions/transition-graph", server.handleGetTransitionGraph)
+
+			mockStore.On("GetActions").Return(tt.mockActions)
+
+			req, _ := http.NewRequest("GET", "/actions/transition-graph", nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+func TestHandleGetTransitionMatrix(t *testing.T) {
+	mockTime := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	// The actions are out of order, as a storage other than the in-memory one may
+	// return them.
+	actions := []types.Action{
+		{ID: 5, UserID: 3, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 3, UserID: 1, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(2 * time.Hour)},
+		{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 4, UserID: 2, Type: "EDIT_CONTACT", CreatedAt: mockTime},
+		{ID: 8, UserID: 3, Type: "VIEW_CONTACTS", CreatedAt: mockTime.Add(3 * time.Hour)},
+		{ID: 2, UserID: 1, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(time.Hour)},
+		{ID: 7, UserID: 3, Type: "WELCOME", CreatedAt: mockTime.Add(2 * time.Hour)},
+		{ID: 6, UserID: 3, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(time.Hour)},
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Whole matrix",
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"WELCOME": {"CONNECT_CRM": 0.67, "VIEW_CONTACTS": 0.33},
+				"CONNECT_CRM": {"ADD_CONTACT": 0.5, "WELCOME": 0.5}
+			}`,
+		},
+		{
+			// WELCOME → VIEW_CONTACTS and every CONNECT_CRM transition are dropped.
+			name:           "Minimum count",
+			query:          "?minCount=2",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"WELCOME": {"CONNECT_CRM": 1}}`,
+		},
+		{
+			name:           "Minimum count above every transition",
+			query:          "?minCount=3",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{}`,
+		},
+		{
+			name:           "Invalid minimum count",
+			query:          "?minCount=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid minCount", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetActions").Return(actions)
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/actions/transition-matrix", server.handleGetTransitionMatrix)
+
+			req, _ := http.NewRequest("GET", "/actions/transition-matrix"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+func TestHandleGetCommonSequences(t *testing.T) {
+	mockTime := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	actions := []types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 2, UserID: 1, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(time.Hour)},
+		{ID: 3, UserID: 1, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(2 * time.Hour)},
+		{ID: 4, UserID: 1, Type: "EDIT_CONTACT", CreatedAt: mockTime.Add(3 * time.Hour)},
+		{ID: 6, UserID: 2, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(time.Hour)},
+		{ID: 5, UserID: 2, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 7, UserID: 2, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(2 * time.Hour)},
+		{ID: 8, UserID: 3, Type: "CONNECT_CRM", CreatedAt: mockTime},
+		{ID: 9, UserID: 3, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(time.Hour)},
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			// Windows spanning two users, e.g. EDIT_CONTACT → WELCOME, are not counted.
+			name:           "Default length",
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"sequence": ["WELCOME", "CONNECT_CRM", "ADD_CONTACT"], "count": 2},
+				{"sequence": ["CONNECT_CRM", "ADD_CONTACT", "EDIT_CONTACT"], "count": 1}
+			]`,
+		},
+		{
+			name:           "Pairs",
+			query:          "?length=2",
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"sequence": ["CONNECT_CRM", "ADD_CONTACT"], "count": 3},
+				{"sequence": ["WELCOME", "CONNECT_CRM"], "count": 2},
+				{"sequence": ["ADD_CONTACT", "EDIT_CONTACT"], "count": 1}
+			]`,
+		},
+		{
+			name:           "Limit",
+			query:          "?length=2&limit=1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"sequence": ["CONNECT_CRM", "ADD_CONTACT"], "count": 3}]`,
+		},
+		{
+			name:           "Longer than every user's actions",
+			query:          "?length=5",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			name:           "Length too short",
+			query:          "?length=1",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid length, expected 2 to 5", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Length too long",
+			query:          "?length=6",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid length, expected 2 to 5", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Invalid limit",
+			query:          "?limit=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid limit", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetActions").Return(actions)
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/actions/common-sequences", server.handleGetCommonSequences)
+
+			req, _ := http.NewRequest("GET", "/actions/common-sequences"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+func TestHandleGetFunnel(t *testing.T) {
+	mockTime := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	actions := []types.Action{
+		// User 1 completes the funnel.
+		{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 2, UserID: 1, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(time.Hour)},
+		{ID: 3, UserID: 1, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(2 * time.Hour)},
+		// User 2 stops after the second step, with another action in between.
+		{ID: 4, UserID: 2, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 5, UserID: 2, Type: "EDIT_CONTACT", CreatedAt: mockTime.Add(time.Hour)},
+		{ID: 6, UserID: 2, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(2 * time.Hour)},
+		// User 3 performs every step but in reverse, listed out of order.
+		{ID: 9, UserID: 3, Type: "WELCOME", CreatedAt: mockTime.Add(2 * time.Hour)},
+		{ID: 7, UserID: 3, Type: "ADD_CONTACT", CreatedAt: mockTime},
+		{ID: 8, UserID: 3, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(time.Hour)},
+		// User 4 never reaches the first step.
+		{ID: 10, UserID: 4, Type: "CONNECT_CRM", CreatedAt: mockTime},
+		{ID: 11, UserID: 4, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(time.Hour)},
+		// User 5 adds a contact before connecting the CRM.
+		{ID: 12, UserID: 5, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 13, UserID: 5, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(time.Hour)},
+		{ID: 14, UserID: 5, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(2 * time.Hour)},
+	}
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Full funnel",
+			body:           `{"steps": ["WELCOME", "CONNECT_CRM", "ADD_CONTACT"]}`,
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"steps": [
+					{"type": "WELCOME", "users": 4, "conversion": null},
+					{"type": "CONNECT_CRM", "users": 3, "conversion": 0.75},
+					{"type": "ADD_CONTACT", "users": 1, "conversion": 0.33}
+				],
+				"conversion": 0.25
+			}`,
+		},
+		{
+			name:           "Funnel nobody enters",
+			body:           `{"steps": ["REFER_USER", "WELCOME"]}`,
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"steps": [
+					{"type": "REFER_USER", "users": 0, "conversion": null},
+					{"type": "WELCOME", "users": 0, "conversion": null}
+				],
+				"conversion": null
+			}`,
+		},
+		{
+			name:           "Single step",
+			body:           `{"steps": ["WELCOME"]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "At least two steps are required", "code": "INVALID_REQUEST_BODY"}`,
+		},
+		{
+			name:           "Too many steps",
+			body:           `{"steps": [` + strings.Repeat(`"WELCOME", `, maxFunnelSteps) + `"WELCOME"]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Too many steps, at most 20 are allowed", "code": "INVALID_REQUEST_BODY"}`,
+		},
+		{
+			name:           "Empty step",
+			body:           `{"steps": ["WELCOME", ""]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Action type is required", "code": "INVALID_ACTION_TYPE"}`,
+		},
+		{
+			name:           "Invalid body",
+			body:           `{"steps": "WELCOME"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid request body", "code": "INVALID_REQUEST_BODY"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetActions").Return(actions)
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.POST("/actions/funnel", server.handleGetFunnel)
+
+			req, _ := http.NewRequest("POST", "/actions/funnel", strings.NewReader(tt.body))
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestTransitionMatrixRows checks on random data that every row of the transition
+// matrix sums to 1 and matches the single-type next-action probabilities.
+func TestTransitionMatrixRows(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	start := time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC)
+	actions := make([]types.Action, 0, 2000)
+	for i := 0; i < cap(actions); i++ {
+		actions = append(actions, types.Action{
+			ID:        i + 1,
+			UserID:    rng.Intn(50),
+			Type:      types.KnownActionTypes[rng.Intn(len(types.KnownActionTypes))],
+			CreatedAt: start.Add(time.Duration(rng.Intn(100_000)) * time.Minute),
+		})
+	}
+	grouped := groupedByUser(actions)
+
+	matrix := transitionMatrix(transitionCounts(grouped), 1, RoundHalfUp)
+
+	assert.NotEmpty(t, matrix)
+	for from, row := range matrix {
+		sum := 0.0
+		for _, probability := range row {
+			sum += probability
+		}
+		// Each of the rounded probabilities is off by at most half a percent.
+		assert.InDelta(t, 1.0, sum, 0.005*float64(len(row)), "row %s", from)
+		assert.Equal(t, roundProbabilities(nextActionProbability(grouped, from), RoundHalfUp), row, "row %s", from)
+	}
+}
+
+// TestHandleGetTransitionEntropy tests the handleGetTransitionEntropy endpoint.
+func TestHandleGetTransitionEntropy(t *testing.T) {
+	tests := []struct {
+		name           string
+		mockActions    []types.Action
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name: "Varied, predictable and terminal types",
+			mockActions: []types.Action{
+				{ID: 1, UserID: 1, Type: "WELCOME"},
+				{ID: 2, UserID: 1, Type: "CONNECT_CRM"},
+				{ID: 3, UserID: 1, Type: "ADD_CONTACT"},
+				{ID: 4, UserID: 2, Type: "WELCOME"},
+				{ID: 5, UserID: 2, Type: "VIEW_CONTACTS"},
+				{ID: 6, UserID: 3, Type: "CONNECT_CRM"},
+				{ID: 7, UserID: 3, Type: "ADD_CONTACT"},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"type": "ADD_CONTACT", "entropy": 0, "samples": 0, "terminal": true},
+				{"type": "CONNECT_CRM", "entropy": 0, "samples": 2, "terminal": false},
+				{"type": "VIEW_CONTACTS", "entropy": 0, "samples": 0, "terminal": true},
+				{"type": "WELCOME", "entropy": 1, "samples": 2, "terminal": false}
+			]`,
+		},
+		{
+			name: "Uniform over four types",
+			mockActions: []types.Action{
+				{ID: 1, UserID: 1, Type: "WELCOME"},
+				{ID: 2, UserID: 1, Type: "ADD_CONTACT"},
+				{ID: 3, UserID: 1, Type: "WELCOME"},
+				{ID: 4, UserID: 1, Type: "EDIT_CONTACT"},
+				{ID: 5, UserID: 1, Type: "WELCOME"},
+				{ID: 6, UserID: 1, Type: "VIEW_CONTACTS"},
+				{ID: 7, UserID: 1, Type: "WELCOME"},
+				{ID: 8, UserID: 1, Type: "CONNECT_CRM"},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"type": "ADD_CONTACT", "entropy": 0, "samples": 1, "terminal": false},
+				{"type": "CONNECT_CRM", "entropy": 0, "samples": 0, "terminal": true},
+				{"type": "EDIT_CONTACT", "entropy": 0, "samples": 1, "terminal": false},
+				{"type": "VIEW_CONTACTS", "entropy": 0, "samples": 1, "terminal": false},
+				{"type": "WELCOME", "entropy": 2, "samples": 4, "terminal": false}
+			]`,
+		},
+		{
+			name:           "No actions",
+			mockActions:    []types.Action{},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/actions/entropy", server.handleGetTransitionEntropy)
+
+			mockStore.On("GetActions").Return(tt.mockActions)
+
+			req, _ := http.NewRequest("GET", "/actions/entropy", nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetActionsSampleBySource tests filtering the sample by ingestion source.
+func TestHandleGetActionsSampleBySource(t *testing.T) {
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/sample", server.handleGetActionsSample)
+
+	mockStore.On("GetActions").Return([]types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME", Source: types.SourceFile},
+		{ID: 2, UserID: 1, Type: "CONNECT_CRM", Source: types.SourceAPI},
+		{ID: 3, UserID: 2, Type: "WELCOME", Source: types.SourceFile},
+	})
+
+	req, _ := http.NewRequest("GET", "/actions/sample?source=api", nil)
+	response := httptest.NewRecorder()
+
+	router.ServeHTTP(response, req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `[{"id": 2, "type": "CONNECT_CRM", "userId": 1, "createdAt": "0001-01-01T00:00:00Z", "source": "api"}]`, response.Body.String())
+}
+
+// TestHandleGetRecentActions tests the handleGetRecentActions endpoint.
+func TestHandleGetRecentActions(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/recent", server.handleGetRecentActions)
+
+	// Actions are sorted by user, so the newest ones are spread across the slice.
+	mockStore.On("GetActions").Return([]types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 2, UserID: 1, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(5 * time.Hour)},
+		{ID: 3, UserID: 2, Type: "WELCOME", CreatedAt: mockTime.Add(1 * time.Hour)},
+		{ID: 4, UserID: 2, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(6 * time.Hour)},
+		{ID: 5, UserID: 3, Type: "WELCOME", CreatedAt: mockTime.Add(2 * time.Hour)},
+		{ID: 6, UserID: 3, Type: "VIEW_CONTACTS", CreatedAt: mockTime.Add(5 * time.Hour)},
+	})
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedIDs    []int
+	}{
+		{name: "Newest three", query: "?n=3", expectedStatus: http.StatusOK, expectedIDs: []int{4, 6, 2}},
+		{name: "More than available", query: "?n=10", expectedStatus: http.StatusOK, expectedIDs: []int{4, 6, 2, 5, 3, 1}},
+		{name: "Default size", query: "", expectedStatus: http.StatusOK, expectedIDs: []int{4, 6, 2, 5, 3, 1}},
+		{name: "Huge size", query: "?n=2000000000", expectedStatus: http.StatusOK, expectedIDs: []int{4, 6, 2, 5, 3, 1}},
+		{name: "Invalid size", query: "?n=-1", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/actions/recent"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var recent []types.Action
+			if err := json.Unmarshal(response.Body.Bytes(), &recent); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			var ids []int
+			for _, action := range recent {
+				ids = append(ids, action.ID)
+			}
+			assert.Equal(t, tt.expectedIDs, ids)
+		})
+	}
+}
+
+// TestHandleGetTypeShare tests the handleGetTypeShare endpoint.
+func TestHandleGetTypeShare(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/type-share", server.handleGetTypeShare)
+
+	mockStore.On("GetActions").Return([]types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 2, UserID: 1, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(1 * time.Hour)},
+		{ID: 3, UserID: 1, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(24 * time.Hour)},
+		{ID: 4, UserID: 2, Type: "WELCOME", CreatedAt: mockTime.Add(2 * time.Hour)},
+		{ID: 5, UserID: 2, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(25 * time.Hour)},
+		{ID: 6, UserID: 2, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(26 * time.Hour)},
+		{ID: 7, UserID: 3, Type: "WELCOME", CreatedAt: mockTime.Add(27 * time.Hour)},
+	})
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Daily shares",
+			query:          "?granularity=day",
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"start": "2021-07-04T00:00:00Z", "total": 3, "shares": {"WELCOME": 0.6666666666666666, "CONNECT_CRM": 0.3333333333333333, "ADD_CONTACT": 0}},
+				{"start": "2021-07-05T00:00:00Z", "total": 4, "shares": {"WELCOME": 0.25, "CONNECT_CRM": 0, "ADD_CONTACT": 0.75}}
+			]`,
+		},
+		{
+			name:           "Weekly shares",
+			query:          "?granularity=week",
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"start": "2021-06-28T00:00:00Z", "total": 3, "shares": {"WELCOME": 0.6666666666666666, "CONNECT_CRM": 0.3333333333333333, "ADD_CONTACT": 0}},
+				{"start": "2021-07-05T00:00:00Z", "total": 4, "shares": {"WELCOME": 0.25, "CONNECT_CRM": 0, "ADD_CONTACT": 0.75}}
+			]`,
+		},
+		{
+			name:           "Invalid granularity",
+			query:          "?granularity=decade",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid granularity", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/actions/type-share"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetInactiveUsers tests the handleGetInactiveUsers endpoint.
+func TestHandleGetInactiveUsers(t *testing.T) {
+	users := map[int]string{1: "Alice", 2: "Bob", 3: "Carol", 4: "Dave"}
+
+	tests := []struct {
+		name           string
+		query          string
+		actionCounts   map[int]int
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Mix of active and inactive users",
+			actionCounts:   map[int]int{1: 3, 3: 1},
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"id": 2, "name": "Bob", "createdAt": "0001-01-01T00:00:00Z"},
+				{"id": 4, "name": "Dave", "createdAt": "0001-01-01T00:00:00Z"}
+			]`,
+		},
+		{
+			name:           "Paginated",
+			query:          "?limit=1&offset=1",
+			actionCounts:   map[int]int{1: 3, 3: 1},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"id": 4, "name": "Dave", "createdAt": "0001-01-01T00:00:00Z"}]`,
+		},
+		{
+			name:           "All users active",
+			actionCounts:   map[int]int{1: 1, 2: 1, 3: 1, 4: 1},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			name:           "Invalid limit",
+			query:          "?limit=abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid limit", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("UserIDs").Return([]int{1, 2, 3, 4})
+			for id, name := range users {
+				mockStore.On("CountActionsByUserID", id).Return(tt.actionCounts[id])
+				mockStore.On("GetUser", id).Return(&types.User{ID: id, Name: name})
+			}
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/users/inactive", server.handleGetInactiveUsers)
+
+			req, _ := http.NewRequest("GET", "/users/inactive"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleExportActionsCSV exports more actions than fit a page, parses the CSV back
+// and compares it to the stored actions.
+func TestHandleExportActionsCSV(t *testing.T) {
+	base := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	rng := rand.New(rand.NewSource(1))
+	actions := make([]types.Action, 2*csvExportPageSize+500)
+	for i := range actions {
+		actions[i] = types.Action{
+			ID:        i + 1,
+			UserID:    1 + rng.Intn(50),
+			Type:      types.KnownActionTypes[rng.Intn(len(types.KnownActionTypes))],
+			CreatedAt: base.Add(time.Duration(rng.Intn(100_000)) * time.Second),
+		}
+		if actions[i].Type == types.ActionReferUser {
+			actions[i].TargetUser = targetUser(1 + rng.Intn(50))
+		}
+	}
+	store := storage.NewInMemoryStorageFromData(nil, actions)
+	server := &Server{store: store}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions.csv", server.handleExportActionsCSV)
+
+	tests := []struct {
+		name            string
+		query           string
+		expectedStatus  int
+		expectedActions []types.Action
+	}{
+		{
+			name:            "All actions",
+			expectedStatus:  http.StatusOK,
+			expectedActions: store.GetActionsBetween(time.Time{}, time.Time{}),
+		},
+		{
+			name:            "Time range",
+			query:           "?from=2021-07-04T20:00:00Z&to=2021-07-05T06:00:00Z",
+			expectedStatus:  http.StatusOK,
+			expectedActions: store.GetActionsBetween(base.Add(8*time.Hour), base.Add(18*time.Hour)),
+		},
+		{
+			name:           "Invalid time range",
+			query:          "?from=2021-07-05T06:00:00Z&to=2021-07-04T20:00:00Z",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/actions.csv"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			assert.Equal(t, "text/csv; charset=utf-8", response.Header().Get("Content-Type"))
+
+			records, err := csv.NewReader(response.Body).ReadAll()
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"id", "type", "userId", "targetUser", "createdAt"}, records[0])
+
+			exported := make([]types.Action, 0, len(records)-1)
+			for _, record := range records[1:] {
+				id, _ := strconv.Atoi(record[0])
+				userID, _ := strconv.Atoi(record[2])
+				createdAt, err := time.Parse(time.RFC3339Nano, record[4])
+				assert.NoError(t, err)
+				action := types.Action{ID: id, Type: types.ActionType(record[1]), UserID: userID, CreatedAt: createdAt}
+				if record[3] != "" {
+					target, _ := strconv.Atoi(record[3])
+					action.TargetUser = &target
+				}
+				exported = append(exported, action)
+			}
+
+			// Source is not part of the export.
+			expected := make([]types.Action, len(tt.expectedActions))
+			for i, action := range tt.expectedActions {
+				action.Source = ""
+				expected[i] = action
+			}
+			assert.NotEmpty(t, expected)
+			assert.Equal(t, expected, exported)
+		})
+	}
+}
+
+// failingWriter is a response writer whose writes fail, as when the client has
+// disconnected.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+// TestHandleExportActionsCSVWriteError checks that the export stops paging through the
+// actions once writing the response fails.
+func TestHandleExportActionsCSVWriteError(t *testing.T) {
+	base := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	page := make([]types.Action, csvExportPageSize)
+	for i := range page {
+		page[i] = types.Action{ID: i + 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: base.Add(time.Duration(i) * time.Second)}
+	}
+
+	mockStore := &MockStorage{}
+	mockStore.On("ActionsByTimeAfter", mock.Anything, mock.Anything, mock.Anything, csvExportPageSize).Return(page)
+	server := &Server{store: mockStore}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/actions.csv", server.handleExportActionsCSV)
+
+	req, _ := http.NewRequest("GET", "/actions.csv", nil)
+	router.ServeHTTP(failingWriter{httptest.NewRecorder()}, req)
+
+	mockStore.AssertNumberOfCalls(t, "ActionsByTimeAfter", 1)
+}
+
+// TestHandleExportTimelines tests the handleExportTimelines endpoint.
+func TestHandleExportTimelines(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedUsers  []int
+	}{
+		{name: "All users", query: "", expectedStatus: http.StatusOK, expectedUsers: []int{1, 2}},
+		{name: "Minimum action count", query: "?minActions=2", expectedStatus: http.StatusOK, expectedUsers: []int{1}},
+		{name: "Minimum above every user", query: "?minActions=3", expectedStatus: http.StatusOK, expectedUsers: nil},
+		{name: "Invalid minimum", query: "?minActions=-1", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("ActiveUserIDs").Return([]int{1, 2})
+			mockStore.On("CountActionsByUserID", 1).Return(2)
+			mockStore.On("CountActionsByUserID", 2).Return(1)
+			mockStore.On("GetUserActions", 1).Return([]types.Action{
+				{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: mockTime},
+				{ID: 2, UserID: 1, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(time.Hour)},
+			})
+			mockStore.On("GetUserActions", 2).Return([]types.Action{
+				{ID: 3, UserID: 2, Type: "WELCOME", CreatedAt: mockTime},
+			})
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/export/timelines", server.handleExportTimelines)
+
+			req, _ := http.NewRequest("GET", "/export/timelines"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			assert.Equal(t, "application/x-ndjson", response.Header().Get("Content-Type"))
+
+			// Every line is a valid timeline of a single user, in the stored order.
+			var users []int
+			for _, line := range strings.Split(strings.TrimSpace(response.Body.String()), "\n") {
+				if line == "" {
+					continue
+				}
+
+				var timeline types.UserTimeline
+				assert.NoError(t, json.Unmarshal([]byte(line), &timeline))
+				assert.Len(t, timeline.Events, map[int]int{1: 2, 2: 1}[timeline.UserID])
+				for i := 1; i < len(timeline.Events); i++ {
+					assert.False(t, timeline.Events[i].CreatedAt.Before(timeline.Events[i-1].CreatedAt))
+				}
+				users = append(users, timeline.UserID)
+			}
+			assert.Equal(t, tt.expectedUsers, users)
+		})
+	}
+}
+
+// TestHandlePatchUser tests the handlePatchUser endpoint.
+func TestHandlePatchUser(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		userID         string
+		body           string
+		expectUpdate   bool
+		mockReturn     *types.User
+		mockErr        error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Name only",
+			userID:         "2",
+			body:           `{"name": "Alicia"}`,
+			expectUpdate:   true,
+			mockReturn:     &types.User{ID: 2, Name: "Alicia", CreatedAt: mockTime},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id": 2, "name": "Alicia", "createdAt": "2021-07-04T12:47:09.888Z"}`,
+		},
+		{
+			name:           "Immutable ID",
+			userID:         "2",
+			body:           `{"id": 3, "name": "Alicia"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Field id cannot be changed", "code": "INVALID_REQUEST_BODY"}`,
+		},
+		{
+			name:           "Immutable createdAt",
+			userID:         "2",
+			body:           `{"createdAt": "2022-01-01T00:00:00Z"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Field createdAt cannot be changed", "code": "INVALID_REQUEST_BODY"}`,
+		},
+		{
+			name:           "Unknown field",
+			userID:         "2",
+			body:           `{"nickname": "Al"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid request body", "code": "INVALID_REQUEST_BODY"}`,
+		},
+		{
+			name:           "Empty name",
+			userID:         "2",
+			body:           `{"name": ""}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Name must not be empty", "code": "INVALID_REQUEST_BODY"}`,
+		},
+		{
+			name:           "User not found",
+			userID:         "55",
+			body:           `{"name": "Bob"}`,
+			expectUpdate:   true,
+			mockErr:        storage.ErrUserNotFound,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "User not found", "code": "USER_NOT_FOUND"}`,
+		},
+		{
+			name:           "Read-only storage",
+			userID:         "2",
+			body:           `{"name": "Alicia"}`,
+			expectUpdate:   true,
+			mockErr:        storage.ErrReadOnly,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedBody:   `{"error": "Server is read-only", "code": "READ_ONLY"}`,
+		},
+		{
+			name:           "Invalid user ID",
+			userID:         "abc",
+			body:           `{"name": "Bob"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid user ID", "code": "INVALID_USER_ID"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			if tt.expectUpdate {
+				mockStore.On("UpdateUser", mock.Anything, mock.Anything).Return(tt.mockReturn, tt.mockErr)
+			}
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.PATCH("/users/:id", server.handlePatchUser)
+
+			req, _ := http.NewRequest("PATCH", "/users/"+tt.userID, strings.NewReader(tt.body))
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+			mockStore.AssertExpectations(t)
+		})
+	}
+}
+
+// TestHandleGetActions tests the handleGetActions endpoint against the in-memory storage,
+// whose actions are ordered by user rather than time.
+func TestHandleGetActions(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	store := storage.NewInMemoryStorageFromData(nil, []types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: mockTime.Add(time.Hour)},
+		{ID: 2, UserID: 1, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(3 * time.Hour)},
+		{ID: 3, UserID: 2, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 4, UserID: 2, Type: "EDIT_CONTACT", CreatedAt: mockTime.Add(2 * time.Hour)},
+		{ID: 5, UserID: 3, Type: "WELCOME", CreatedAt: mockTime.Add(time.Hour)},
+	})
+	server := &Server{store: store}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions", server.handleGetActions)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedIDs    []int
+		expectedBody   string
+	}{
+		{
+			// Actions created at the same time are ordered by ID.
+			name:           "All actions",
+			query:          "?orderBy=time",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{3, 1, 5, 4, 2},
+		},
+		{
+			// Matches the order of the storage.
+			name:           "Default order",
+			query:          "",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{1, 2, 3, 4, 5},
+		},
+		{
+			name:           "Page",
+			query:          "?orderBy=time&limit=2&offset=2",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{5, 4},
+		},
+		{
+			name:           "Offset beyond data",
+			query:          "?offset=10",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{},
+		},
+		{
+			name:           "Newest first",
+			query:          "?sort=createdAt&order=desc",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{2, 4, 5, 1, 3},
+		},
+		{
+			name:           "Newest first page",
+			query:          "?sort=createdAt&order=desc&limit=2&offset=1",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{4, 5},
+		},
+		{
+			// Matches the order of the storage.
+			name:           "By user",
+			query:          "?sort=userId",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{1, 2, 3, 4, 5},
+		},
+		{
+			name:           "By user descending",
+			query:          "?sort=userId&order=desc&limit=3",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{5, 4, 3},
+		},
+		{
+			name:           "By ID descending",
+			query:          "?sort=id&order=desc",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{5, 4, 3, 2, 1},
+		},
+		{
+			name:           "Maximum limit",
+			query:          "?limit=500",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{1, 2, 3, 4, 5},
+		},
+		{
+			// from is inclusive.
+			name:           "From timestamp",
+			query:          "?from=2021-07-04T13:47:09.888Z",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{1, 2, 4, 5},
+		},
+		{
+			// to is exclusive.
+			name:           "To timestamp",
+			query:          "?to=2021-07-04T14:47:09.888Z&sort=createdAt",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{3, 1, 5},
+		},
+		{
+			name:           "Time range",
+			query:          "?from=2021-07-04T13:47:09.888Z&to=2021-07-04T14:47:09.888Z",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{1, 5},
+		},
+		{
+			name:           "Time range newest first",
+			query:          "?from=2021-07-04T13:47:09.888Z&to=2021-07-04T15:47:09.888Z&sort=createdAt&order=desc",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{4, 5, 1},
+		},
+		{
+			name:           "Time range by user",
+			query:          "?from=2021-07-04T12:47:09.888Z&to=2021-07-04T14:47:09.888Z&sort=userId",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{1, 3, 5},
+		},
+		{
+			name:           "Empty time range",
+			query:          "?from=2021-07-04T13:47:09.888Z&to=2021-07-04T13:47:09.888Z",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{},
+		},
+		{
+			name:           "Malformed from timestamp",
+			query:          "?from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid from timestamp", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Malformed to timestamp",
+			query:          "?to=2021-07-04",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid to timestamp", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "To before from",
+			query:          "?from=2021-07-04T14:47:09.888Z&to=2021-07-04T13:47:09.888Z",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid time range", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Unsupported order",
+			query:          "?orderBy=user",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid orderBy, expected time", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Invalid sort field",
+			query:          "?sort=type",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid sort, expected createdAt, userId or id", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Invalid sort order",
+			query:          "?sort=id&order=up",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid order, expected asc or desc", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Invalid limit",
+			query:          "?limit=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid limit", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Limit above maximum",
+			query:          "?limit=501",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid limit", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/actions"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, response.Body.String())
+				return
+			}
+
+			var actions []types.Action
+			assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &actions))
+			ids := []int{}
+			for _, action := range actions {
+				ids = append(ids, action.ID)
+			}
+			assert.Equal(t, tt.expectedIDs, ids)
+		})
+	}
+
+	t.Run("Page metadata", func(t *testing.T) {
+		t.Parallel() // Enable parallel execution
+
+		tests := []struct {
+			query        string
+			expectedPage pageMeta
+		}{
+			{query: "?envelope=true&limit=2&offset=1", expectedPage: pageMeta{Total: 5, Limit: 2, Offset: 1}},
+			{query: "?envelope=true&sort=id&order=desc&limit=500", expectedPage: pageMeta{Total: 5, Limit: maxPageLimit}},
+			{query: "?envelope=true&from=2021-07-04T13:47:09.888Z&limit=1", expectedPage: pageMeta{Total: 4, Limit: 1}},
+		}
+
+		for _, tt := range tests {
+			req, _ := http.NewRequest("GET", "/actions"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, http.StatusOK, response.Code)
+			var body struct {
+				Meta meta `json:"meta"`
+			}
+			assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+			if assert.NotNil(t, body.Meta.Page, tt.query) {
+				assert.Equal(t, tt.expectedPage, *body.Meta.Page, tt.query)
+			}
+		}
+	})
+}
+
+func TestHandleDeleteAction(t *testing.T) {
+	tests := []struct {
+		name           string
+		actionID       string
+		mockErr        error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Deleted",
+			actionID:       "1",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "Unknown action",
+			actionID:       "42",
+			mockErr:        storage.ErrActionNotFound,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "Action not found", "code": "ACTION_NOT_FOUND"}`,
+		},
+		{
+			name:           "Read-only storage",
+			actionID:       "1",
+			mockErr:        storage.ErrReadOnly,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedBody:   `{"error": "Server is read-only", "code": "READ_ONLY"}`,
+		},
+		{
+			name:           "Invalid action ID",
+			actionID:       "abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid action ID", "code": "INVALID_ACTION_ID"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			if id, err := strconv.Atoi(tt.actionID); err == nil {
+				mockStore.On("DeleteAction", id).Return(tt.mockErr)
+			}
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.DELETE("/actions/:id", server.handleDeleteAction)
+
+			req, _ := http.NewRequest("DELETE", "/actions/"+tt.actionID, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			if tt.expectedBody == "" {
+				assert.Empty(t, response.Body.String())
+				return
+			}
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestDeleteActionUpdatesAnalytics deletes actions through the API and checks the
+// probability and referral endpoints, including the live referral index, reflect it.
+func TestDeleteActionUpdatesAnalytics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	base := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	store := storage.NewInMemoryStorageFromData(nil, []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 2, UserID: 1, Type: types.ActionReferUser, TargetUser: targetUser(2), CreatedAt: base.Add(time.Hour)},
+		{ID: 3, UserID: 2, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 4, UserID: 2, Type: types.ActionReferUser, TargetUser: targetUser(3), CreatedAt: base.Add(time.Hour)},
+		{ID: 5, UserID: 3, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 6, UserID: 3, Type: types.ActionAddContact, CreatedAt: base.Add(time.Hour)},
+	})
+	server := NewServer("", store, Config{})
+
+	get := func(path string) string {
+		req, _ := http.NewRequest("GET", path, nil)
+		response := httptest.NewRecorder()
+		server.router.ServeHTTP(response, req)
+		return response.Body.String()
+	}
+	remove := func(id string) {
+		req, _ := http.NewRequest("DELETE", "/actions/"+id, nil)
+		response := httptest.NewRecorder()
+		server.router.ServeHTTP(response, req)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+	}
+
+	assert.JSONEq(t, `{"1": 2, "2": 1}`, get("/users/referral-index"))
+	assert.JSONEq(t, `{"1": 2, "2": 1}`, get("/users/referrals/live"))
+	assert.JSONEq(t, `{"REFER_USER": 0.67, "ADD_CONTACT": 0.33}`, get("/actions/WELCOME/next-probability"))
+
+	// Cutting 2 → 3 also cuts user 3 off from user 1.
+	remove("4")
+	assert.JSONEq(t, `{"1": 1}`, get("/users/referral-index"))
+	assert.JSONEq(t, `{"1": 1}`, get("/users/referrals/live"))
+	assert.JSONEq(t, `{"REFER_USER": 0.5, "ADD_CONTACT": 0.5}`, get("/actions/WELCOME/next-probability"))
+
+	remove("6")
+	assert.JSONEq(t, `{"REFER_USER": 1}`, get("/actions/WELCOME/next-probability"))
+}
+
+// TestHandleCreateUser tests the handleCreateUser endpoint.
+func TestHandleCreateUser(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	// createdNow matches users created with the given ID and name at the time of the request.
+	createdNow := func(id int, name string) any {
+		return mock.MatchedBy(func(user types.User) bool {
+			return user.ID == id && user.Name == name && time.Since(user.CreatedAt) < time.Minute
+		})
+	}
+
+	tests := []struct {
+		name           string
+		body           string
+		expectCreate   any
+		mockReturn     *types.User
+		mockErr        error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Assigned ID",
+			body:           `{"name": "Bob"}`,
+			expectCreate:   createdNow(0, "Bob"),
+			mockReturn:     &types.User{ID: 6, Name: "Bob", CreatedAt: mockTime},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"id": 6, "name": "Bob", "createdAt": "2021-07-04T12:47:09.888Z"}`,
+		},
+		{
+			name:           "Explicit ID",
+			body:           `{"id": 3, "name": "Bob"}`,
+			expectCreate:   createdNow(3, "Bob"),
+			mockReturn:     &types.User{ID: 3, Name: "Bob", CreatedAt: mockTime},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"id": 3, "name": "Bob", "createdAt": "2021-07-04T12:47:09.888Z"}`,
+		},
+		{
+			name:           "Duplicate ID",
+			body:           `{"id": 1, "name": "Bob"}`,
+			expectCreate:   createdNow(1, "Bob"),
+			mockErr:        storage.ErrUserExists,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"error": "User already exists", "code": "USER_EXISTS"}`,
+		},
+		{
+			name:           "Invalid ID",
+			body:           `{"id": 0, "name": "Bob"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid user ID", "code": "INVALID_USER_ID"}`,
+		},
+		{
+			name:           "Missing name",
+			body:           `{}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Name must not be empty", "code": "INVALID_REQUEST_BODY"}`,
+		},
+		{
+			name:           "Invalid body",
+			body:           `{"name": 5}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid request body", "code": "INVALID_REQUEST_BODY"}`,
+		},
+		{
+			name:           "Read-only storage",
+			body:           `{"name": "Bob"}`,
+			expectCreate:   createdNow(0, "Bob"),
+			mockErr:        storage.ErrReadOnly,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedBody:   `{"error": "Server is read-only", "code": "READ_ONLY"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			if tt.expectCreate != nil {
+				mockStore.On("CreateUser", tt.expectCreate).Return(tt.mockReturn, tt.mockErr)
+			}
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.POST("/users", server.handleCreateUser)
+
+			req, _ := http.NewRequest("POST", "/users", strings.NewReader(tt.body))
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+			mockStore.AssertExpectations(t)
+		})
+	}
+}
+
+// TestHandleCreateAction tests the handleCreateAction endpoint.
+func TestHandleCreateAction(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	// createdRecently matches actions defaulted to the time of the request.
+	createdRecently := mock.MatchedBy(func(action types.Action) bool {
+		return action.UserID == 2 && time.Since(action.CreatedAt) < time.Minute
+	})
+
+	tests := []struct {
+		name           string
+		cfg            Config
+		body           string
+		expectCreate   any
+		mockReturn     *types.Action
+		mockErr        error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Created",
+			body:           `{"type": "WELCOME", "userId": 2, "createdAt": "2021-07-04T12:47:09.888Z"}`,
+			expectCreate:   types.Action{Type: "WELCOME", UserID: 2, CreatedAt: mockTime, Source: "api"},
+			mockReturn:     &types.Action{ID: 10, Type: "WELCOME", UserID: 2, CreatedAt: mockTime, Source: "api"},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"id": 10, "type": "WELCOME", "userId": 2, "createdAt": "2021-07-04T12:47:09.888Z", "source": "api"}`,
+		},
+		{
+			name:           "Referral with metadata",
+			body:           `{"type": "REFER_USER", "userId": 2, "targetUser": 3, "createdAt": "2021-07-04T12:47:09.888Z", "metadata": {"campaign":"spring"}}`,
+			expectCreate:   types.Action{Type: "REFER_USER", UserID: 2, TargetUser: targetUser(3), CreatedAt: mockTime, Metadata: json.RawMessage(`{"campaign":"spring"}`), Source: "api"},
+			mockReturn:     &types.Action{ID: 11, Type: "REFER_USER", UserID: 2, TargetUser: targetUser(3), CreatedAt: mockTime, Metadata: json.RawMessage(`{"campaign":"spring"}`), Source: "api"},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"id": 11, "type": "REFER_USER", "userId": 2, "targetUser": 3, "createdAt": "2021-07-04T12:47:09.888Z", "metadata": {"campaign": "spring"}, "source": "api"}`,
+		},
+		{
+			name:           "Default createdAt",
+			body:           `{"type": "WELCOME", "userId": 2}`,
+			expectCreate:   createdRecently,
+			mockReturn:     &types.Action{ID: 12, Type: "WELCOME", UserID: 2, CreatedAt: mockTime, Source: "api"},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"id": 12, "type": "WELCOME", "userId": 2, "createdAt": "2021-07-04T12:47:09.888Z", "source": "api"}`,
+		},
+		{
+			name:           "Unknown user",
+			body:           `{"type": "WELCOME", "userId": 55}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "User does not exist", "code": "USER_NOT_FOUND"}`,
+		},
+		{
+			name:           "Unknown type",
+			body:           `{"type": "WELCM", "userId": 2}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Unknown action type", "code": "INVALID_ACTION_TYPE"}`,
+		},
+		{
+			name:           "Unknown type allowed",
+			cfg:            Config{AllowUnknownActionTypes: true},
+			body:           `{"type": "SIGN_OUT", "userId": 2, "createdAt": "2021-07-04T12:47:09.888Z"}`,
+			expectCreate:   types.Action{Type: "SIGN_OUT", UserID: 2, CreatedAt: mockTime, Source: "api"},
+			mockReturn:     &types.Action{ID: 13, Type: "SIGN_OUT", UserID: 2, CreatedAt: mockTime, Source: "api"},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"id": 13, "type": "SIGN_OUT", "userId": 2, "createdAt": "2021-07-04T12:47:09.888Z", "source": "api"}`,
+		},
+		{
+			name:           "Allowlist does not apply",
+			cfg:            Config{StrictActionTypes: true, AllowedActionTypes: []types.ActionType{"CONNECT_CRM"}, AllowUnknownActionTypes: true},
+			body:           `{"type": "WELCOME", "userId": 2, "createdAt": "2021-07-04T12:47:09.888Z"}`,
+			expectCreate:   types.Action{Type: "WELCOME", UserID: 2, CreatedAt: mockTime, Source: "api"},
+			mockReturn:     &types.Action{ID: 14, Type: "WELCOME", UserID: 2, CreatedAt: mockTime, Source: "api"},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"id": 14, "type": "WELCOME", "userId": 2, "createdAt": "2021-07-04T12:47:09.888Z", "source": "api"}`,
+		},
+		{
+			name:           "Missing type",
+			body:           `{"userId": 2}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Action type is required", "code": "INVALID_ACTION_TYPE"}`,
+		},
+		{
+			name:           "Invalid body",
+			body:           `{"type": "WELCOME", "userId": "two"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid request body", "code": "INVALID_REQUEST_BODY"}`,
+		},
+		{
+			name:           "Read-only storage",
+			body:           `{"type": "WELCOME", "userId": 2}`,
+			expectCreate:   createdRecently,
+			mockErr:        storage.ErrReadOnly,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedBody:   `{"error": "Server is read-only", "code": "READ_ONLY"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetUser", 2).Return(&types.User{ID: 2, Name: "Alice", CreatedAt: mockTime}).Maybe()
+			mockStore.On("GetUser", 55).Return(nil).Maybe()
+			if tt.expectCreate != nil {
+				mockStore.On("CreateAction", tt.expectCreate).Return(tt.mockReturn, tt.mockErr)
+			}
+			server := &Server{store: mockStore, cfg: tt.cfg}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.POST("/actions", server.handleCreateAction)
+
+			req, _ := http.NewRequest("POST", "/actions", strings.NewReader(tt.body))
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+			mockStore.AssertExpectations(t)
+		})
+	}
+}
+
+// TestHandleGetSelfTargetingActions tests the handleGetSelfTargetingActions endpoint.
+func TestHandleGetSelfTargetingActions(t *testing.T) {
+	tests := []struct {
+		name           string
+		mockActions    []types.Action
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name: "Self-targeting non-referral action",
+			mockActions: []types.Action{
+				{ID: 1, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(2)},
+				{ID: 2, UserID: 1, Type: "ADD_CONTACT", TargetUser: targetUser(1)},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"id": 2, "type": "ADD_CONTACT", "userId": 1, "targetUser": 1, "createdAt": "0001-01-01T00:00:00Z"}]`,
+		},
+		{
+			name: "Clean data",
+			mockActions: []types.Action{
+				{ID: 1, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(2)},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetActions").Return(tt.mockActions)
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/actions/self-targeting", server.handleGetSelfTargetingActions)
+
+			req, _ := http.NewRequest("GET", "/actions/self-targeting", nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleBatchGetActions tests the handleBatchGetActions endpoint.
+func TestHandleBatchGetActions(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Existing and missing IDs",
+			body:           `{"ids": [2, 55, 1]}`,
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"id": 2, "type": "CONNECT_CRM", "userId": 1, "createdAt": "0001-01-01T00:00:00Z"},
+				null,
+				{"id": 1, "type": "WELCOME", "userId": 1, "createdAt": "0001-01-01T00:00:00Z"}
+			]`,
+		},
+		{
+			name:           "No IDs",
+			body:           `{"ids": []}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "At least one action ID is required", "code": "INVALID_REQUEST_BODY"}`,
+		},
+		{
+			name:           "Too many IDs",
+			body:           `{"ids": [` + strings.Repeat("1, ", maxBatchGetActions) + `1]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Too many action IDs, at most 100 are allowed", "code": "INVALID_REQUEST_BODY"}`,
+		},
+		{
+			name:           "Malformed body",
+			body:           `{"ids": "1"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid request body", "code": "INVALID_REQUEST_BODY"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetAction", 1).Return(&types.Action{ID: 1, UserID: 1, Type: "WELCOME"})
+			mockStore.On("GetAction", 2).Return(&types.Action{ID: 2, UserID: 1, Type: "CONNECT_CRM"})
+			mockStore.On("GetAction", 55).Return(nil)
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.POST("/actions/batch-get", server.handleBatchGetActions)
+
+			req, _ := http.NewRequest("POST", "/actions/batch-get", strings.NewReader(tt.body))
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetUserProfile tests the handleGetUserProfile endpoint.
+func TestHandleGetUserProfile(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	user1Actions := []types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 2, UserID: 1, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(time.Hour)},
+		{ID: 3, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(2), CreatedAt: mockTime.Add(2 * time.Hour)},
+		{ID: 4, UserID: 1, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(3 * time.Hour)},
+		{ID: 5, UserID: 1, Type: "VIEW_CONTACTS", CreatedAt: mockTime.Add(4 * time.Hour)},
+	}
+	user2Actions := []types.Action{
+		{ID: 6, UserID: 2, Type: "REFER_USER", TargetUser: targetUser(3), CreatedAt: mockTime},
+	}
+
+	tests := []struct {
+		name           string
+		userID         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Active referrer",
+			userID:         "1",
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"user": {"id": 1, "name": "Alice", "createdAt": "2021-07-04T12:47:09.888Z"},
+				"actionCount": 5,
+				"firstActivity": "2021-07-04T12:47:09.888Z",
+				"lastActivity": "2021-07-04T16:47:09.888Z",
+				"topActionTypes": [
+					{"type": "ADD_CONTACT", "count": 2},
+					{"type": "REFER_USER", "count": 1},
+					{"type": "VIEW_CONTACTS", "count": 1}
+				],
+				"referralIndex": 2
+			}`,
+		},
+		{
+			name:           "User without actions",
+			userID:         "3",
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"user": {"id": 3, "name": "Carol", "createdAt": "2021-07-04T12:47:09.888Z"},
+				"actionCount": 0,
+				"firstActivity": null,
+				"lastActivity": null,
+				"topActionTypes": [],
+				"referralIndex": 0
+			}`,
+		},
+		{
+			name:           "Unknown user",
+			userID:         "55",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "User not found", "code": "USER_NOT_FOUND"}`,
+		},
+		{
+			name:           "Invalid user ID",
+			userID:         "abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid user ID", "code": "INVALID_USER_ID"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetUser", 1).Return(&types.User{ID: 1, Name: "Alice", CreatedAt: mockTime})
+			mockStore.On("GetUser", 3).Return(&types.User{ID: 3, Name: "Carol", CreatedAt: mockTime})
+			mockStore.On("GetUser", 55).Return(nil)
+			mockStore.On("GetActions").Return(append(append([]types.Action{}, user1Actions...), user2Actions...))
+			mockStore.On("GetUserActions", 1).Return(user1Actions)
+			mockStore.On("GetUserActions", 3).Return([]types.Action{})
+			mockStore.On("CountActionsByUserID", 1).Return(len(user1Actions))
+			mockStore.On("CountActionsByUserID", 3).Return(0)
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/users/:id/profile", server.handleGetUserProfile)
+
+			req, _ := http.NewRequest("GET", "/users/"+tt.userID+"/profile", nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetUserVelocity tests the handleGetUserVelocity endpoint.
+func TestHandleGetUserVelocity(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2021, time.July, d, 12, 0, 0, 0, time.UTC)
+	}
+
+	// The newest action in the data is user 2's, on July 15th.
+	user1Actions := []types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: day(1)},
+		// Exactly 7 days before the newest action: just outside a 7-day window.
+		{ID: 2, UserID: 1, Type: "ADD_CONTACT", CreatedAt: day(8)},
+		// Just inside a 7-day window.
+		{ID: 3, UserID: 1, Type: "ADD_CONTACT", CreatedAt: day(8).Add(time.Second)},
+		{ID: 4, UserID: 1, Type: "EDIT_CONTACT", CreatedAt: day(12)},
+	}
+	user2Actions := []types.Action{
+		{ID: 5, UserID: 2, Type: "WELCOME", CreatedAt: day(15)},
+	}
+
+	tests := []struct {
+		name           string
+		userID         string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Default window",
+			userID:         "1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"userId": 1, "windowDays": 7, "windowEnd": "2021-07-15T12:00:00Z", "actions": 2, "actionsPerDay": 0.2857142857142857}`,
+		},
+		{
+			name:           "Window including the boundary action",
+			userID:         "1",
+			query:          "?windowDays=8",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"userId": 1, "windowDays": 8, "windowEnd": "2021-07-15T12:00:00Z", "actions": 3, "actionsPerDay": 0.375}`,
+		},
+		{
+			name:           "No recent actions",
+			userID:         "1",
+			query:          "?windowDays=1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"userId": 1, "windowDays": 1, "windowEnd": "2021-07-15T12:00:00Z", "actions": 0, "actionsPerDay": 0}`,
+		},
+		{
+			name:           "Action at the end of the window",
+			userID:         "2",
+			query:          "?windowDays=1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"userId": 2, "windowDays": 1, "windowEnd": "2021-07-15T12:00:00Z", "actions": 1, "actionsPerDay": 1}`,
+		},
+		{
+			name:           "Invalid window",
+			userID:         "1",
+			query:          "?windowDays=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid window", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Unknown user",
+			userID:         "55",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "User not found", "code": "USER_NOT_FOUND"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetUser", 1).Return(&types.User{ID: 1})
+			mockStore.On("GetUser", 2).Return(&types.User{ID: 2})
+			mockStore.On("GetUser", 55).Return(nil)
+			mockStore.On("GetActions").Return(append(append([]types.Action{}, user1Actions...), user2Actions...))
+			mockStore.On("GetUserActions", 1).Return(user1Actions)
+			mockStore.On("GetUserActions", 2).Return(user2Actions)
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/users/:id/velocity", server.handleGetUserVelocity)
+
+			req, _ := http.NewRequest("GET", "/users/"+tt.userID+"/velocity"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+func TestHandleGetUserCadence(t *testing.T) {
+	start := time.Date(2021, time.July, 1, 12, 0, 0, 0, time.UTC)
+	at := func(offsets ...time.Duration) []types.Action {
+		actions := make([]types.Action, 0, len(offsets))
+		for i, offset := range offsets {
+			actions = append(actions, types.Action{ID: i + 1, Type: "ADD_CONTACT", CreatedAt: start.Add(offset)})
+		}
+		return actions
+	}
+
+	tests := []struct {
+		name           string
+		userID         string
+		actions        []types.Action
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Evenly spaced",
+			userID:         "1",
+			actions:        at(0, time.Hour, 2*time.Hour, 3*time.Hour),
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"userId": 1, "samples": 3,
+				"minSeconds": 3600, "maxSeconds": 3600, "meanSeconds": 3600, "medianSeconds": 3600, "p90Seconds": 3600,
+				"formatted": {"min": "1h", "max": "1h", "mean": "1h", "median": "1h"}
+			}`,
+		},
+		{
+			// Gaps of 1m, 30m and 2d 1h.
+			name:           "Unevenly spaced",
+			userID:         "1",
+			actions:        at(0, time.Minute, 31*time.Minute, 49*time.Hour+31*time.Minute),
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"userId": 1, "samples": 3,
+				"minSeconds": 60, "maxSeconds": 176400, "meanSeconds": 59420, "medianSeconds": 1800, "p90Seconds": 141480,
+				"formatted": {"min": "1m", "max": "2d 1h", "mean": "16h 30m", "median": "30m"}
+			}`,
+		},
+		{
+			name:           "Single action",
+			userID:         "1",
+			actions:        at(0),
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"userId": 1, "samples": 0,
+				"minSeconds": null, "maxSeconds": null, "meanSeconds": null, "medianSeconds": null, "p90Seconds": null,
+				"formatted": {"min": null, "max": null, "mean": null, "median": null}
+			}`,
+		},
+		{
+			name:           "No actions",
+			userID:         "1",
+			actions:        []types.Action{},
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"userId": 1, "samples": 0,
+				"minSeconds": null, "maxSeconds": null, "meanSeconds": null, "medianSeconds": null, "p90Seconds": null,
+				"formatted": {"min": null, "max": null, "mean": null, "median": null}
+			}`,
+		},
+		{
+			name:           "Unknown user",
+			userID:         "55",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "User not found", "code": "USER_NOT_FOUND"}`,
+		},
+		{
+			name:           "Invalid user ID",
+			userID:         "abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid user ID", "code": "INVALID_USER_ID"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetUser", 1).Return(&types.User{ID: 1})
+			mockStore.On("GetUser", 55).Return(nil)
+			mockStore.On("GetUserActions", 1).Return(tt.actions)
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/users/:id/actions/cadence", server.handleGetUserCadence)
+
+			req, _ := http.NewRequest("GET", "/users/"+tt.userID+"/actions/cadence", nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetUserNextActionProbability tests the handleGetUserNextActionProbability
+// endpoint.
+func TestHandleGetUserNextActionProbability(t *testing.T) {
+	// Globally, ADD_CONTACT is followed by EDIT_CONTACT 3 times out of 4, and
+	// EDIT_CONTACT always by ADD_CONTACT. User 1 last added a contact, user 2 last edited
+	// one, and user 3 has no actions.
+	user1Actions := []types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME"},
+		{ID: 2, UserID: 1, Type: "ADD_CONTACT"},
+		{ID: 3, UserID: 1, Type: "EDIT_CONTACT"},
+		{ID: 4, UserID: 1, Type: "ADD_CONTACT"},
+	}
+	user2Actions := []types.Action{
+		{ID: 5, UserID: 2, Type: "ADD_CONTACT"},
+		{ID: 6, UserID: 2, Type: "VIEW_CONTACTS"},
+		{ID: 7, UserID: 2, Type: "ADD_CONTACT"},
+		{ID: 8, UserID: 2, Type: "EDIT_CONTACT"},
+		{ID: 9, UserID: 2, Type: "ADD_CONTACT"},
+		{ID: 10, UserID: 2, Type: "EDIT_CONTACT"},
+	}
+
+	tests := []struct {
+		name           string
+		userID         string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Likely next action",
+			userID:         "1",
+			query:          "?type=EDIT_CONTACT",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"userId": 1, "lastActionType": "ADD_CONTACT", "type": "EDIT_CONTACT", "probability": 0.75}`,
+		},
+		{
+			name:           "Never the next action",
+			userID:         "1",
+			query:          "?type=WELCOME",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"userId": 1, "lastActionType": "ADD_CONTACT", "type": "WELCOME", "probability": 0}`,
+		},
+		{
+			name:           "Other last action",
+			userID:         "2",
+			query:          "?type=ADD_CONTACT",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"userId": 2, "lastActionType": "EDIT_CONTACT", "type": "ADD_CONTACT", "probability": 1}`,
+		},
+		{
+			name:           "User without actions",
+			userID:         "3",
+			query:          "?type=WELCOME",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"userId": 3, "lastActionType": null, "type": "WELCOME", "probability": null}`,
+		},
+		{
+			name:           "Missing type",
+			userID:         "1",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Action type is required", "code": "INVALID_ACTION_TYPE"}`,
+		},
+		{
+			name:           "Unknown user",
+			userID:         "55",
+			query:          "?type=WELCOME",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "User not found", "code": "USER_NOT_FOUND"}`,
+		},
+		{
+			name:           "Invalid user ID",
+			userID:         "abc",
+			query:          "?type=WELCOME",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid user ID", "code": "INVALID_USER_ID"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("GetUser", 1).Return(&types.User{ID: 1})
+			mockStore.On("GetUser", 2).Return(&types.User{ID: 2})
+			mockStore.On("GetUser", 3).Return(&types.User{ID: 3})
+			mockStore.On("GetUser", 55).Return(nil)
+			mockStore.On("GetActions").Return(append(append([]types.Action{}, user1Actions...), user2Actions...))
+			mockStore.On("GetUserActions", 1).Return(user1Actions)
+			mockStore.On("GetUserActions", 2).Return(user2Actions)
+			mockStore.On("GetUserActions", 3).Return([]types.Action{})
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/users/:id/next-probability-for", server.handleGetUserNextActionProbability)
+
+			req, _ := http.NewRequest("GET", "/users/"+tt.userID+"/next-probability-for"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetInsertPosition tests the handleGetInsertPosition endpoint.
+func TestHandleGetInsertPosition(t *testing.T) {
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/admin/debug/insert-position", server.handleGetInsertPosition)
+
+	createdAt := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	mockStore.On("InsertPosition", 2, createdAt).Return(7)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Valid query",
+			query:          "?userId=2&createdAt=2021-07-04T12:00:00Z",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"index": 7}`,
+		},
+		{
+			name:           "Invalid user ID",
+			query:          "?userId=abc&createdAt=2021-07-04T12:00:00Z",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid user ID", "code": "INVALID_USER_ID"}`,
+		},
+		{
+			name:           "Missing timestamp",
+			query:          "?userId=2",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid createdAt timestamp", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/admin/debug/insert-position"+tt.query, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleResort tests the handleResort endpoint.
+func TestHandleResort(t *testing.T) {
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.POST("/admin/resort", server.handleResort)
+
+	mockStore.On("Resort").Return(3, nil)
+
+	req, _ := http.NewRequest("POST", "/admin/resort", nil)
+	response := httptest.NewRecorder()
+
+	router.ServeHTTP(response, req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"moved": 3}`, response.Body.String())
+}
+
+// TestHandleCheckConsistency tests the handleCheckConsistency endpoint.
+func TestHandleCheckConsistency(t *testing.T) {
+	tests := []struct {
+		name           string
+		report         types.ConsistencyReport
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Consistent",
+			report:         types.ConsistencyReport{Consistent: true, Version: 4, Drift: []string{}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"consistent": true, "version": 4, "drift": []}`,
+		},
+		{
+			name:           "Drift",
+			report:         types.ConsistencyReport{Version: 4, Drift: []string{"actionCountByUser: 1 = 5, expected 2"}},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"consistent": false, "version": 4, "drift": ["actionCountByUser: 1 = 5, expected 2"]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockStore := &MockStorage{}
+			mockStore.On("CheckConsistency").Return(tt.report)
+			server := &Server{store: mockStore}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.Default()
+			router.GET("/admin/consistency", server.handleCheckConsistency)
+
+			req, _ := http.NewRequest("GET", "/admin/consistency", nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetActionsByUserID tests the handleGetActionsByUserID endpoint.
+func TestHandleGetActionsByUserID(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/users/:id/actions", server.handleGetActionsByUserID)
+
+	mockStore.On("GetUser", 1).Return(&types.User{ID: 1, Name: "Tom", CreatedAt: mockTime})
+	mockStore.On("GetUser", 2).Return(&types.User{ID: 2, Name: "Alice", CreatedAt: mockTime})
+	mockStore.On("GetUser", 3).Return(nil)
+	mockStore.On("GetUserActions", 1).Return([]types.Action{
+		{ID: 4, UserID: 1, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 9, UserID: 1, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(time.Hour)},
+		{ID: 12, UserID: 1, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(2 * time.Hour)},
+	})
+	mockStore.On("GetUserActions", 2).Return([]types.Action{})
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "All actions",
+			path:           "/users/1/actions",
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"id": 4, "type": "WELCOME", "userId": 1, "createdAt": "2021-07-04T12:47:09.888Z"},
+				{"id": 9, "type": "CONNECT_CRM", "userId": 1, "createdAt": "2021-07-04T13:47:09.888Z"},
+				{"id": 12, "type": "ADD_CONTACT", "userId": 1, "createdAt": "2021-07-04T14:47:09.888Z"}
+			]`,
+		},
+		{
+			name:           "Page of actions",
+			path:           "/users/1/actions?limit=1&offset=1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"id": 9, "type": "CONNECT_CRM", "userId": 1, "createdAt": "2021-07-04T13:47:09.888Z"}]`,
+		},
+		{
+			name:           "Offset beyond actions",
+			path:           "/users/1/actions?offset=3",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			// from is inclusive and to is exclusive.
+			name:           "Actions within time range",
+			path:           "/users/1/actions?from=2021-07-04T13:47:09.888Z&to=2021-07-04T14:47:09.888Z",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"id": 9, "type": "CONNECT_CRM", "userId": 1, "createdAt": "2021-07-04T13:47:09.888Z"}]`,
+		},
+		{
+			name:           "Malformed to timestamp",
+			path:           "/users/1/actions?to=tomorrow",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid to timestamp", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "User without actions",
+			path:           "/users/2/actions",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			name:           "User not found",
+			path:           "/users/3/actions",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error": "User not found", "code": "USER_NOT_FOUND"}`,
+		},
+		{
+			name:           "Invalid User ID (non-numeric)",
+			path:           "/users/abc/actions",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid user ID", "code": "INVALID_USER_ID"}`,
+		},
+		{
+			name:           "Invalid limit",
+			path:           "/users/1/actions?limit=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid limit", "code": "INVALID_PARAMETER"}`,
+		},
+		{
+			name:           "Invalid offset",
+			path:           "/users/1/actions?offset=-1",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid offset", "code": "INVALID_PARAMETER"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetIndexedUserActions tests the handleGetIndexedUserActions endpoint.
+func TestHandleGetIndexedUserActions(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/users/:id/actions/indexed", server.handleGetIndexedUserActions)
+
+	mockStore.On("GetUserActions", 1).Return([]types.Action{
+		{ID: 4, UserID: 1, Type: "WELCOME", CreatedAt: mockTime},
+		{ID: 9, UserID: 1, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(time.Hour)},
+		{ID: 12, UserID: 1, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(2 * time.Hour)},
+	})
+	mockStore.On("GetUserActions", 2).Return([]types.Action{})
+
+	tests := []struct {
+		name           string
+		userID         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Positions follow the timeline",
+			userID:         "1",
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"id": 4, "type": "WELCOME", "userId": 1, "createdAt": "2021-07-04T12:47:09.888Z", "position": 1},
+				{"id": 9, "type": "CONNECT_CRM", "userId": 1, "createdAt": "2021-07-04T13:47:09.888Z", "position": 2},
+				{"id": 12, "type": "ADD_CONTACT", "userId": 1, "createdAt": "2021-07-04T14:47:09.888Z", "position": 3}
+			]`,
+		},
+		{
+			name:           "User without actions",
+			userID:         "2",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			name:           "Invalid User ID (non-numeric)",
+			userID:         "abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error": "Invalid user ID", "code": "INVALID_USER_ID"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/users/"+tt.userID+"/actions/indexed", nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetFirstActionTypes tests the handleGetFirstActionTypes endpoint.
+func TestHandleGetFirstActionTypes(t *testing.T) {
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/first", server.handleGetFirstActionTypes)
+
+	// Users 1 and 3 start with WELCOME, user 2 with CONNECT_CRM.
+	mockStore.On("GetActions").Return([]types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME"},
+		{ID: 2, UserID: 1, Type: "CONNECT_CRM"},
+		{ID: 3, UserID: 2, Type: "CONNECT_CRM"},
+		{ID: 4, UserID: 2, Type: "WELCOME"},
+		{ID: 5, UserID: 3, Type: "WELCOME"},
+	})
+
+	req, _ := http.NewRequest("GET", "/actions/first", nil)
+	response := httptest.NewRecorder()
+
+	router.ServeHTTP(response, req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `[
+		{"type": "WELCOME", "count": 2},
+		{"type": "CONNECT_CRM", "count": 1}
+	]`, response.Body.String())
+}
+
+// TestReadOnlyMode checks that a server over read-only storage serves reads and
+// rejects writes with 405.
+func TestReadOnlyMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := NewServer("", storage.NewReadOnlyStorage(storage.NewFakeStorage()), Config{})
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"Read user", "GET", "/users/1", "", http.StatusOK},
+		{"Read action count", "GET", "/users/1/actions/count", "", http.StatusOK},
+		{"Create user", "POST", "/users", `{"name": "Bob"}`, http.StatusMethodNotAllowed},
+		{"Update user", "PATCH", "/users/1", `{"name": "Alicia"}`, http.StatusMethodNotAllowed},
+		{"Create action", "POST", "/actions", `{"type": "WELCOME", "userId": 1}`, http.StatusMethodNotAllowed},
+		{"Re-sort actions", "POST", "/admin/resort", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			response := httptest.NewRecorder()
+
+			server.router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			if tt.expectedStatus == http.StatusMethodNotAllowed {
+				assert.JSONEq(t, `{"error": "Server is read-only", "code": "READ_ONLY"}`, response.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleGetGapStats tests the handleGetGapStats endpoint.
+func TestHandleGetGapStats(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/actions/:type/gap-stats", server.handleGetGapStats)
+
+	// WELCOME is followed after 10, 20, 30, 40 and 100 seconds. The gap across users
+	// 5 and 6 does not count.
+	var actions []types.Action
+	for userID, gap := range []int{10, 40, 20, 100, 30} {
+		actions = append(actions,
+			types.Action{ID: 2 * userID, UserID: userID, Type: "WELCOME", CreatedAt: mockTime},
+			types.Action{ID: 2*userID + 1, UserID: userID, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(time.Duration(gap) * time.Second)},
+		)
+	}
+	actions = append(actions,
+		types.Action{ID: 20, UserID: 5, Type: "WELCOME", CreatedAt: mockTime},
+		types.Action{ID: 21, UserID: 6, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(time.Hour)},
+	)
+	mockStore.On("GetActions").Return(actions)
+
+	tests := []struct {
+		name           string
+		actionType     string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Known gaps",
+			actionType:     "WELCOME",
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"samples": 5,
+				"minSeconds": 10,
+				"maxSeconds": 100,
+				"meanSeconds": 40,
+				"medianSeconds": 30,
+				"p90Seconds": 76
+			}`,
+		},
+		{
+			name:           "No samples",
+			actionType:     "ADD_CONTACT",
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"samples": 0,
+				"minSeconds": null,
+				"maxSeconds": null,
+				"meanSeconds": null,
+				"medianSeconds": null,
+				"p90Seconds": null
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", "/actions/"+tt.actionType+"/gap-stats", nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+// TestHandleGetUsersBySignup tests the handleGetNewestUsers and handleGetOldestUsers
+// endpoints.
+func TestHandleGetUsersBySignup(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	// Set up mock storage.
+	mockStore := &MockStorage{}
+	server := &Server{store: mockStore}
+
+	// Set up Gin router
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/users/newest", server.handleGetNewestUsers)
+	router.GET("/users/oldest", server.handleGetOldestUsers)
+
+	// Users 2 and 4 signed up at the same time.
+	users := []types.User{
+		{ID: 1, Name: "Alice", CreatedAt: mockTime.Add(time.Hour)},
+		{ID: 2, Name: "Bob", CreatedAt: mockTime},
+		{ID: 3, Name: "Carol", CreatedAt: mockTime.Add(2 * time.Hour)},
+		{ID: 4, Name: "Dave", CreatedAt: mockTime},
+	}
+	mockStore.On("UserIDs").Return([]int{1, 2, 3, 4})
+	for i := range users {
+		mockStore.On("GetUser", users[i].ID).Return(&users[i])
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedIDs    []int
+	}{
+		{
+			name:           "Newest first",
+			path:           "/users/newest",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{3, 1, 2, 4},
+		},
+		{
+			name:           "Oldest first",
+			path:           "/users/oldest",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{2, 4, 1, 3},
+		},
+		{
+			name:           "Newest with limit",
+			path:           "/users/newest?limit=2",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{3, 1},
+		},
+		{
+			name:           "Oldest with limit",
+			path:           "/users/oldest?limit=3",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{2, 4, 1},
+		},
+		{
+			name:           "Invalid limit",
+			path:           "/users/oldest?limit=0",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var got []types.User
+			assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &got))
+			var ids []int
+			for _, user := range got {
+				assert.False(t, user.CreatedAt.IsZero())
+				ids = append(ids, user.ID)
+			}
+			assert.Equal(t, tt.expectedIDs, ids)
+		})
+	}
+}
+
+// TestRoutePaths checks that the routes registered by NewServer are reachable under
+// their documented paths, so a misspelled registration cannot silently return 404.
+func TestRoutePaths(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockStore := &MockStorage{}
+	mockStore.On("GetActions").Return([]types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME"},
+		{ID: 2, UserID: 1, Type: "REFER_USER", TargetUser: targetUser(2)},
+		{ID: 3, UserID: 2, Type: "WELCOME"},
+	})
+	server := NewServer("", mockStore, Config{})
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedBody string
+	}{
+		{
+			name:         "Next action probability",
+			path:         "/actions/WELCOME/next-probability",
+			expectedBody: `{"REFER_USER": 1}`,
+		},
+		{
+			name:         "Referral index",
+			path:         "/users/referral-index",
+			expectedBody: `{"1": 1}`,
+		},
+		{
+			name:         "Misspelled referral index",
+			path:         "/users/referal-index",
+			expectedBody: `{"1": 1}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			response := httptest.NewRecorder()
+
+			server.router.ServeHTTP(response, req)
+
+			assert.Equal(t, http.StatusOK, response.Code)
+
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := NewServer("", new(MockStorage), Config{})
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	server.router.GET("/slow", func(c *gin.Context) {
+		close(entered)
+		<-release
+		c.String(http.StatusOK, "done")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.httpServer.Serve(listener)
+	}()
+	url := "http://" + listener.Addr().String()
+
+	type result struct {
+		status int
+		err    error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		response, err := http.Get(url + "/slow")
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		response.Body.Close()
+		responses <- result{status: response.StatusCode}
+	}()
+	<-entered
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- server.Shutdown(context.Background())
+	}()
+
+	// Shutdown waits for the request in flight.
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned before the request completed: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	response := <-responses
+	assert.NoError(t, response.err)
+	assert.Equal(t, http.StatusOK, response.status)
+	assert.NoError(t, <-shutdown)
+	assert.ErrorIs(t, <-served, http.ErrServerClosed)
+
+	// New connections are refused once the server is shut down.
+	_, err = http.Get(url + "/slow")
+	assert.Error(t, err)
+}
+
+func TestHealthProbes(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		ready          bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Live when not ready",
+			path:           "/healthz",
+			ready:          false,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status": "ok"}`,
+		},
+		{
+			name:           "Live when ready",
+			path:           "/healthz",
+			ready:          true,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status": "ok"}`,
+		},
+		{
+			name:           "Not ready",
+			path:           "/readyz",
+			ready:          false,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"status": "unavailable"}`,
+		},
+		{
+			name:           "Ready",
+			path:           "/readyz",
+			ready:          true,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status": "ok"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			gin.SetMode(gin.TestMode)
+			// Probes are not enveloped even when envelopes are the default.
+			server := NewServer("", new(MockStorage), Config{EnvelopeResponses: true})
+			server.SetReady(tt.ready)
+
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			response := httptest.NewRecorder()
+
+			server.router.ServeHTTP(response, req)
+
+			assert.Equal(t, tt.expectedStatus, response.Code)
+			assert.JSONEq(t, tt.expectedBody, response.Body.String())
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadableFlags are the flags re-read from the -config file on SIGHUP. The others,
+// such as -listenaddr, only take effect on restart.
+var reloadableFlags = map[string]bool{
+	"envelope":          true,
+	"referralMaxVisits": true,
+	"emptyAs200":        true,
+	"logSampleRate":     true,
+	"slowRequest":       true,
+	"roundingMode":      true,
+	"rateLimit":         true,
+	"rateBurst":         true,
+}
+
+// readConfigFile reads a JSON object mapping flag names to values, e.g.
+// {"logSampleRate": 0.1, "slowRequest": "2s"}.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		if name == "config" || flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown flag %q in %s", name, path)
+		}
+
+		// Strings are unquoted, other values such as numbers are kept as written, so
+		// that large integers are not turned into floats like 1e+06.
+		var s string
+		if err := json.Unmarshal(value, &s); err == nil {
+			values[name] = s
+		} else {
+			values[name] = string(value)
+		}
+	}
+
+	return values, nil
+}
+
+// setFlags sets the flags to the given values, except those in explicit, which were
+// given on the command line and take precedence. If a value is invalid the flags are
+// left unchanged. The returned function restores the previous values.
+func setFlags(values map[string]string, explicit map[string]bool) (restore func(), err error) {
+	previous := make(map[string]string)
+	restore = func() {
+		for name, value := range previous {
+			flag.Set(name, value)
+		}
+	}
+
+	for name, value := range values {
+		if explicit[name] {
+			continue
+		}
+
+		previous[name] = flag.Lookup(name).Value.String()
+		if err := flag.Set(name, value); err != nil {
+			restore()
+			return nil, fmt.Errorf("invalid value %q for flag -%s: %w", value, name, err)
+		}
+	}
+
+	return restore, nil
+}
+
+// reloadOnHangup re-reads the config file on every SIGHUP, sets the reloadable flags
+// from it and calls apply. If the file or apply fails, the error is logged and the
+// flags are left unchanged.
+func reloadOnHangup(path string, explicit map[string]bool, apply func() error) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	for range hangup {
+		log.Printf("Reloading %s", path)
+		if err := reloadConfigFile(path, explicit, apply); err != nil {
+			log.Printf("Failed to reload %s: %v", path, err)
+		}
+	}
+}
+
+// reloadConfigFile sets the reloadable flags from the config file and calls apply.
+// Changes to other flags are logged as requiring a restart.
+func reloadConfigFile(path string, explicit map[string]bool, apply func() error) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	reloadable := make(map[string]string)
+	for name, value := range values {
+		switch {
+		case reloadableFlags[name]:
+			reloadable[name] = value
+		case !explicit[name] && flag.Lookup(name).Value.String() != value:
+			log.Printf("Ignoring changed -%s, it only takes effect on restart", name)
+		}
+	}
+
+	restore, err := setFlags(reloadable, explicit)
+	if err != nil {
+		return err
+	}
+	if err := apply(); err != nil {
+		restore()
+		return err
+	}
+
+	return nil
+}
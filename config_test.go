@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	// The flags are defined in main, which tests do not run.
+	flag.Int("referralMaxVisits", 0, "")
+	flag.Float64("logSampleRate", 1, "")
+	flag.Duration("slowRequest", time.Second, "")
+	flag.Bool("emptyAs200", false, "")
+}
+
+// TestReadConfigFile tests reading flag values from a config file.
+func TestReadConfigFile(t *testing.T) {
+	tests := []struct {
+		name           string
+		content        string
+		expectedValues map[string]string
+		expectedError  bool
+	}{
+		{
+			name:           "Values of every kind",
+			content:        `{"logSampleRate": 0.1, "slowRequest": "2s", "emptyAs200": true}`,
+			expectedValues: map[string]string{"logSampleRate": "0.1", "slowRequest": "2s", "emptyAs200": "true"},
+		},
+		{
+			name:           "Large integer",
+			content:        `{"referralMaxVisits": 1000000}`,
+			expectedValues: map[string]string{"referralMaxVisits": "1000000"},
+		},
+		{name: "Unknown flag", content: `{"noSuchFlag": 1}`, expectedError: true},
+		{name: "Invalid JSON", content: `{"logSampleRate": }`, expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatalf("Failed to write config file: %v", err)
+			}
+
+			values, err := readConfigFile(path)
+
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedValues, values)
+
+			// The values are accepted by the flags they are meant for.
+			for name, value := range values {
+				assert.NoError(t, flag.Lookup(name).Value.Set(value))
+			}
+		})
+	}
+}
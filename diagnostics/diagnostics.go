@@ -0,0 +1,30 @@
+package diagnostics
+
+import (
+	"fmt"
+
+	"github.com/klemis/user-actions-api/types"
+)
+
+// SelfTargeting returns the actions whose target is the acting user, whatever their
+// type. Such actions are usually a bug in the source data.
+func SelfTargeting(actions []types.Action) []types.Action {
+	selfTargeting := []types.Action{}
+	for _, action := range actions {
+		if action.TargetUser != nil && *action.TargetUser == action.UserID {
+			selfTargeting = append(selfTargeting, action)
+		}
+	}
+
+	return selfTargeting
+}
+
+// Validate runs every check on the actions and describes each problem found.
+func Validate(actions []types.Action) []string {
+	var problems []string
+	for _, action := range SelfTargeting(actions) {
+		problems = append(problems, fmt.Sprintf("action %d: %s by user %d targets the acting user", action.ID, action.Type, action.UserID))
+	}
+
+	return problems
+}
@@ -0,0 +1,68 @@
+package diagnostics
+
+import (
+	"testing"
+
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// targetUser returns a pointer to the ID, for setting Action.TargetUser.
+func targetUser(id int) *int {
+	return &id
+}
+
+func TestSelfTargeting(t *testing.T) {
+	tests := []struct {
+		name     string
+		actions  []types.Action
+		expected []types.Action
+	}{
+		{
+			name: "Self-targeting non-referral action",
+			actions: []types.Action{
+				{ID: 1, UserID: 1, Type: types.ActionReferUser, TargetUser: targetUser(2)},
+				{ID: 2, UserID: 1, Type: types.ActionAddContact, TargetUser: targetUser(1)},
+				{ID: 3, UserID: 2, Type: types.ActionWelcome},
+			},
+			expected: []types.Action{
+				{ID: 2, UserID: 1, Type: types.ActionAddContact, TargetUser: targetUser(1)},
+			},
+		},
+		{
+			name: "Self-referral",
+			actions: []types.Action{
+				{ID: 1, UserID: 3, Type: types.ActionReferUser, TargetUser: targetUser(3)},
+			},
+			expected: []types.Action{
+				{ID: 1, UserID: 3, Type: types.ActionReferUser, TargetUser: targetUser(3)},
+			},
+		},
+		{
+			name: "Clean data",
+			actions: []types.Action{
+				{ID: 1, UserID: 1, Type: types.ActionReferUser, TargetUser: targetUser(2)},
+				{ID: 2, UserID: 1, Type: types.ActionWelcome},
+			},
+			expected: []types.Action{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			assert.Equal(t, tt.expected, SelfTargeting(tt.actions))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	actions := []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionWelcome},
+		{ID: 2, UserID: 1, Type: types.ActionEditContact, TargetUser: targetUser(1)},
+	}
+
+	assert.Equal(t, []string{"action 2: EDIT_CONTACT by user 1 targets the acting user"}, Validate(actions))
+	assert.Empty(t, Validate(actions[:1]))
+}
@@ -1,23 +1,289 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/klemis/user-actions-api/api"
+	"github.com/klemis/user-actions-api/diagnostics"
 	"github.com/klemis/user-actions-api/storage"
+	"github.com/klemis/user-actions-api/types"
 )
 
 func main() {
 	listenAddr := flag.String("listenaddr", ":8080", "api server address")
+	envelope := flag.Bool("envelope", false, "wrap responses in a data/meta envelope by default")
+	backend := flag.String("storage", "memory", "storage backend ("+strings.Join(storage.Backends(), ", ")+")")
+	usersFile := flag.String("users", "users.json", "path or http(s) URL of the users data file")
+	actionsFile := flag.String("actions", "actions.json", "path or http(s) URL of the actions data file")
+	loadTimeout := flag.Duration("loadTimeout", 0, "maximum time to load each data file (0 for no limit)")
+	strict := flag.Bool("strict", false, "reject unknown fields in the data files")
+	maxReferralVisits := flag.Int("referralMaxVisits", 0, "maximum users visited when computing the referral index (0 for no limit)")
+	maxConcurrent := flag.Int("maxConcurrent", 0, "maximum concurrent in-flight requests (0 for no limit)")
+	strictTypes := flag.Bool("strictTypes", false, "reject action types in requests that are not upper-case letters and underscores")
+	allowedTypes := flag.String("allowedTypes", "", "with -strictTypes, comma-separated action types the probability and transition endpoints accept, or \"known\" for the well-known types (empty for no restriction)")
+	allowUnknownTypes := flag.Bool("allowUnknownTypes", false, "accept actions of types other than the well-known ones in POST /actions")
+	zeroTargetValid := flag.Bool("zeroTargetValid", false, "count referrals to user 0, for data whose user IDs start at 0")
+	emptyAs200 := flag.Bool("emptyAs200", false, "return an empty referral index with 200 instead of 404")
+	validate := flag.Bool("validate", false, "check the data for problems and exit instead of serving")
+	groupLimits := flag.String("groupLimits", "", "maximum concurrent requests per endpoint group, e.g. analytics=4,export=1")
+	logSampleRate := flag.Float64("logSampleRate", 1, "fraction of requests logged; failed and slow requests are always logged")
+	slowRequest := flag.Duration("slowRequest", time.Second, "duration from which a request is always logged (0 to disable)")
+	jsonLogs := flag.Bool("jsonLogs", false, "write request logs as JSON lines, for log aggregators")
+	roundingMode := flag.String("roundingMode", api.RoundHalfUp, "rounding of probabilities ("+api.RoundHalfUp+" or "+api.RoundHalfEven+")")
+	readOnly := flag.Bool("readonly", false, "serve reads only and reject every mutation with 405, for read replicas")
+	maxActionTypes := flag.Int("maxActionTypes", 0, "maximum distinct action types; actions of further types are stored as OTHER (0 for no cap)")
+	persist := flag.String("persist", "", "write runtime mutations back to the local data files ("+storage.PersistWriteThrough+" or "+storage.PersistPeriodic+", empty to disable)")
+	flushInterval := flag.Duration("flushInterval", 30*time.Second, "how often data is written back with -persist "+storage.PersistPeriodic)
+	mock := flag.Bool("mock", false, "serve generated fake data instead of loading data files (development only)")
+	shutdownTimeout := flag.Duration("shutdownTimeout", 10*time.Second, "maximum time to wait for requests in flight when shutting down")
+	rateLimit := flag.Float64("rateLimit", 0, "requests per second allowed per client IP (0 for no limit)")
+	rateBurst := flag.Int("rateBurst", 20, "requests a client may make at once before -rateLimit applies")
+	gzipMinSize := flag.Int("gzipMinSize", 1024, "size in bytes from which responses are gzip-compressed for clients accepting it (0 to disable)")
+	corsOrigins := flag.String("corsOrigins", "", "comma-separated browser origins allowed to call the API, or * for any origin (empty disables CORS)")
+	apiKey := flag.String("apikey", "", "API key required on every request except the health probes, defaults to $API_KEY (empty disables authentication)")
+	configFile := flag.String("config", "", "JSON file of flag values, e.g. {\"logSampleRate\": 0.1}; flags on the command line take precedence, and the log, rounding, envelope, referral and rate limit settings are reloaded on SIGHUP")
 	flag.Parse()
 
-	store, err := storage.NewInMemoryStorage("users.json", "actions.json")
+	// Flags given on the command line take precedence over the config file.
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	if *configFile != "" {
+		values, err := readConfigFile(*configFile)
+		if err != nil {
+			log.Fatalf("Failed to read -config: %v", err)
+		}
+		if _, err := setFlags(values, explicit); err != nil {
+			log.Fatalf("Invalid -config: %v", err)
+		}
+	}
+	// The key is read from the environment here rather than as the flag default, which
+	// -help and usage errors would print.
+	if *apiKey == "" {
+		*apiKey = os.Getenv("API_KEY")
+	}
+
+	if *mock {
+		// Mock mode must never be mistaken for a real deployment, so any explicit
+		// data source is an error rather than being silently ignored.
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "storage", "users", "actions", "validate", "persist":
+				log.Fatalf("-mock cannot be combined with -%s", f.Name)
+			}
+		})
+		*backend = "mock"
+		log.Println("WARNING: running in mock mode, all responses are generated fake data")
+	}
+
+	if err := validateRoundingMode(*roundingMode); err != nil {
+		log.Fatal(err)
+	}
+
+	switch *persist {
+	case "", storage.PersistWriteThrough:
+	case storage.PersistPeriodic:
+		if *flushInterval <= 0 {
+			log.Fatal("-persist periodic requires a positive -flushInterval")
+		}
+	default:
+		log.Fatalf("Invalid -persist %q, expected %s or %s", *persist, storage.PersistWriteThrough, storage.PersistPeriodic)
+	}
+
+	allowedActionTypes := parseAllowedTypes(*allowedTypes)
+	if len(allowedActionTypes) > 0 && !*strictTypes {
+		log.Fatal("-allowedTypes requires -strictTypes")
+	}
+
+	if err := validateRateLimit(*rateLimit, *rateBurst); err != nil {
+		log.Fatal(err)
+	}
+
+	groupConcurrencyLimits, err := parseGroupLimits(*groupLimits)
+	if err != nil {
+		log.Fatalf("Invalid -groupLimits: %v", err)
+	}
+
+	store, err := storage.New(*backend, storage.Config{
+		UsersFile:      *usersFile,
+		ActionsFile:    *actionsFile,
+		StrictDecoding: *strict,
+		LoadTimeout:    *loadTimeout,
+		MaxActionTypes: *maxActionTypes,
+		PersistMode:    *persist,
+		FlushInterval:  *flushInterval,
+	})
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 
-	server := api.NewServer(*listenAddr, store)
+	if *readOnly {
+		store = storage.NewReadOnlyStorage(store)
+		log.Println("Serving read-only, mutations are rejected")
+	}
+
+	if *validate {
+		problems := diagnostics.Validate(store.GetActions())
+		for _, problem := range problems {
+			log.Println(problem)
+		}
+		if len(problems) > 0 {
+			log.Printf("Validation found %d problems", len(problems))
+			os.Exit(1)
+		}
+		log.Println("Validation found no problems")
+		return
+	}
+
+	apiConfig := func() api.Config {
+		return api.Config{
+			EnvelopeResponses:       *envelope,
+			MaxReferralVisits:       *maxReferralVisits,
+			MaxConcurrentRequests:   *maxConcurrent,
+			StrictActionTypes:       *strictTypes,
+			AllowedActionTypes:      allowedActionTypes,
+			AllowUnknownActionTypes: *allowUnknownTypes,
+			ZeroTargetUserValid:     *zeroTargetValid,
+			EmptyReferralIndexAs200: *emptyAs200,
+			GroupConcurrencyLimits:  groupConcurrencyLimits,
+			LogSampleRate:           *logSampleRate,
+			SlowRequestThreshold:    *slowRequest,
+			JSONLogs:                *jsonLogs,
+			RoundingMode:            *roundingMode,
+			RateLimit:               *rateLimit,
+			RateBurst:               *rateBurst,
+			CompressMinSize:         *gzipMinSize,
+			CORSOrigins:             parseList(*corsOrigins),
+			APIKey:                  *apiKey,
+		}
+	}
+	server := api.NewServer(*listenAddr, store, apiConfig())
+	// The storage is fully loaded by now.
+	server.SetReady(true)
+	if *configFile != "" {
+		go reloadOnHangup(*configFile, explicit, func() error {
+			if err := validateRoundingMode(*roundingMode); err != nil {
+				return err
+			}
+			if err := validateRateLimit(*rateLimit, *rateBurst); err != nil {
+				return err
+			}
+			server.Reload(apiConfig())
+			return nil
+		})
+	}
 	log.Println("API server running on port: ", *listenAddr)
-	log.Fatal(server.Start())
+	go func() {
+		if err := server.Start(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	shutdown(server, store, *shutdownTimeout)
+}
+
+// shutdown stops the server, letting requests in flight complete within the timeout,
+// and then writes pending changes of the storage to disk.
+func shutdown(server *api.Server, store storage.Storage, timeout time.Duration) {
+	log.Println("Shutting down")
+	server.SetReady(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Failed to shut down gracefully: %v", err)
+	}
+
+	if flusher, ok := store.(storage.Flusher); ok {
+		if err := flusher.Flush(); err != nil {
+			log.Printf("Failed to flush data: %v", err)
+		}
+	}
+	log.Println("Server stopped")
+}
+
+// validateRoundingMode checks the -roundingMode flag value.
+func validateRoundingMode(mode string) error {
+	if mode != api.RoundHalfUp && mode != api.RoundHalfEven {
+		return fmt.Errorf("invalid -roundingMode %q, expected %s or %s", mode, api.RoundHalfUp, api.RoundHalfEven)
+	}
+
+	return nil
+}
+
+// validateRateLimit checks the -rateBurst flag value against -rateLimit.
+func validateRateLimit(rate float64, burst int) error {
+	if rate > 0 && burst < 1 {
+		return errors.New("-rateBurst must be at least 1 with -rateLimit")
+	}
+
+	return nil
+}
+
+// parseGroupLimits parses a comma-separated list of group=limit pairs.
+func parseGroupLimits(value string) (map[string]int, error) {
+	limits := make(map[string]int)
+	if value == "" {
+		return limits, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		group, limit, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			return nil, fmt.Errorf("%q is not of the form group=limit", pair)
+		}
+		if group != api.GroupAnalytics && group != api.GroupExport {
+			return nil, fmt.Errorf("unknown endpoint group %q", group)
+		}
+
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid limit %q for group %s", limit, group)
+		}
+		limits[group] = n
+	}
+
+	return limits, nil
+}
+
+// parseAllowedTypes parses a comma-separated list of action types. "known" stands for
+// the well-known action types.
+func parseAllowedTypes(value string) []types.ActionType {
+	if value == "known" {
+		return types.KnownActionTypes
+	}
+
+	var allowed []types.ActionType
+	for _, actionType := range parseList(value) {
+		allowed = append(allowed, types.ActionType(actionType))
+	}
+
+	return allowed
+}
+
+// parseList splits a comma-separated flag value, dropping empty items.
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
 }
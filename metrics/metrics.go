@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/klemis/user-actions-api/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// otherActionType is the label used for action types outside the known set,
+// which keeps the label cardinality bounded regardless of the data.
+const otherActionType = "other"
+
+// knownActionTypes are the action types that get their own label value.
+var knownActionTypes = make(map[types.ActionType]bool)
+
+func init() {
+	for _, actionType := range types.KnownActionTypes {
+		knownActionTypes[actionType] = true
+	}
+}
+
+var (
+	// ActionsCreated counts ingested actions by type.
+	ActionsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "actions_created_total",
+		Help: "Total number of actions ingested, by action type.",
+	}, []string{"type"})
+
+	// UsersCreated counts ingested users.
+	UsersCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "users_created_total",
+		Help: "Total number of users ingested.",
+	})
+
+	// ReferralsRecorded counts ingested referral actions.
+	ReferralsRecorded = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "referrals_recorded_total",
+		Help: "Total number of referrals recorded.",
+	})
+)
+
+var (
+	// HTTPRequests counts served requests by method, route and status.
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests served, by method, route and status.",
+	}, []string{"method", "path", "status"})
+
+	// HTTPRequestDuration observes the time taken to serve requests by route.
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests, by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"path"})
+)
+
+// UnmatchedRoute is the path label of requests that matched no route, which keeps
+// arbitrary URLs out of the labels.
+const UnmatchedRoute = "unmatched"
+
+// RecordRequest updates the HTTP metrics for a served request. The route is the
+// template the request matched, e.g. /users/:id, not the requested URL.
+func RecordRequest(method, route string, status int, duration time.Duration) {
+	if route == "" {
+		route = UnmatchedRoute
+	}
+	HTTPRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	HTTPRequestDuration.WithLabelValues(route).Observe(duration.Seconds())
+}
+
+// RecordAction updates the domain counters for an ingested action.
+func RecordAction(action types.Action) {
+	ActionsCreated.WithLabelValues(actionTypeLabel(action.Type)).Inc()
+	if action.Type == types.ActionReferUser {
+		ReferralsRecorded.Inc()
+	}
+}
+
+// RecordUser updates the domain counters for an ingested user.
+func RecordUser() {
+	UsersCreated.Inc()
+}
+
+// actionTypeLabel normalizes an action type to a bounded label value.
+func actionTypeLabel(actionType types.ActionType) string {
+	if knownActionTypes[actionType] {
+		return string(actionType)
+	}
+	return otherActionType
+}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/klemis/user-actions-api/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAction(t *testing.T) {
+	welcome := testutil.ToFloat64(ActionsCreated.WithLabelValues("WELCOME"))
+	other := testutil.ToFloat64(ActionsCreated.WithLabelValues(otherActionType))
+	referrals := testutil.ToFloat64(ReferralsRecorded)
+
+	RecordAction(types.Action{Type: "WELCOME"})
+	RecordAction(types.Action{Type: "WELCM"})
+	RecordAction(types.Action{Type: "NOT_A_TYPE"})
+	target := 2
+	RecordAction(types.Action{Type: "REFER_USER", TargetUser: &target})
+
+	assert.Equal(t, welcome+1, testutil.ToFloat64(ActionsCreated.WithLabelValues("WELCOME")))
+	assert.Equal(t, other+2, testutil.ToFloat64(ActionsCreated.WithLabelValues(otherActionType)))
+	assert.Equal(t, referrals+1, testutil.ToFloat64(ReferralsRecorded))
+}
+
+func TestRecordUser(t *testing.T) {
+	users := testutil.ToFloat64(UsersCreated)
+
+	RecordUser()
+	RecordUser()
+
+	assert.Equal(t, users+2, testutil.ToFloat64(UsersCreated))
+}
+
+func TestRecordRequest(t *testing.T) {
+	ok := testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", "/users/:id", "200"))
+	notFound := testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", UnmatchedRoute, "404"))
+
+	RecordRequest("GET", "/users/:id", 200, 10*time.Millisecond)
+	RecordRequest("GET", "/users/:id", 200, 20*time.Millisecond)
+	RecordRequest("GET", "", 404, time.Millisecond)
+
+	assert.Equal(t, ok+2, testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", "/users/:id", "200")))
+	assert.Equal(t, notFound+1, testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", UnmatchedRoute, "404")))
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/klemis/user-actions-api/types"
+)
+
+// CheckConsistency verifies the maintained indices against indices rebuilt from the
+// actions, to catch bugs in the paths updating them. Every mismatch is logged and
+// described in the report.
+func (s *InMemoryStorage) CheckConsistency() types.ConsistencyReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	expected := buildIndices(s.actions)
+
+	drift := []string{}
+	drift = append(drift, mapDrift("userIndex", s.userIndex, expected.userIndex, func(a, b userSpan) bool { return a == b })...)
+	drift = append(drift, mapDrift("typeIndex", s.typeIndex, expected.typeIndex, slices.Equal[[]int])...)
+	drift = append(drift, mapDrift("actionCountByUser", s.actionCountByUser, expected.actionCountByUser, func(a, b int) bool { return a == b })...)
+	drift = append(drift, mapDrift("actionIndex", s.actionIndex, expected.actionIndex, func(a, b int) bool { return a == b })...)
+	if i, differs := firstDifference(s.timeIndex, expected.timeIndex); differs {
+		drift = append(drift, fmt.Sprintf("timeIndex: differs from the rebuilt order at position %d", i))
+	}
+
+	for _, d := range drift {
+		log.Printf("WARNING: index drift: %s", d)
+	}
+
+	return types.ConsistencyReport{
+		Consistent: len(drift) == 0,
+		Version:    s.version,
+		Drift:      drift,
+	}
+}
+
+// mapDrift describes the entries of the maintained index that are missing, unexpected
+// or different from the rebuilt one, in key order.
+func mapDrift[K cmp.Ordered, V any](name string, got, want map[K]V, equal func(V, V) bool) []string {
+	keys := make([]K, 0, len(got)+len(want))
+	for key := range want {
+		keys = append(keys, key)
+	}
+	for key := range got {
+		if _, ok := want[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	var drift []string
+	for _, key := range keys {
+		g, inGot := got[key]
+		w, inWant := want[key]
+		switch {
+		case !inGot:
+			drift = append(drift, fmt.Sprintf("%s: missing %v, expected %v", name, key, w))
+		case !inWant:
+			drift = append(drift, fmt.Sprintf("%s: unexpected %v = %v", name, key, g))
+		case !equal(g, w):
+			drift = append(drift, fmt.Sprintf("%s: %v = %v, expected %v", name, key, g, w))
+		}
+	}
+
+	return drift
+}
+
+// firstDifference returns the first position at which the slices differ, including
+// positions beyond the end of the shorter one.
+func firstDifference(got, want []int) (int, bool) {
+	for i := 0; i < min(len(got), len(want)); i++ {
+		if got[i] != want[i] {
+			return i, true
+		}
+	}
+	if len(got) != len(want) {
+		return min(len(got), len(want)), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	createdAt := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	newStorage := func() *InMemoryStorage {
+		return NewInMemoryStorageFromData(map[int]types.User{
+			1: {ID: 1, Name: "Tom", CreatedAt: createdAt},
+			2: {ID: 2, Name: "Alice", CreatedAt: createdAt},
+		}, []types.Action{
+			{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: createdAt},
+			{ID: 2, UserID: 1, Type: types.ActionConnectCRM, CreatedAt: createdAt.Add(time.Hour)},
+			{ID: 3, UserID: 2, Type: types.ActionWelcome, CreatedAt: createdAt.Add(time.Minute)},
+		})
+	}
+
+	tests := []struct {
+		name          string
+		corrupt       func(s *InMemoryStorage)
+		expectedDrift []string
+	}{
+		{
+			name:          "Consistent",
+			corrupt:       func(*InMemoryStorage) {},
+			expectedDrift: []string{},
+		},
+		{
+			name: "Wrong count",
+			corrupt: func(s *InMemoryStorage) {
+				s.actionCountByUser[1] = 5
+			},
+			expectedDrift: []string{"actionCountByUser: 1 = 5, expected 2"},
+		},
+		{
+			name: "Missing and unexpected types",
+			corrupt: func(s *InMemoryStorage) {
+				s.typeIndex["ADD_CONTACT"] = s.typeIndex[types.ActionConnectCRM]
+				delete(s.typeIndex, types.ActionConnectCRM)
+			},
+			expectedDrift: []string{
+				"typeIndex: unexpected ADD_CONTACT = [1]",
+				"typeIndex: missing CONNECT_CRM, expected [1]",
+			},
+		},
+		{
+			name: "Stale user span and action position",
+			corrupt: func(s *InMemoryStorage) {
+				s.userIndex[2] = userSpan{start: 1, end: 3}
+				s.actionIndex[3] = 1
+			},
+			expectedDrift: []string{
+				"userIndex: 2 = {1 3}, expected {2 3}",
+				"actionIndex: 3 = 1, expected 2",
+			},
+		},
+		{
+			name: "Wrong time order",
+			corrupt: func(s *InMemoryStorage) {
+				s.timeIndex[1], s.timeIndex[2] = s.timeIndex[2], s.timeIndex[1]
+			},
+			expectedDrift: []string{"timeIndex: differs from the rebuilt order at position 1"},
+		},
+		{
+			name: "Truncated time order",
+			corrupt: func(s *InMemoryStorage) {
+				s.timeIndex = s.timeIndex[:2]
+			},
+			expectedDrift: []string{"timeIndex: differs from the rebuilt order at position 2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			storage := newStorage()
+			tt.corrupt(storage)
+
+			report := storage.CheckConsistency()
+			assert.Equal(t, len(tt.expectedDrift) == 0, report.Consistent)
+			assert.Equal(t, uint64(1), report.Version)
+			assert.Equal(t, tt.expectedDrift, report.Drift)
+		})
+	}
+}
+
+// TestCheckConsistencyAfterMutations checks that the mutation paths keep the indices
+// consistent.
+func TestCheckConsistencyAfterMutations(t *testing.T) {
+	createdAt := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	storage := NewInMemoryStorageFromData(map[int]types.User{
+		1: {ID: 1, Name: "Tom", CreatedAt: createdAt},
+	}, []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: createdAt},
+	})
+
+	_, err := storage.CreateUser(types.User{ID: 2, Name: "Alice", CreatedAt: createdAt})
+	assert.NoError(t, err)
+	_, err = storage.CreateAction(types.Action{UserID: 2, Type: types.ActionWelcome, CreatedAt: createdAt.Add(-time.Hour)})
+	assert.NoError(t, err)
+	_, err = storage.CreateAction(types.Action{UserID: 1, Type: types.ActionAddContact, CreatedAt: createdAt.Add(-time.Minute)})
+	assert.NoError(t, err)
+	_, err = storage.Resort()
+	assert.NoError(t, err)
+
+	report := storage.CheckConsistency()
+	assert.True(t, report.Consistent)
+	assert.Empty(t, report.Drift)
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes where a storage backend loads its data from. UsersFile and
+// ActionsFile are local paths or http(s) URLs.
+type Config struct {
+	UsersFile   string
+	ActionsFile string
+	// StrictDecoding rejects unknown fields in the source data.
+	StrictDecoding bool
+	// LoadTimeout bounds how long loading each data source may take. Zero means no limit.
+	LoadTimeout time.Duration
+	// PersistMode writes runtime mutations back to local data files, PersistWriteThrough
+	// or PersistPeriodic every FlushInterval. Empty disables persistence.
+	PersistMode   string
+	FlushInterval time.Duration
+	// MaxActionTypes caps the number of distinct action types; actions of further types
+	// are stored as OTHER. Zero means no cap.
+	MaxActionTypes int
+}
+
+// StorageFactory constructs a Storage backend from the given config.
+type StorageFactory func(cfg Config) (Storage, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]StorageFactory)
+)
+
+func init() {
+	Register("memory", func(cfg Config) (Storage, error) {
+		var opts []Option
+		if cfg.StrictDecoding {
+			opts = append(opts, WithStrictDecoding())
+		}
+		if cfg.LoadTimeout > 0 {
+			opts = append(opts, WithLoadTimeout(cfg.LoadTimeout))
+		}
+		if cfg.MaxActionTypes > 0 {
+			opts = append(opts, WithMaxActionTypes(cfg.MaxActionTypes))
+		}
+		if cfg.PersistMode != "" {
+			opts = append(opts, WithPersistence(cfg.PersistMode, cfg.FlushInterval))
+		}
+
+		return NewInMemoryStorage(cfg.UsersFile, cfg.ActionsFile, opts...)
+	})
+	Register("mock", func(Config) (Storage, error) {
+		return NewFakeStorage(), nil
+	})
+}
+
+// Register makes a storage backend available under the given name.
+// It panics if a backend with the same name is already registered.
+func Register(name string, factory StorageFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("storage: Register factory is nil")
+	}
+	if _, exists := factories[name]; exists {
+		panic("storage: Register called twice for backend " + name)
+	}
+	factories[name] = factory
+}
+
+// New constructs the storage backend registered under the given name.
+func New(name string, cfg Config) (Storage, error) {
+	factoriesMu.RLock()
+	factory, exists := factories[name]
+	factoriesMu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown storage backend %q (available: %s)", name, strings.Join(Backends(), ", "))
+	}
+
+	return factory(cfg)
+}
+
+// Backends returns the sorted names of the registered storage backends.
+func Backends() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
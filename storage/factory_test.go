@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStorage is a minimal Storage used to exercise the factory registry.
+type fakeStorage struct {
+	Storage
+	cfg Config
+}
+
+func (f *fakeStorage) GetUser(id int) *types.User {
+	return &types.User{ID: id, Name: f.cfg.UsersFile}
+}
+
+func TestNewRegisteredBackend(t *testing.T) {
+	Register("fake", func(cfg Config) (Storage, error) {
+		return &fakeStorage{cfg: cfg}, nil
+	})
+
+	store, err := New("fake", Config{UsersFile: "fake-users.json"})
+	assert.NoError(t, err)
+	assert.Equal(t, &types.User{ID: 1, Name: "fake-users.json"}, store.GetUser(1))
+
+	assert.Contains(t, Backends(), "fake")
+	assert.Contains(t, Backends(), "memory")
+}
+
+func TestNewUnknownBackend(t *testing.T) {
+	store, err := New("unknown", Config{})
+	assert.Nil(t, store)
+	assert.ErrorContains(t, err, `unknown storage backend "unknown"`)
+}
+
+func TestRegisterDuplicateBackend(t *testing.T) {
+	assert.Panics(t, func() {
+		Register("memory", func(cfg Config) (Storage, error) { return nil, nil })
+	})
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/klemis/user-actions-api/metrics"
+	"github.com/klemis/user-actions-api/types"
+)
+
+// Shape of the generated fake data.
+const (
+	fakeSeed           = 1
+	fakeUsers          = 50
+	fakeMaxUserActions = 12
+)
+
+// fakeEpoch is the creation time of the first fake user.
+var fakeEpoch = time.Date(2021, time.July, 1, 9, 0, 0, 0, time.UTC)
+
+// NewFakeStorage returns a storage filled with generated users and actions, for
+// developing clients without real data. The data is the same on every call.
+func NewFakeStorage() Storage {
+	rng := rand.New(rand.NewSource(fakeSeed))
+
+	storage := &InMemoryStorage{
+		users:   make(map[int]types.User, fakeUsers),
+		actions: []types.Action{},
+	}
+
+	nextActionID := 1
+	for userID := 1; userID <= fakeUsers; userID++ {
+		createdAt := fakeEpoch.Add(time.Duration(userID) * time.Hour)
+		storage.users[userID] = types.User{ID: userID, Name: fmt.Sprintf("User %d", userID), CreatedAt: createdAt}
+		metrics.RecordUser()
+
+		// Every user starts with a welcome, followed by a random mix of actions.
+		at := createdAt
+		for i := 0; i < 1+rng.Intn(fakeMaxUserActions); i++ {
+			action := types.Action{
+				ID:        nextActionID,
+				Type:      types.ActionWelcome,
+				UserID:    userID,
+				CreatedAt: at,
+				Source:    types.SourceMock,
+			}
+			if i > 0 {
+				action.Type = types.KnownActionTypes[1+rng.Intn(len(types.KnownActionTypes)-1)]
+			}
+			if action.Type == types.ActionReferUser {
+				target := 1 + rng.Intn(fakeUsers)
+				action.TargetUser = &target
+			}
+
+			storage.actions = append(storage.actions, action)
+			metrics.RecordAction(action)
+			nextActionID++
+			at = at.Add(time.Duration(1+rng.Intn(48*60)) * time.Minute)
+		}
+	}
+
+	// Actions are generated per user in time order, so they are already sorted.
+	storage.warmup()
+	storage.version = 1
+
+	return storage
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/klemis/user-actions-api/types"
+)
+
+// userSpan is the range [start, end) of a user's actions within the sorted actions slice.
+type userSpan struct {
+	start int
+	end   int
+}
+
+// indices holds the lookup structures derived from the actions slice.
+type indices struct {
+	userIndex         map[int]userSpan
+	typeIndex         map[types.ActionType][]int
+	actionCountByUser map[int]int
+	actionIndex       map[int]int
+	timeIndex         []int
+}
+
+// warmup builds the per-user, per-type, count, ID and time indices concurrently. The builders
+// only read the actions slice, and the indices are swapped in together under the write
+// lock once all builders are done.
+func (s *InMemoryStorage) warmup() {
+	s.mu.RLock()
+	actions := s.actions
+	s.mu.RUnlock()
+
+	idx := buildIndices(actions)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setIndices(idx)
+}
+
+// buildIndices runs the index builders concurrently, each filling its own map.
+func buildIndices(actions []types.Action) indices {
+	var (
+		wg  sync.WaitGroup
+		idx indices
+	)
+	wg.Add(5)
+	go func() {
+		defer wg.Done()
+		idx.userIndex = buildUserIndex(actions)
+	}()
+	go func() {
+		defer wg.Done()
+		idx.typeIndex = buildTypeIndex(actions)
+	}()
+	go func() {
+		defer wg.Done()
+		idx.actionCountByUser = buildActionCountByUser(actions)
+	}()
+	go func() {
+		defer wg.Done()
+		idx.actionIndex = buildActionIndex(actions)
+	}()
+	go func() {
+		defer wg.Done()
+		idx.timeIndex = buildTimeIndex(actions)
+	}()
+	wg.Wait()
+
+	return idx
+}
+
+// setIndices replaces the indices, and raises lastActionID to the highest indexed ID.
+// The caller must hold the write lock.
+func (s *InMemoryStorage) setIndices(idx indices) {
+	s.userIndex = idx.userIndex
+	s.typeIndex = idx.typeIndex
+	s.actionCountByUser = idx.actionCountByUser
+	s.actionIndex = idx.actionIndex
+	s.timeIndex = idx.timeIndex
+	for id := range idx.actionIndex {
+		s.lastActionID = max(s.lastActionID, id)
+	}
+}
+
+// indexInsertedAction updates the indices for an action inserted into the actions
+// slice at pos, shifting the positions after it instead of rebuilding the indices. The
+// caller must hold the write lock.
+func (s *InMemoryStorage) indexInsertedAction(pos int, action types.Action) {
+	shift := func(p int) int {
+		if p >= pos {
+			return p + 1
+		}
+		return p
+	}
+
+	for userID, span := range s.userIndex {
+		if userID != action.UserID && span.start >= pos {
+			s.userIndex[userID] = userSpan{start: span.start + 1, end: span.end + 1}
+		}
+	}
+	// The action was inserted within or right after its user's span, if there is one.
+	span, exists := s.userIndex[action.UserID]
+	if !exists {
+		span = userSpan{start: pos, end: pos}
+	}
+	span.end++
+	s.userIndex[action.UserID] = span
+
+	for _, positions := range s.typeIndex {
+		for i, p := range positions {
+			positions[i] = shift(p)
+		}
+	}
+	positions := s.typeIndex[action.Type]
+	s.typeIndex[action.Type] = slices.Insert(positions, sort.SearchInts(positions, pos), pos)
+
+	for id, p := range s.actionIndex {
+		s.actionIndex[id] = shift(p)
+	}
+	s.actionIndex[action.ID] = pos
+
+	for i, p := range s.timeIndex {
+		s.timeIndex[i] = shift(p)
+	}
+	at := sort.Search(len(s.timeIndex), func(i int) bool {
+		return createdBefore(action, s.actions[s.timeIndex[i]])
+	})
+	s.timeIndex = slices.Insert(s.timeIndex, at, pos)
+
+	s.actionCountByUser[action.UserID]++
+}
+
+// indexDeletedAction updates the indices for the action that was at pos in the actions
+// slice before it was deleted, shifting the positions after it instead of rebuilding
+// the indices. The caller must hold the write lock.
+func (s *InMemoryStorage) indexDeletedAction(pos int, action types.Action) {
+	shift := func(p int) int {
+		if p > pos {
+			return p - 1
+		}
+		return p
+	}
+
+	span := s.userIndex[action.UserID]
+	span.end--
+	if span.start == span.end {
+		delete(s.userIndex, action.UserID)
+	} else {
+		s.userIndex[action.UserID] = span
+	}
+	for userID, span := range s.userIndex {
+		if span.start > pos {
+			s.userIndex[userID] = userSpan{start: span.start - 1, end: span.end - 1}
+		}
+	}
+
+	positions := s.typeIndex[action.Type]
+	at := sort.SearchInts(positions, pos)
+	positions = slices.Delete(positions, at, at+1)
+	if len(positions) == 0 {
+		delete(s.typeIndex, action.Type)
+	} else {
+		s.typeIndex[action.Type] = positions
+	}
+	for _, positions := range s.typeIndex {
+		for i, p := range positions {
+			positions[i] = shift(p)
+		}
+	}
+
+	delete(s.actionIndex, action.ID)
+	for id, p := range s.actionIndex {
+		s.actionIndex[id] = shift(p)
+	}
+
+	s.timeIndex = slices.DeleteFunc(s.timeIndex, func(p int) bool { return p == pos })
+	for i, p := range s.timeIndex {
+		s.timeIndex[i] = shift(p)
+	}
+
+	if s.actionCountByUser[action.UserID]--; s.actionCountByUser[action.UserID] == 0 {
+		delete(s.actionCountByUser, action.UserID)
+	}
+}
+
+// createdBefore reports whether a comes before b in the time index: created earlier,
+// or at the same time with a lower ID.
+func createdBefore(a, b types.Action) bool {
+	if a.CreatedAt.Equal(b.CreatedAt) {
+		return a.ID < b.ID
+	}
+	return a.CreatedAt.Before(b.CreatedAt)
+}
+
+// buildUserIndex maps each user to the span of their actions. The actions must be
+// sorted by user.
+func buildUserIndex(actions []types.Action) map[int]userSpan {
+	index := make(map[int]userSpan)
+	for start := 0; start < len(actions); {
+		end := start + 1
+		for end < len(actions) && actions[end].UserID == actions[start].UserID {
+			end++
+		}
+		index[actions[start].UserID] = userSpan{start: start, end: end}
+		start = end
+	}
+
+	return index
+}
+
+// buildTypeIndex maps each action type to the positions of its actions.
+func buildTypeIndex(actions []types.Action) map[types.ActionType][]int {
+	index := make(map[types.ActionType][]int)
+	for i, action := range actions {
+		index[action.Type] = append(index[action.Type], i)
+	}
+
+	return index
+}
+
+// buildActionCountByUser maps each user to the number of their actions.
+func buildActionCountByUser(actions []types.Action) map[int]int {
+	counts := make(map[int]int)
+	for _, action := range actions {
+		counts[action.UserID]++
+	}
+
+	return counts
+}
+
+// buildActionIndex maps each action ID to its position. If the data contains an ID
+// more than once, the first occurrence wins.
+func buildActionIndex(actions []types.Action) map[int]int {
+	index := make(map[int]int, len(actions))
+	for i, action := range actions {
+		if _, exists := index[action.ID]; !exists {
+			index[action.ID] = i
+		}
+	}
+
+	return index
+}
+
+// buildTimeIndex returns the positions of the actions ordered by createdAt across all
+// users. Actions created at the same time are ordered by ID.
+func buildTimeIndex(actions []types.Action) []int {
+	index := make([]int, len(actions))
+	for i := range index {
+		index[i] = i
+	}
+	sort.Slice(index, func(i, j int) bool {
+		return createdBefore(actions[index[i]], actions[index[j]])
+	})
+
+	return index
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarmup(t *testing.T) {
+	storage := &InMemoryStorage{
+		actions: []types.Action{
+			{ID: 1, UserID: 1, Type: "WELCOME"},
+			{ID: 2, UserID: 1, Type: "CONNECT_CRM"},
+			{ID: 3, UserID: 1, Type: "WELCOME"},
+			{ID: 4, UserID: 2, Type: "EDIT_CONTACT"},
+			{ID: 5, UserID: 3, Type: "WELCOME"},
+			{ID: 6, UserID: 3, Type: "VIEW_CONTACTS"},
+		},
+	}
+
+	storage.warmup()
+
+	assert.Equal(t, map[int]userSpan{
+		1: {start: 0, end: 3},
+		2: {start: 3, end: 4},
+		3: {start: 4, end: 6},
+	}, storage.userIndex)
+	assert.Equal(t, map[types.ActionType][]int{
+		"WELCOME":       {0, 2, 4},
+		"CONNECT_CRM":   {1},
+		"EDIT_CONTACT":  {3},
+		"VIEW_CONTACTS": {5},
+	}, storage.typeIndex)
+	assert.Equal(t, map[int]int{1: 3, 2: 1, 3: 2}, storage.actionCountByUser)
+	assert.Equal(t, map[int]int{1: 0, 2: 1, 3: 2, 4: 3, 5: 4, 6: 5}, storage.actionIndex)
+	// Every action was created at the same time, so they are ordered by ID.
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, storage.timeIndex)
+}
+
+func TestWarmupEmpty(t *testing.T) {
+	storage := &InMemoryStorage{actions: []types.Action{}}
+
+	storage.warmup()
+
+	assert.Empty(t, storage.userIndex)
+	assert.Empty(t, storage.typeIndex)
+	assert.Empty(t, storage.actionCountByUser)
+	assert.Empty(t, storage.actionIndex)
+	assert.Empty(t, storage.timeIndex)
+}
+
+// TestIndicesAfterMutations checks the indices updated by random creates and deletes
+// against indices rebuilt from the actions.
+func TestIndicesAfterMutations(t *testing.T) {
+	start := time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC)
+	storage := NewInMemoryStorageFromData(nil, []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: start},
+		{ID: 2, UserID: 2, Type: types.ActionWelcome, CreatedAt: start},
+	})
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		if rng.Intn(3) == 0 {
+			actions := storage.GetActions()
+			if len(actions) > 0 {
+				assert.NoError(t, storage.DeleteAction(actions[rng.Intn(len(actions))].ID))
+			}
+			continue
+		}
+
+		_, err := storage.CreateAction(types.Action{
+			UserID:    rng.Intn(10),
+			Type:      types.KnownActionTypes[rng.Intn(len(types.KnownActionTypes))],
+			CreatedAt: start.Add(time.Duration(rng.Intn(100)) * time.Minute),
+		})
+		assert.NoError(t, err)
+	}
+
+	report := storage.CheckConsistency()
+	assert.True(t, report.Consistent, report.Drift)
+}
+
+// countByScan counts the actions of a user by scanning all actions, as a reference for
+// the count index.
+func countByScan(actions []types.Action, userID int) int {
+	count := 0
+	for _, action := range actions {
+		if action.UserID == userID {
+			count++
+		}
+	}
+
+	return count
+}
+
+func TestActionCountIndexAfterInserts(t *testing.T) {
+	storage := NewInMemoryStorageFromData(nil, []types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME"},
+		{ID: 2, UserID: 2, Type: "WELCOME"},
+	})
+
+	rng := rand.New(rand.NewSource(1))
+	start := time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 200; i++ {
+		_, err := storage.CreateAction(types.Action{
+			UserID:    rng.Intn(10),
+			Type:      "ADD_CONTACT",
+			CreatedAt: start.Add(time.Duration(rng.Intn(1000)) * time.Minute),
+		})
+		assert.NoError(t, err)
+	}
+
+	actions := storage.GetActions()
+	for userID := 0; userID < 12; userID++ {
+		assert.Equal(t, countByScan(actions, userID), storage.CountActionsByUserID(userID), "user %d", userID)
+	}
+}
+
+func BenchmarkCountActionsByUserID(b *testing.B) {
+	actions := make([]types.Action, 0, 1_000_000)
+	for i := 0; i < cap(actions); i++ {
+		actions = append(actions, types.Action{ID: i, UserID: i / 20, Type: "WELCOME"})
+	}
+	storage := NewInMemoryStorageFromData(nil, actions)
+
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			countByScan(actions, i%50_000)
+		}
+	})
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			storage.CountActionsByUserID(i % 50_000)
+		}
+	})
+}
+
+// BenchmarkCreateAction measures the cost of keeping the indices, including the action
+// counts, up to date on every insert.
+func BenchmarkCreateAction(b *testing.B) {
+	start := time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC)
+	for _, size := range []int{10_000, 100_000} {
+		b.Run(fmt.Sprintf("actions=%d", size), func(b *testing.B) {
+			actions := make([]types.Action, 0, size)
+			for i := 0; i < size; i++ {
+				actions = append(actions, types.Action{ID: i + 1, UserID: i / 20, Type: "WELCOME", CreatedAt: start.Add(time.Duration(i) * time.Second)})
+			}
+			storage := NewInMemoryStorageFromData(nil, actions)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				storage.CreateAction(types.Action{UserID: i % (size / 20), Type: "ADD_CONTACT", CreatedAt: start.Add(time.Duration(i) * time.Second)})
+			}
+		})
+	}
+}
+
+func BenchmarkWarmup(b *testing.B) {
+	actionTypes := types.KnownActionTypes
+	actions := make([]types.Action, 0, 1_000_000)
+	for i := 0; i < cap(actions); i++ {
+		actions = append(actions, types.Action{ID: i, UserID: i / 20, Type: actionTypes[i%len(actionTypes)]})
+	}
+
+	for _, size := range []int{10_000, 100_000, 1_000_000} {
+		b.Run(fmt.Sprintf("actions=%d", size), func(b *testing.B) {
+			storage := &InMemoryStorage{actions: actions[:size]}
+			for i := 0; i < b.N; i++ {
+				storage.warmup()
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/klemis/user-actions-api/types"
+)
+
+// observer is a callback registered with Subscribe.
+type observer struct {
+	id int
+	fn func(types.StorageEvent)
+}
+
+// observers holds the callbacks notified of storage mutations. It has its own lock, so
+// subscribing does not contend with the data lock and callbacks can call back into the
+// storage.
+type observers struct {
+	mu     sync.Mutex
+	nextID int
+	list   []observer
+}
+
+// Subscribe registers fn to be called with an event after every mutation, and returns a
+// function removing it again. Callbacks run synchronously in the mutating goroutine, in
+// subscription order, once the write lock is released, so they may read from or write to
+// the storage. Concurrent mutations may deliver their events out of order; compare
+// StorageEvent.Version to tell.
+func (s *InMemoryStorage) Subscribe(fn func(types.StorageEvent)) (unsubscribe func()) {
+	s.observers.mu.Lock()
+	defer s.observers.mu.Unlock()
+
+	id := s.observers.nextID
+	s.observers.nextID++
+	s.observers.list = append(s.observers.list, observer{id: id, fn: fn})
+
+	return func() {
+		s.observers.mu.Lock()
+		defer s.observers.mu.Unlock()
+
+		for i, o := range s.observers.list {
+			if o.id == id {
+				s.observers.list = append(s.observers.list[:i:i], s.observers.list[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// notify calls every observer with the event. The caller must not hold the write lock.
+func (s *InMemoryStorage) notify(event types.StorageEvent) {
+	s.observers.mu.Lock()
+	list := s.observers.list
+	s.observers.mu.Unlock()
+
+	for _, o := range list {
+		o.fn(event)
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribe(t *testing.T) {
+	createdAt := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	storage := NewInMemoryStorageFromData(map[int]types.User{
+		1: {ID: 1, Name: "Tom", CreatedAt: createdAt},
+	}, nil)
+
+	var first, second []types.StorageEvent
+	unsubscribe := storage.Subscribe(func(event types.StorageEvent) {
+		// Reading from the storage must not deadlock, as the write lock is released.
+		assert.Equal(t, event.Version, storage.Version())
+		first = append(first, event)
+	})
+	storage.Subscribe(func(event types.StorageEvent) {
+		second = append(second, event)
+	})
+
+	action, err := storage.CreateAction(types.Action{UserID: 1, Type: types.ActionWelcome, CreatedAt: createdAt})
+	assert.NoError(t, err)
+	user, err := storage.CreateUser(types.User{Name: "Alice", CreatedAt: createdAt})
+	assert.NoError(t, err)
+
+	expected := []types.StorageEvent{
+		{Type: types.EventActionCreated, Version: 2, Action: action},
+		{Type: types.EventUserCreated, Version: 3, User: user},
+	}
+	assert.Equal(t, expected, first)
+	assert.Equal(t, expected, second)
+
+	// Failed mutations notify nobody.
+	_, err = storage.CreateUser(types.User{ID: 1, Name: "Bob", CreatedAt: createdAt})
+	assert.ErrorIs(t, err, ErrUserExists)
+	assert.Len(t, first, 2)
+
+	// After unsubscribing, only the remaining observer is notified.
+	unsubscribe()
+	unsubscribe()
+	name := "Thomas"
+	_, err = storage.UpdateUser(1, types.UserPatch{Name: &name})
+	assert.NoError(t, err)
+	assert.Len(t, first, 2)
+	assert.Len(t, second, 3)
+	assert.Equal(t, types.EventUserUpdated, second[2].Type)
+}
+
+func TestSubscribeCallbackMutates(t *testing.T) {
+	createdAt := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	storage := NewInMemoryStorageFromData(map[int]types.User{
+		1: {ID: 1, Name: "Tom", CreatedAt: createdAt},
+	}, nil)
+
+	// An observer creating a welcome action for every new user would deadlock if it ran
+	// under the write lock.
+	storage.Subscribe(func(event types.StorageEvent) {
+		if event.Type == types.EventUserCreated {
+			_, err := storage.CreateAction(types.Action{UserID: event.User.ID, Type: types.ActionWelcome, CreatedAt: createdAt})
+			assert.NoError(t, err)
+		}
+	})
+
+	user, err := storage.CreateUser(types.User{Name: "Alice", CreatedAt: createdAt})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, storage.CountActionsByUserID(user.ID))
+}
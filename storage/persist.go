@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/klemis/user-actions-api/types"
+)
+
+// Persistence modes for WithPersistence.
+const (
+	// PersistWriteThrough writes the data files after every mutation.
+	PersistWriteThrough = "write-through"
+	// PersistPeriodic writes the data files at a fixed interval if the data changed.
+	PersistPeriodic = "periodic"
+)
+
+// errNotPersistable is returned by Persist when the data was not loaded from local files.
+var errNotPersistable = errors.New("data was not loaded from local files")
+
+// WithPersistence writes runtime mutations back to the local data files, so they
+// survive a restart: after every mutation with PersistWriteThrough, or every interval
+// if the data changed with PersistPeriodic. Failed writes are logged.
+func WithPersistence(mode string, interval time.Duration) Option {
+	return func(s *InMemoryStorage) {
+		s.persistMode = mode
+		s.flushInterval = interval
+	}
+}
+
+// startPersistence starts writing mutations back in the configured mode. It is called
+// once the data is loaded.
+func (s *InMemoryStorage) startPersistence() {
+	switch s.persistMode {
+	case PersistWriteThrough:
+		s.Subscribe(func(types.StorageEvent) {
+			s.persistAndLog()
+		})
+	case PersistPeriodic:
+		go s.flushPeriodically()
+	}
+}
+
+// flushPeriodically persists the data every flush interval if it changed since it was
+// last persisted.
+func (s *InMemoryStorage) flushPeriodically() {
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.RLock()
+		changed := s.version != s.persistedVersion
+		s.mu.RUnlock()
+
+		if changed {
+			s.persistAndLog()
+		}
+	}
+}
+
+// Flush persists the data if persistence is enabled and the data changed since it was
+// last persisted, e.g. before shutting down with PersistPeriodic. Without persistence
+// it does nothing.
+func (s *InMemoryStorage) Flush() error {
+	if s.persistMode == "" {
+		return nil
+	}
+
+	s.mu.RLock()
+	changed := s.version != s.persistedVersion
+	s.mu.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	return s.Persist()
+}
+
+// persistAndLog persists the data, logging a failure as there is no caller to report it to.
+func (s *InMemoryStorage) persistAndLog() {
+	if err := s.Persist(); err != nil {
+		log.Printf("Failed to persist data: %v", err)
+	}
+}
+
+// Persist rewrites the local data files the storage was loaded from with the current
+// users and actions. Each file is written to a temporary file next to it and renamed
+// over it, so a crash never leaves a partially written file behind. Persist holds the
+// write lock throughout, which serializes it with mutations and other calls.
+func (s *InMemoryStorage) Persist() error {
+	if s.usersFile == "" || s.actionsFile == "" || isRemote(s.usersFile) || isRemote(s.actionsFile) {
+		return errNotPersistable
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]types.User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+
+	if err := writeFileAtomically(s.usersFile, users); err != nil {
+		return fmt.Errorf("failed to persist users: %v", err)
+	}
+	// Types stored as OTHER by -maxActionTypes are written back as they were loaded.
+	if err := writeFileAtomically(s.actionsFile, s.uncappedActions()); err != nil {
+		return fmt.Errorf("failed to persist actions: %v", err)
+	}
+	s.persistedVersion = s.version
+
+	return nil
+}
+
+// writeFileAtomically writes v as indented JSON to a temporary file in the directory of
+// path, then renames it over path.
+func writeFileAtomically(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Removing fails harmlessly once the file was renamed.
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
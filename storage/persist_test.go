@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeDataFiles writes users and actions files with a user and an action to a
+// temporary directory and returns their paths.
+func writeDataFiles(t *testing.T) (usersFile, actionsFile string) {
+	dir := t.TempDir()
+	usersFile = filepath.Join(dir, "users.json")
+	actionsFile = filepath.Join(dir, "actions.json")
+
+	users := `[{"id": 1, "name": "Tom", "createdAt": "2021-07-04T12:00:00Z"}]`
+	actions := `[{"id": 1, "type": "WELCOME", "userId": 1, "createdAt": "2021-07-04T12:00:00Z"}]`
+	assert.NoError(t, os.WriteFile(usersFile, []byte(users), 0644))
+	assert.NoError(t, os.WriteFile(actionsFile, []byte(actions), 0644))
+
+	return usersFile, actionsFile
+}
+
+func TestPersist(t *testing.T) {
+	usersFile, actionsFile := writeDataFiles(t)
+	store, err := NewInMemoryStorage(usersFile, actionsFile)
+	assert.NoError(t, err)
+	storage := store.(*InMemoryStorage)
+
+	createdAt := time.Date(2021, time.July, 5, 12, 0, 0, 0, time.UTC)
+	user, err := storage.CreateUser(types.User{Name: "Alice", CreatedAt: createdAt})
+	assert.NoError(t, err)
+	action, err := storage.CreateAction(types.Action{UserID: user.ID, Type: types.ActionReferUser, TargetUser: &user.ID, CreatedAt: createdAt, Source: types.SourceAPI})
+	assert.NoError(t, err)
+
+	assert.NoError(t, storage.Persist())
+
+	// Only the data files are left behind, no temporary files.
+	entries, err := os.ReadDir(filepath.Dir(usersFile))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	reloaded, err := NewInMemoryStorage(usersFile, actionsFile)
+	assert.NoError(t, err)
+	assert.Equal(t, user, reloaded.GetUser(user.ID))
+	assert.Equal(t, action, reloaded.GetAction(action.ID))
+	assert.Equal(t, storage.GetActions(), reloaded.GetActions())
+}
+
+// TestPersistCappedTypes checks that actions stored as OTHER because of the type cap
+// are written back with their original types.
+func TestPersistCappedTypes(t *testing.T) {
+	usersFile, actionsFile := writeDataFiles(t)
+	actions := `[
+		{"id": 1, "type": "WELCOME", "userId": 1, "createdAt": "2021-07-04T12:00:00Z"},
+		{"id": 2, "type": "CONNECT_CRM", "userId": 1, "createdAt": "2021-07-04T12:01:00Z"},
+		{"id": 3, "type": "ADD_CONTCAT", "userId": 1, "createdAt": "2021-07-04T12:02:00Z"}
+	]`
+	assert.NoError(t, os.WriteFile(actionsFile, []byte(actions), 0644))
+
+	store, err := NewInMemoryStorage(usersFile, actionsFile, WithMaxActionTypes(1), WithPersistence(PersistWriteThrough, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, types.ActionOther, store.GetAction(2).Type)
+
+	// A write-through triggered by a mutation writes every action back.
+	created, err := store.CreateAction(types.Action{UserID: 1, Type: types.ActionReferUser, CreatedAt: time.Date(2021, time.July, 4, 12, 3, 0, 0, time.UTC)})
+	assert.NoError(t, err)
+	assert.Equal(t, types.ActionOther, created.Type)
+
+	reloaded, err := NewInMemoryStorage(usersFile, actionsFile)
+	assert.NoError(t, err)
+	var reloadedTypes []types.ActionType
+	for _, action := range reloaded.GetActions() {
+		reloadedTypes = append(reloadedTypes, action.Type)
+	}
+	assert.Equal(t, []types.ActionType{types.ActionWelcome, types.ActionConnectCRM, "ADD_CONTCAT", types.ActionReferUser}, reloadedTypes)
+}
+
+func TestPersistWithoutDataFiles(t *testing.T) {
+	storage := NewInMemoryStorageFromData(nil, nil)
+
+	assert.ErrorIs(t, storage.Persist(), errNotPersistable)
+}
+
+func TestPersistenceModes(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+	}{
+		{name: "Write-through", mode: PersistWriteThrough},
+		{name: "Periodic", mode: PersistPeriodic},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			usersFile, actionsFile := writeDataFiles(t)
+			store, err := NewInMemoryStorage(usersFile, actionsFile, WithPersistence(tt.mode, 10*time.Millisecond))
+			assert.NoError(t, err)
+
+			user, err := store.CreateUser(types.User{Name: "Alice", CreatedAt: time.Date(2021, time.July, 5, 12, 0, 0, 0, time.UTC)})
+			assert.NoError(t, err)
+
+			// The user is written back without calling Persist.
+			assert.Eventually(t, func() bool {
+				reloaded, err := NewInMemoryStorage(usersFile, actionsFile)
+				return err == nil && reloaded.GetUser(user.ID) != nil
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestFlush(t *testing.T) {
+	t.Run("Without persistence", func(t *testing.T) {
+		t.Parallel() // Enable parallel execution
+
+		storage := NewInMemoryStorageFromData(nil, nil)
+
+		assert.NoError(t, storage.Flush())
+	})
+
+	t.Run("Periodic", func(t *testing.T) {
+		t.Parallel() // Enable parallel execution
+
+		usersFile, actionsFile := writeDataFiles(t)
+		// The interval is long enough that only Flush writes the data back.
+		store, err := NewInMemoryStorage(usersFile, actionsFile, WithPersistence(PersistPeriodic, time.Hour))
+		assert.NoError(t, err)
+
+		user, err := store.CreateUser(types.User{Name: "Alice", CreatedAt: time.Date(2021, time.July, 5, 12, 0, 0, 0, time.UTC)})
+		assert.NoError(t, err)
+
+		assert.NoError(t, store.(Flusher).Flush())
+		reloaded, err := NewInMemoryStorage(usersFile, actionsFile)
+		assert.NoError(t, err)
+		assert.Equal(t, user, reloaded.GetUser(user.ID))
+	})
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/klemis/user-actions-api/types"
+)
+
+// ErrReadOnly is returned by mutations of a read-only storage.
+var ErrReadOnly = errors.New("storage is read-only")
+
+// ReadOnlyStorage wraps a storage so it serves reads but rejects every mutation with
+// ErrReadOnly, for running read replicas. Reads are passed through to the wrapped
+// storage, so mutations added to Storage must be overridden here.
+type ReadOnlyStorage struct {
+	Storage
+}
+
+// NewReadOnlyStorage returns a read-only view of the storage.
+func NewReadOnlyStorage(store Storage) *ReadOnlyStorage {
+	return &ReadOnlyStorage{Storage: store}
+}
+
+// CreateUser rejects the user.
+func (s *ReadOnlyStorage) CreateUser(types.User) (*types.User, error) {
+	return nil, ErrReadOnly
+}
+
+// UpdateUser rejects the update.
+func (s *ReadOnlyStorage) UpdateUser(int, types.UserPatch) (*types.User, error) {
+	return nil, ErrReadOnly
+}
+
+// CreateAction rejects the action.
+func (s *ReadOnlyStorage) CreateAction(types.Action) (*types.Action, error) {
+	return nil, ErrReadOnly
+}
+
+// DeleteAction rejects the deletion.
+func (s *ReadOnlyStorage) DeleteAction(int) error {
+	return ErrReadOnly
+}
+
+// Resort rejects the re-sort.
+func (s *ReadOnlyStorage) Resort() (int, error) {
+	return 0, ErrReadOnly
+}
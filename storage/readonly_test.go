@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOnlyStorage(t *testing.T) {
+	createdAt := time.Date(2021, time.July, 4, 12, 47, 9, 0, time.UTC)
+	inner := &InMemoryStorage{
+		users: map[int]types.User{1: {ID: 1, Name: "Alice", CreatedAt: createdAt}},
+		actions: []types.Action{
+			{ID: 2, UserID: 1, Type: types.ActionAddContact, CreatedAt: createdAt},
+			{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: createdAt.Add(-time.Hour)},
+		},
+		version: 1,
+	}
+	inner.warmup()
+	store := NewReadOnlyStorage(inner)
+
+	// Reads are served by the wrapped storage.
+	assert.Equal(t, &types.User{ID: 1, Name: "Alice", CreatedAt: createdAt}, store.GetUser(1))
+	assert.Equal(t, 2, store.CountActionsByUserID(1))
+	assert.Equal(t, types.ActionWelcome, store.GetAction(1).Type)
+
+	// Mutations are rejected and leave the data untouched.
+	created, err := store.CreateUser(types.User{Name: "Bob", CreatedAt: createdAt})
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.Nil(t, created)
+
+	name := "Alicia"
+	user, err := store.UpdateUser(1, types.UserPatch{Name: &name})
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.Nil(t, user)
+
+	action, err := store.CreateAction(types.Action{UserID: 1, Type: types.ActionEditContact, CreatedAt: createdAt})
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.Nil(t, action)
+
+	assert.ErrorIs(t, store.DeleteAction(1), ErrReadOnly)
+
+	moved, err := store.Resort()
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.Zero(t, moved)
+
+	assert.Equal(t, "Alice", inner.users[1].Name)
+	assert.Len(t, inner.users, 1)
+	assert.Equal(t, 2, inner.actions[0].ID)
+	assert.Len(t, inner.actions, 2)
+	assert.Equal(t, uint64(1), store.Version())
+}
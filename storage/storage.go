@@ -1,34 +1,132 @@
 package storage
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/http"
 	"os"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/klemis/user-actions-api/metrics"
 	"github.com/klemis/user-actions-api/types"
 )
 
+// ErrUserExists is returned when creating a user whose ID is already taken.
+var ErrUserExists = errors.New("user already exists")
+
+// ErrUserNotFound is returned when updating a user that does not exist.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrActionNotFound is returned when deleting an action that does not exist.
+var ErrActionNotFound = errors.New("action not found")
+
 // Storage interface for accessing user and action data.
 type Storage interface {
 	GetUser(int) *types.User
+	CreateUser(user types.User) (*types.User, error)
+	UpdateUser(id int, patch types.UserPatch) (*types.User, error)
+	GetAction(id int) *types.Action
 	CountActionsByUserID(userID int) int
+	CountActionsByType(actionType types.ActionType) int
 	GetActions() []types.Action
+	GetUserActions(userID int) []types.Action
+	ActionsByTime(offset, limit int) []types.Action
+	GetActionsBetween(from, to time.Time) []types.Action
+	ActionsByTimeAfter(createdAt time.Time, id int, to time.Time, limit int) []types.Action
+	ActiveUserIDs() []int
+	UserIDs() []int
+	CreateAction(action types.Action) (*types.Action, error)
+	DeleteAction(id int) error
+	InsertPosition(userID int, createdAt time.Time) int
+	Resort() (int, error)
+	Version() uint64
+	Stats() types.Stats
+	CheckConsistency() types.ConsistencyReport
+	Subscribe(fn func(types.StorageEvent)) (unsubscribe func())
+}
+
+// Flusher is implemented by storages that write changes back to durable storage in the
+// background. Flush writes any pending changes.
+type Flusher interface {
+	Flush() error
 }
 
-// inMemoryStorage implements the Storage interface with in-memory data.
-type inMemoryStorage struct {
+// InMemoryStorage implements the Storage interface with in-memory data. Its data is
+// only reachable through the Storage methods; construct it with NewInMemoryStorage or
+// NewInMemoryStorageFromData.
+type InMemoryStorage struct {
 	users   map[int]types.User
 	actions []types.Action
-	mu      sync.RWMutex
+	// version is bumped whenever the stored data changes.
+	version uint64
+	// lastActionID is the highest action ID stored so far. New actions are numbered
+	// after it, so IDs are never reused.
+	lastActionID int
+	// outOfOrder is the number of actions that were out of order in the source data.
+	outOfOrder int
+	// strict rejects unknown fields in the source data instead of ignoring them.
+	strict bool
+	// loadTimeout bounds how long loading each data source may take. Zero means no limit.
+	loadTimeout time.Duration
+	// typeCap buckets action types beyond a maximum into OTHER. Nil means no cap.
+	typeCap *actionTypeCap
+	// usersFile and actionsFile are the data sources, which Persist writes back to.
+	usersFile   string
+	actionsFile string
+	// persistMode and flushInterval configure writing mutations back, see WithPersistence.
+	persistMode   string
+	flushInterval time.Duration
+	// persistedVersion is the data version last written to the data files.
+	persistedVersion uint64
+	// Indices derived from actions, built by warmup and updated by each mutation.
+	userIndex         map[int]userSpan
+	typeIndex         map[types.ActionType][]int
+	actionCountByUser map[int]int
+	actionIndex       map[int]int
+	timeIndex         []int
+	mu                sync.RWMutex
+	// observers are notified of every mutation, see Subscribe.
+	observers observers
+}
+
+// Option configures an InMemoryStorage.
+type Option func(*InMemoryStorage)
+
+// WithStrictDecoding makes the loaders reject fields that are not part of the schema,
+// so typos in the source data are reported rather than silently ignored.
+func WithStrictDecoding() Option {
+	return func(s *InMemoryStorage) {
+		s.strict = true
+	}
+}
+
+// WithLoadTimeout bounds how long loading each data source may take, so a slow or
+// unresponsive remote source fails startup with an error instead of hanging it.
+func WithLoadTimeout(timeout time.Duration) Option {
+	return func(s *InMemoryStorage) {
+		s.loadTimeout = timeout
+	}
 }
 
 // NewInMemoryStorage loads data from JSON files and initializes storage.
-func NewInMemoryStorage(userFile, actionFile string) (Storage, error) {
-	storage := &inMemoryStorage{
-		users:   make(map[int]types.User),
-		actions: []types.Action{},
+func NewInMemoryStorage(userFile, actionFile string, opts ...Option) (Storage, error) {
+	storage := &InMemoryStorage{
+		users:       make(map[int]types.User),
+		actions:     []types.Action{},
+		usersFile:   userFile,
+		actionsFile: actionFile,
+	}
+	for _, opt := range opts {
+		opt(storage)
 	}
 
 	if err := storage.loadUsers(userFile); err != nil {
@@ -37,12 +135,71 @@ func NewInMemoryStorage(userFile, actionFile string) (Storage, error) {
 	if err := storage.loadActions(actionFile); err != nil {
 		return nil, fmt.Errorf("failed to load actions: %v", err)
 	}
+	// The storage is only handed out, and so the server only starts serving,
+	// once the indices are built.
+	storage.warmup()
+	storage.version = 1
+	storage.persistedVersion = storage.version
+	storage.startPersistence()
+
+	return storage, nil
+}
+
+// NewInMemoryStorageFromReaders loads data from JSON read from the readers, such as
+// embedded data or a network stream, and initializes storage. Errors name the sources
+// "users" and "actions". As there are no files to write back to, it cannot persist.
+func NewInMemoryStorageFromReaders(users, actions io.Reader, opts ...Option) (*InMemoryStorage, error) {
+	storage := &InMemoryStorage{
+		users:   make(map[int]types.User),
+		actions: []types.Action{},
+	}
+	for _, opt := range opts {
+		opt(storage)
+	}
+
+	if err := storage.loadUsersFrom("users", users); err != nil {
+		return nil, fmt.Errorf("failed to load users: %v", err)
+	}
+	if err := storage.loadActionsFrom("actions", actions); err != nil {
+		return nil, fmt.Errorf("failed to load actions: %v", err)
+	}
+	storage.warmup()
+	storage.version = 1
 
 	return storage, nil
 }
 
+// NewInMemoryStorageFromData initializes storage from data already in memory, for tests
+// and embedders that do not load files. The users and actions are copied, and the
+// actions are sorted by user and createdAt like loaded ones.
+func NewInMemoryStorageFromData(users map[int]types.User, actions []types.Action, opts ...Option) *InMemoryStorage {
+	storage := &InMemoryStorage{
+		users:   make(map[int]types.User, len(users)),
+		actions: append([]types.Action{}, actions...),
+	}
+	for _, opt := range opts {
+		opt(storage)
+	}
+
+	for id, user := range users {
+		storage.users[id] = user
+	}
+	storage.outOfOrder = countOutOfOrder(storage.actions)
+	sort.Slice(storage.actions, func(i, j int) bool {
+		return actionLess(storage.actions[i], storage.actions[j])
+	})
+	// Types are admitted in the sorted order, as when loading a data file.
+	for i := range storage.actions {
+		storage.capActionType(&storage.actions[i])
+	}
+	storage.warmup()
+	storage.version = 1
+
+	return storage
+}
+
 // Get retrieves a user by ID.
-func (s *inMemoryStorage) GetUser(id int) *types.User {
+func (s *InMemoryStorage) GetUser(id int) *types.User {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -57,22 +214,90 @@ func (s *inMemoryStorage) GetUser(id int) *types.User {
 	return &userCopy
 }
 
-// CountActionsByUserID returns the count of actions for a specific user ID.
-func (s *inMemoryStorage) CountActionsByUserID(userID int) int {
+// CreateUser stores a new user and returns it. A zero ID is replaced with the next free
+// ID, one above the highest stored. It returns ErrUserExists if the ID is taken.
+func (s *InMemoryStorage) CreateUser(user types.User) (*types.User, error) {
+	s.mu.Lock()
+	if user.ID == 0 {
+		for id := range s.users {
+			user.ID = max(user.ID, id)
+		}
+		user.ID++
+	}
+	if _, exists := s.users[user.ID]; exists {
+		s.mu.Unlock()
+		return nil, ErrUserExists
+	}
+
+	s.users[user.ID] = user
+	s.version++
+	event := types.StorageEvent{Type: types.EventUserCreated, Version: s.version, User: &user}
+	s.mu.Unlock()
+
+	metrics.RecordUser()
+	s.notify(event)
+
+	return &user, nil
+}
+
+// UpdateUser applies a partial update to a user and returns a copy of the updated
+// user. Its ID and createdAt never change. It returns ErrUserNotFound if the user does
+// not exist.
+func (s *InMemoryStorage) UpdateUser(id int, patch types.UserPatch) (*types.User, error) {
+	s.mu.Lock()
+	user, exists := s.users[id]
+	if !exists {
+		s.mu.Unlock()
+		return nil, ErrUserNotFound
+	}
+
+	if patch.Name != nil {
+		user.Name = *patch.Name
+	}
+	s.users[id] = user
+	s.version++
+	event := types.StorageEvent{Type: types.EventUserUpdated, Version: s.version, User: &user}
+	s.mu.Unlock()
+
+	s.notify(event)
+
+	return &user, nil
+}
+
+// GetAction retrieves an action by ID.
+func (s *InMemoryStorage) GetAction(id int) *types.Action {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	count := 0
-	for _, action := range s.actions {
-		if action.UserID == userID {
-			count++
-		}
+	i, exists := s.actionIndex[id]
+	if !exists {
+		return nil
 	}
 
-	return count
+	// Return a copy of the action to prevent modification of the data.
+	actionCopy := s.actions[i]
+
+	return &actionCopy
+}
+
+// CountActionsByUserID returns the count of actions for a specific user ID.
+func (s *InMemoryStorage) CountActionsByUserID(userID int) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.actionCountByUser[userID]
+}
+
+// CountActionsByType returns the count of actions of a specific type across all users.
+// Types are matched case-sensitively.
+func (s *InMemoryStorage) CountActionsByType(actionType types.ActionType) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.typeIndex[actionType])
 }
 
-func (s *inMemoryStorage) GetActions() []types.Action {
+func (s *InMemoryStorage) GetActions() []types.Action {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -83,35 +308,271 @@ func (s *inMemoryStorage) GetActions() []types.Action {
 	return actionsCopy
 }
 
-// CreateAction inserts a new action into the actions slice while maintaining the sorted order.
-// The function uses a binary search to determine the correct position for insertion.
-// This ensures the actions slice remains sorted by UserID and CreatedAt.
+// GetUserActions returns the actions of a single user, ordered by createdAt.
+func (s *InMemoryStorage) GetUserActions(userID int) []types.Action {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	span, exists := s.userIndex[userID]
+	if !exists {
+		return []types.Action{}
+	}
+
+	// Return a copy of the span to prevent external modification.
+	actionsCopy := make([]types.Action, span.end-span.start)
+	copy(actionsCopy, s.actions[span.start:span.end])
+
+	return actionsCopy
+}
+
+// ActionsByTime returns up to limit actions across all users ordered by createdAt, and
+// then by ID, skipping the first offset of them.
+func (s *InMemoryStorage) ActionsByTime(offset, limit int) []types.Action {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if offset >= len(s.timeIndex) {
+		return []types.Action{}
+	}
+	positions := s.timeIndex[offset:]
+	if limit < len(positions) {
+		positions = positions[:limit]
+	}
+
+	actions := make([]types.Action, 0, len(positions))
+	for _, i := range positions {
+		actions = append(actions, s.actions[i])
+	}
+
+	return actions
+}
+
+// GetActionsBetween returns the actions created at or after from and before to,
+// ordered by createdAt and then by ID. A zero from or to leaves that end unbounded.
+func (s *InMemoryStorage) GetActionsBetween(from, to time.Time) []types.Action {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// The time index is ordered by createdAt, so both ends are found by binary search.
+	start := 0
+	if !from.IsZero() {
+		start = sort.Search(len(s.timeIndex), func(i int) bool {
+			return !s.actions[s.timeIndex[i]].CreatedAt.Before(from)
+		})
+	}
+	end := len(s.timeIndex)
+	if !to.IsZero() {
+		end = sort.Search(len(s.timeIndex), func(i int) bool {
+			return !s.actions[s.timeIndex[i]].CreatedAt.Before(to)
+		})
+	}
+	if end < start {
+		return []types.Action{}
+	}
+
+	actions := make([]types.Action, 0, end-start)
+	for _, i := range s.timeIndex[start:end] {
+		actions = append(actions, s.actions[i])
+	}
+
+	return actions
+}
+
+// ActionsByTimeAfter returns up to limit actions ordered by createdAt and then by ID
+// that come after the action created at createdAt with the given ID in that order,
+// and were created before to. A zero to leaves the end unbounded; an id of math.MinInt
+// includes every action created at createdAt. Unlike an offset, paging from the last
+// action returned neither repeats nor skips actions when others are created or deleted
+// in between.
+func (s *InMemoryStorage) ActionsByTimeAfter(createdAt time.Time, id int, to time.Time, limit int) []types.Action {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	start := sort.Search(len(s.timeIndex), func(i int) bool {
+		action := s.actions[s.timeIndex[i]]
+		if action.CreatedAt.Equal(createdAt) {
+			return action.ID > id
+		}
+		return action.CreatedAt.After(createdAt)
+	})
+
+	actions := []types.Action{}
+	for _, i := range s.timeIndex[start:] {
+		if len(actions) == limit || (!to.IsZero() && !s.actions[i].CreatedAt.Before(to)) {
+			break
+		}
+		actions = append(actions, s.actions[i])
+	}
+
+	return actions
+}
+
+// ActiveUserIDs returns the sorted IDs of the users with at least one action.
+func (s *InMemoryStorage) ActiveUserIDs() []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]int, 0, len(s.userIndex))
+	for id := range s.userIndex {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids
+}
+
+// UserIDs returns the sorted IDs of all users.
+func (s *InMemoryStorage) UserIDs() []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]int, 0, len(s.users))
+	for id := range s.users {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids
+}
+
+// Version returns the current data version, which changes on every mutation.
+func (s *InMemoryStorage) Version() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.version
+}
+
+// InsertPosition returns the index at which an action of the user created at the given
+// time would be inserted, without inserting anything.
+func (s *InMemoryStorage) InsertPosition(userID int, createdAt time.Time) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return insertPosition(s.actions, userID, createdAt)
+}
+
+// Resort restores the canonical order of the actions and rebuilds the indices, as a
+// recovery tool should the slice ever end up out of order. It returns the number of
+// actions that changed position, zero if the actions were already sorted.
+func (s *InMemoryStorage) Resort() (int, error) {
+	s.mu.Lock()
+
+	// Sort positions rather than the actions, to tell which ones moved.
+	order := make([]int, len(s.actions))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return actionLess(s.actions[order[i]], s.actions[order[j]])
+	})
+
+	moved := 0
+	sorted := make([]types.Action, len(s.actions))
+	for i, position := range order {
+		if position != i {
+			moved++
+		}
+		sorted[i] = s.actions[position]
+	}
+	if moved == 0 {
+		s.mu.Unlock()
+		return 0, nil
+	}
+
+	s.actions = sorted
+	s.setIndices(buildIndices(sorted))
+	s.version++
+	event := types.StorageEvent{Type: types.EventActionsResorted, Version: s.version}
+	s.mu.Unlock()
 
-// func (s *InMemoryStorage) CreateAction(action types.Action) {
-// 	s.mu.Lock()
-// 	defer s.mu.Unlock()
+	s.notify(event)
 
-// 	// Find the appropriate index to insert the new action.
-// 	idx := sort.Search(len(s.actions), func(i int) bool {
-// 		if s.actions[i].UserID == action.UserID {
-// 			return s.actions[i].CreatedAt.After(action.CreatedAt)
-// 		}
-// 		return s.actions[i].UserID > action.UserID
-// 	})
+	return moved, nil
+}
+
+// Stats returns summary statistics about the stored data.
+func (s *InMemoryStorage) Stats() types.Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-// 	// Insert the new action while maintaining sorted order.
-// 	s.actions = append(s.actions[:idx], append([]types.Action{action}, s.actions[idx:]...)...)
-// }
+	return types.Stats{
+		Users:             len(s.users),
+		Actions:           len(s.actions),
+		OutOfOrderActions: s.outOfOrder,
+	}
+}
+
+// CreateAction stores a new action under the next free ID and returns it. The action is
+// inserted at the position found by binary search, so the actions stay sorted by UserID
+// and CreatedAt, and the indices are updated for the shifted positions.
+func (s *InMemoryStorage) CreateAction(action types.Action) (*types.Action, error) {
+	s.mu.Lock()
+	s.lastActionID++
+	action.ID = s.lastActionID
+	s.capActionType(&action)
+
+	// Find the appropriate index to insert the new action.
+	idx := insertPosition(s.actions, action.UserID, action.CreatedAt)
+
+	// Insert the new action while maintaining sorted order.
+	s.actions = slices.Insert(s.actions, idx, action)
+	s.indexInsertedAction(idx, action)
+	s.version++
+	event := types.StorageEvent{Type: types.EventActionCreated, Version: s.version, Action: &action}
+	s.mu.Unlock()
+
+	metrics.RecordAction(action)
+	s.notify(event)
+
+	return &action, nil
+}
+
+// DeleteAction removes the action with the given ID, or returns ErrActionNotFound. The
+// remaining actions stay sorted, and the indices are updated for the shifted positions.
+// The ID is not reused by later actions.
+func (s *InMemoryStorage) DeleteAction(id int) error {
+	s.mu.Lock()
+	i, exists := s.actionIndex[id]
+	if !exists {
+		s.mu.Unlock()
+		return ErrActionNotFound
+	}
+
+	action := s.actions[i]
+	s.actions = slices.Delete(s.actions, i, i+1)
+	if s.typeCap != nil {
+		delete(s.typeCap.originals, id)
+	}
+	s.indexDeletedAction(i, action)
+	s.version++
+	event := types.StorageEvent{Type: types.EventActionDeleted, Version: s.version, Action: &action}
+	s.mu.Unlock()
+
+	s.notify(event)
+
+	return nil
+}
 
 // loadUsers reads and parses users.json file.
-func (s *inMemoryStorage) loadUsers(filename string) error {
-	data, err := os.ReadFile(filename)
+func (s *InMemoryStorage) loadUsers(filename string) error {
+	data, err := s.read(filename)
+	if err != nil {
+		return err
+	}
+
+	return s.loadUsersFrom(filename, bytes.NewReader(data))
+}
+
+// loadUsersFrom parses users from r. The name identifies the source in errors.
+func (s *InMemoryStorage) loadUsersFrom(name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
 	if err != nil {
 		return err
 	}
 
 	var users []types.User
-	if err := json.Unmarshal(data, &users); err != nil {
+	if err := s.decode(name, data, &users); err != nil {
 		return err
 	}
 
@@ -119,34 +580,216 @@ func (s *inMemoryStorage) loadUsers(filename string) error {
 	defer s.mu.Unlock()
 	for _, user := range users {
 		s.users[user.ID] = user
+		metrics.RecordUser()
 	}
 
 	return nil
 }
 
 // loadActions reads and parses actions.json file.
-func (s *inMemoryStorage) loadActions(filename string) error {
-	data, err := os.ReadFile(filename)
+func (s *InMemoryStorage) loadActions(filename string) error {
+	data, err := s.read(filename)
+	if err != nil {
+		return err
+	}
+
+	return s.loadActionsFrom(filename, bytes.NewReader(data))
+}
+
+// loadActionsFrom parses actions from r and stores them sorted. The name identifies the
+// source in errors and logs.
+func (s *InMemoryStorage) loadActionsFrom(name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
 	if err != nil {
 		return err
 	}
 
 	var actions []types.Action
-	if err := json.Unmarshal(data, &actions); err != nil {
+	if err := s.decode(name, data, &actions); err != nil {
 		return err
 	}
 
+	// Actions without an explicit source come from the file itself.
+	for i := range actions {
+		if actions[i].Source == "" {
+			actions[i].Source = types.SourceFile
+		}
+	}
+
+	// Unknown types are kept for backward compatibility, but usually point to typos.
+	for _, unknown := range countUnknownTypes(actions) {
+		log.Printf("%s: %d actions of unknown type %q", name, unknown.Count, unknown.Type)
+	}
+
+	// Record how far the source data was from the canonical order before fixing it.
+	outOfOrder := countOutOfOrder(actions)
+	if outOfOrder > 0 {
+		log.Printf("%s: %d actions out of order, sorting", name, outOfOrder)
+	}
+
 	// Sort actions by user and createdAt before storing them.
 	sort.Slice(actions, func(i, j int) bool {
-		if actions[i].UserID == actions[j].UserID {
-			return actions[i].CreatedAt.Before(actions[j].CreatedAt)
-		}
-		return actions[i].UserID < actions[j].UserID
+		return actionLess(actions[i], actions[j])
 	})
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	for i := range actions {
+		s.capActionType(&actions[i])
+	}
 	s.actions = actions
+	s.outOfOrder = outOfOrder
+
+	for _, action := range actions {
+		metrics.RecordAction(action)
+	}
 
 	return nil
 }
+
+// read returns the contents of a data source: an http(s) URL or a local file path.
+// Remote sources are fetched within the load timeout.
+func (s *InMemoryStorage) read(source string) ([]byte, error) {
+	if !isRemote(source) {
+		return os.ReadFile(source)
+	}
+
+	ctx := context.Background()
+	if s.loadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.loadTimeout)
+		defer cancel()
+	}
+
+	data, err := fetch(ctx, source)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("loading %s timed out after %s", source, s.loadTimeout)
+	}
+
+	return data, err
+}
+
+// isRemote reports whether the data source is an http(s) URL rather than a local file.
+func isRemote(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// fetch downloads the body of the given URL.
+func fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: unexpected status %s", url, resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// decode parses JSON data read from source into v, rejecting unknown fields in strict
+// mode and any data after the value. Errors name the source and, where the decoder
+// reports an offset, the line of the offending input.
+func (s *InMemoryStorage) decode(source string, data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if s.strict {
+		decoder.DisallowUnknownFields()
+	}
+
+	err := decoder.Decode(v)
+	if err == nil {
+		// Anything after the value, such as a second array, would otherwise be ignored.
+		end := decoder.InputOffset()
+		var extra json.RawMessage
+		if err := decoder.Decode(&extra); err != io.EOF {
+			trailing := len(data) - len(bytes.TrimLeft(data[end:], " \t\r\n"))
+			return fmt.Errorf("%s:%d: unexpected data after the top-level value", source, lineAt(data, int64(trailing)+1))
+		}
+		return nil
+	}
+
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("%s:%d: %w", source, lineAt(data, syntaxErr.Offset), err)
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("%s:%d: %w", source, lineAt(data, typeErr.Offset), err)
+	default:
+		return fmt.Errorf("%s: %w", source, err)
+	}
+}
+
+// lineAt returns the 1-based line of the byte preceding offset, which is the byte a
+// decoder error offset points just past.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset > 0 {
+		offset--
+	}
+
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// actionLess reports whether a sorts before b in the canonical order: by user, then createdAt.
+func actionLess(a, b types.Action) bool {
+	if a.UserID == b.UserID {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.UserID < b.UserID
+}
+
+// insertPosition finds the index in the sorted actions at which an action of the user
+// created at the given time belongs. Actions with an equal user and timestamp stay in
+// front, so equal actions keep their insertion order.
+func insertPosition(actions []types.Action, userID int, createdAt time.Time) int {
+	action := types.Action{UserID: userID, CreatedAt: createdAt}
+	return sort.Search(len(actions), func(i int) bool {
+		return actionLess(action, actions[i])
+	})
+}
+
+// countUnknownTypes counts the actions of each type that is not well-known, ordered by
+// type.
+func countUnknownTypes(actions []types.Action) []types.ActionTypeCount {
+	counts := make(map[types.ActionType]int)
+	for _, action := range actions {
+		if !action.Type.Valid() {
+			counts[action.Type]++
+		}
+	}
+
+	unknown := make([]types.ActionTypeCount, 0, len(counts))
+	for actionType, count := range counts {
+		unknown = append(unknown, types.ActionTypeCount{Type: actionType, Count: count})
+	}
+	slices.SortFunc(unknown, func(a, b types.ActionTypeCount) int {
+		return strings.Compare(string(a.Type), string(b.Type))
+	})
+
+	return unknown
+}
+
+// countOutOfOrder counts actions that sort before the action preceding them,
+// i.e. the number of places where the input breaks the canonical order.
+func countOutOfOrder(actions []types.Action) int {
+	count := 0
+	for i := 1; i < len(actions); i++ {
+		if actionLess(actions[i], actions[i-1]) {
+			count++
+		}
+	}
+
+	return count
+}
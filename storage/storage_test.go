@@ -2,9 +2,16 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/klemis/user-actions-api/types"
@@ -44,7 +51,7 @@ func TestGetUser(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel() // Enable parallel execution
 
-			storage := &inMemoryStorage{
+			storage := &InMemoryStorage{
 				users: tt.users,
 				mu:    sync.RWMutex{},
 			}
@@ -55,6 +62,36 @@ func TestGetUser(t *testing.T) {
 	}
 }
 
+func TestNewInMemoryStorageFromData(t *testing.T) {
+	base := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	users := map[int]types.User{
+		1: {ID: 1, Name: "Tom", CreatedAt: base},
+		2: {ID: 2, Name: "Alice", CreatedAt: base},
+	}
+	actions := []types.Action{
+		{ID: 3, UserID: 2, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 2, UserID: 1, Type: types.ActionAddContact, CreatedAt: base.Add(time.Hour)},
+		{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: base},
+	}
+
+	storage := NewInMemoryStorageFromData(users, actions)
+
+	// The actions are sorted, leaving the caller's slice untouched.
+	stored := storage.GetActions()
+	assert.Equal(t, []int{1, 2, 3}, []int{stored[0].ID, stored[1].ID, stored[2].ID})
+	assert.Equal(t, 3, actions[0].ID)
+	assert.Equal(t, types.Stats{Users: 2, Actions: 3, OutOfOrderActions: 2}, storage.Stats())
+
+	// The indices are built.
+	assert.Equal(t, 2, storage.CountActionsByUserID(1))
+	assert.Equal(t, &actions[0], storage.GetAction(3))
+	assert.Equal(t, uint64(1), storage.Version())
+
+	// Later changes to the caller's data do not leak into the storage.
+	delete(users, 2)
+	assert.NotNil(t, storage.GetUser(2))
+}
+
 func TestCountActionsByUserID(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -87,10 +124,11 @@ func TestCountActionsByUserID(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel() // Enable parallel execution
 
-			storage := &inMemoryStorage{
+			storage := &InMemoryStorage{
 				actions: tt.actions,
 				mu:      sync.RWMutex{},
 			}
+			storage.warmup()
 
 			result := storage.CountActionsByUserID(tt.userID)
 			assert.Equal(t, tt.expected, result)
@@ -98,6 +136,34 @@ func TestCountActionsByUserID(t *testing.T) {
 	}
 }
 
+func TestCountActionsByType(t *testing.T) {
+	storage := NewInMemoryStorageFromData(nil, []types.Action{
+		{ID: 1, UserID: 1, Type: "WELCOME"},
+		{ID: 2, UserID: 1, Type: "CONNECT_CRM"},
+		{ID: 3, UserID: 2, Type: "WELCOME"},
+		{ID: 4, UserID: 3, Type: "WELCOME"},
+	})
+
+	tests := []struct {
+		name       string
+		actionType types.ActionType
+		expected   int
+	}{
+		{name: "Type occurring multiple times", actionType: "WELCOME", expected: 3},
+		{name: "Type occurring once", actionType: "CONNECT_CRM", expected: 1},
+		{name: "Absent type", actionType: "REFER_USER", expected: 0},
+		{name: "Case-sensitive", actionType: "welcome", expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			assert.Equal(t, tt.expected, storage.CountActionsByType(tt.actionType))
+		})
+	}
+}
+
 func TestGetActions(t *testing.T) {
 	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
 	if err != nil {
@@ -133,7 +199,7 @@ func TestGetActions(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel() // Enable parallel execution
 
-			storage := &inMemoryStorage{
+			storage := &InMemoryStorage{
 				actions: tt.actions,
 				mu:      sync.RWMutex{},
 			}
@@ -167,9 +233,9 @@ func TestLoadActions(t *testing.T) {
 			},
 			expectErr: false,
 			expected: []types.Action{
-				{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: mockTime},
-				{ID: 2, UserID: 1, Type: "EDIT_CONTACT", CreatedAt: mockTime.Add(3 * time.Hour)},
-				{ID: 3, UserID: 2, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(1 * time.Hour)},
+				{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: mockTime, Source: types.SourceFile},
+				{ID: 2, UserID: 1, Type: "EDIT_CONTACT", CreatedAt: mockTime.Add(3 * time.Hour), Source: types.SourceFile},
+				{ID: 3, UserID: 2, Type: "CONNECT_CRM", CreatedAt: mockTime.Add(1 * time.Hour), Source: types.SourceFile},
 			},
 		},
 		{
@@ -210,7 +276,7 @@ func TestLoadActions(t *testing.T) {
 
 			defer os.Remove(tt.inputFile)
 
-			storage := &inMemoryStorage{}
+			storage := &InMemoryStorage{}
 			err := storage.loadActions(tt.inputFile)
 
 			if tt.expectErr {
@@ -227,3 +293,870 @@ func TestLoadActions(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadActionsOutOfOrder(t *testing.T) {
+	mockTime, err := time.Parse(time.RFC3339, "2021-07-04T12:47:09.888Z")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		inputFile   string
+		mockActions []types.Action
+		expected    int
+	}{
+		{
+			name:      "Already sorted",
+			inputFile: "sorted_actions.json",
+			mockActions: []types.Action{
+				{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: mockTime},
+				{ID: 2, UserID: 1, Type: "EDIT_CONTACT", CreatedAt: mockTime.Add(1 * time.Hour)},
+				{ID: 3, UserID: 2, Type: "WELCOME", CreatedAt: mockTime},
+			},
+			expected: 0,
+		},
+		{
+			name:      "Shuffled",
+			inputFile: "shuffled_actions.json",
+			mockActions: []types.Action{
+				{ID: 3, UserID: 2, Type: "WELCOME", CreatedAt: mockTime},
+				{ID: 2, UserID: 1, Type: "EDIT_CONTACT", CreatedAt: mockTime.Add(1 * time.Hour)},
+				{ID: 4, UserID: 2, Type: "ADD_CONTACT", CreatedAt: mockTime.Add(2 * time.Hour)},
+				{ID: 1, UserID: 1, Type: "WELCOME", CreatedAt: mockTime},
+			},
+			expected: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			mockActions, err := json.Marshal(tt.mockActions)
+			if err != nil {
+				t.Fatalf("Failed to marshal mock data: %v", err)
+			}
+			if err := os.WriteFile(tt.inputFile, mockActions, 0644); err != nil {
+				t.Fatalf("Failed to write mock file: %v", err)
+			}
+			defer os.Remove(tt.inputFile)
+
+			storage := &InMemoryStorage{}
+			assert.NoError(t, storage.loadActions(tt.inputFile))
+
+			stats := storage.Stats()
+			assert.Equal(t, tt.expected, stats.OutOfOrderActions)
+			assert.Equal(t, len(tt.mockActions), stats.Actions)
+
+			// The stored result is sorted regardless of the input order.
+			for i := 1; i < len(storage.actions); i++ {
+				assert.False(t, actionLess(storage.actions[i], storage.actions[i-1]))
+			}
+		})
+	}
+}
+
+func TestLoadStrictDecoding(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		strict    bool
+		load      func(s *InMemoryStorage, name string, r io.Reader) error
+		expectErr bool
+	}{
+		{
+			name:      "Lenient actions ignore unknown field",
+			content:   `[{"id": 1, "type": "REFER_USER", "userId": 1, "tagetUser": 2}]`,
+			load:      (*InMemoryStorage).loadActionsFrom,
+			expectErr: false,
+		},
+		{
+			name:      "Strict actions reject unknown field",
+			content:   `[{"id": 1, "type": "REFER_USER", "userId": 1, "tagetUser": 2}]`,
+			strict:    true,
+			load:      (*InMemoryStorage).loadActionsFrom,
+			expectErr: true,
+		},
+		{
+			name:      "Strict actions accept known fields",
+			content:   `[{"id": 1, "type": "REFER_USER", "userId": 1, "targetUser": 2}]`,
+			strict:    true,
+			load:      (*InMemoryStorage).loadActionsFrom,
+			expectErr: false,
+		},
+		{
+			name:      "Strict users reject unknown field",
+			content:   `[{"id": 1, "name": "Tom", "email": "tom@example.com"}]`,
+			strict:    true,
+			load:      (*InMemoryStorage).loadUsersFrom,
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			storage := &InMemoryStorage{users: make(map[int]types.User), strict: tt.strict}
+			err := tt.load(storage, "data.json", strings.NewReader(tt.content))
+
+			if tt.expectErr {
+				assert.ErrorContains(t, err, "unknown field")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNewInMemoryStorageFromReaders(t *testing.T) {
+	users := `[{"id": 1, "name": "Tom", "createdAt": "2021-07-04T12:00:00Z"}]`
+	actions := `[
+		{"id": 2, "type": "ADD_CONTACT", "userId": 1, "createdAt": "2021-07-04T13:00:00Z"},
+		{"id": 1, "type": "WELCOME", "userId": 1, "createdAt": "2021-07-04T12:00:00Z"}
+	]`
+
+	t.Run("Valid data", func(t *testing.T) {
+		t.Parallel() // Enable parallel execution
+
+		storage, err := NewInMemoryStorageFromReaders(strings.NewReader(users), strings.NewReader(actions))
+		assert.NoError(t, err)
+
+		assert.Equal(t, "Tom", storage.GetUser(1).Name)
+		// The actions are sorted and indexed like loaded files.
+		assert.Equal(t, []int{1, 2}, []int{storage.GetActions()[0].ID, storage.GetActions()[1].ID})
+		assert.Equal(t, 2, storage.CountActionsByUserID(1))
+		assert.Equal(t, types.SourceFile, storage.GetAction(1).Source)
+		assert.Equal(t, 1, storage.Stats().OutOfOrderActions)
+		assert.Equal(t, uint64(1), storage.Version())
+		assert.ErrorIs(t, storage.Persist(), errNotPersistable)
+	})
+
+	t.Run("Malformed actions", func(t *testing.T) {
+		t.Parallel() // Enable parallel execution
+
+		_, err := NewInMemoryStorageFromReaders(strings.NewReader(users), strings.NewReader("[\n{\"id\": 1,,}\n]"))
+		assert.ErrorContains(t, err, "failed to load actions: actions:2:")
+	})
+
+	t.Run("Strict decoding", func(t *testing.T) {
+		t.Parallel() // Enable parallel execution
+
+		_, err := NewInMemoryStorageFromReaders(strings.NewReader(`[{"id": 1, "email": "tom@example.com"}]`), strings.NewReader(actions), WithStrictDecoding())
+		assert.ErrorContains(t, err, "failed to load users: users:")
+		assert.ErrorContains(t, err, "unknown field")
+	})
+
+	t.Run("Unknown action types", func(t *testing.T) {
+		t.Parallel() // Enable parallel execution
+
+		// Unknown types are only warned about, and kept as they are.
+		storage, err := NewInMemoryStorageFromReaders(strings.NewReader(users), strings.NewReader(`[
+			{"id": 1, "type": "WELCM", "userId": 1, "createdAt": "2021-07-04T12:00:00Z"}
+		]`))
+		assert.NoError(t, err)
+		assert.Equal(t, types.ActionType("WELCM"), storage.GetAction(1).Type)
+	})
+
+	t.Run("Failing reader", func(t *testing.T) {
+		t.Parallel() // Enable parallel execution
+
+		_, err := NewInMemoryStorageFromReaders(iotest.ErrReader(errors.New("connection reset")), strings.NewReader(actions))
+		assert.ErrorContains(t, err, "connection reset")
+	})
+}
+
+func TestCountUnknownTypes(t *testing.T) {
+	actions := []types.Action{
+		{ID: 1, Type: types.ActionWelcome},
+		{ID: 2, Type: "WELCM"},
+		{ID: 3, Type: "SIGN_OUT"},
+		{ID: 4, Type: "WELCM"},
+		{ID: 5, Type: types.ActionReferUser},
+	}
+
+	assert.Equal(t, []types.ActionTypeCount{
+		{Type: "SIGN_OUT", Count: 1},
+		{Type: "WELCM", Count: 2},
+	}, countUnknownTypes(actions))
+	assert.Empty(t, countUnknownTypes(actions[:1]))
+}
+
+func TestLoadActionsErrorLine(t *testing.T) {
+	tests := []struct {
+		name        string
+		inputFile   string
+		content     string
+		expectedErr string
+	}{
+		{
+			name:      "Syntax error",
+			inputFile: "syntax_error_actions.json",
+			content: `[
+	{"id": 1, "type": "WELCOME", "userId": 1},
+	{"id": 2, "type": "WELCOME", "userId": 2},
+	{"id": 3, "type": "WELCOME" "userId": 3}
+]`,
+			expectedErr: "syntax_error_actions.json:4: invalid character",
+		},
+		{
+			name:      "Type error",
+			inputFile: "type_error_actions.json",
+			content: `[
+	{"id": 1, "type": "WELCOME", "userId": 1},
+	{"id": 2, "type": "WELCOME", "userId": "two"}
+]`,
+			expectedErr: "type_error_actions.json:3: json: cannot unmarshal string",
+		},
+		{
+			name:        "Truncated file",
+			inputFile:   "truncated_actions.json",
+			content:     "[\n\t{\"id\": 1",
+			expectedErr: "truncated_actions.json: unexpected EOF",
+		},
+		{
+			name:      "Trailing array",
+			inputFile: "trailing_array_actions.json",
+			content: `[
+	{"id": 1, "type": "WELCOME", "userId": 1}
+]
+[
+	{"id": 2, "type": "WELCOME", "userId": 2}
+]`,
+			expectedErr: "trailing_array_actions.json:4: unexpected data after the top-level value",
+		},
+		{
+			name:        "Trailing garbage",
+			inputFile:   "trailing_garbage_actions.json",
+			content:     "[\n\t{\"id\": 1, \"type\": \"WELCOME\", \"userId\": 1}\n] garbage\n",
+			expectedErr: "trailing_garbage_actions.json:3: unexpected data after the top-level value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			if err := os.WriteFile(tt.inputFile, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write mock file: %v", err)
+			}
+			defer os.Remove(tt.inputFile)
+
+			storage := &InMemoryStorage{}
+			assert.ErrorContains(t, storage.loadActions(tt.inputFile), tt.expectedErr)
+		})
+	}
+}
+
+func TestLoadActionsMetadata(t *testing.T) {
+	content := `[
+		{"id": 1, "type": "WELCOME", "userId": 1, "targetUser": 0, "createdAt": "2021-07-04T12:47:09.888Z", "metadata": {"campaign": "spring", "tags": ["a", "b"], "score": 1.5}},
+		{"id": 2, "type": "ADD_CONTACT", "userId": 1, "targetUser": 0, "createdAt": "2021-07-04T13:47:09.888Z", "metadata": "plain string"},
+		{"id": 3, "type": "EDIT_CONTACT", "userId": 1, "targetUser": 0, "createdAt": "2021-07-04T14:47:09.888Z", "metadata": [1, null, {"nested": true}]},
+		{"id": 4, "type": "VIEW_CONTACTS", "userId": 1, "targetUser": 0, "createdAt": "2021-07-04T15:47:09.888Z"}
+	]`
+	expected := `[
+		{"id": 1, "type": "WELCOME", "userId": 1, "targetUser": 0, "createdAt": "2021-07-04T12:47:09.888Z", "metadata": {"campaign": "spring", "tags": ["a", "b"], "score": 1.5}, "source": "file"},
+		{"id": 2, "type": "ADD_CONTACT", "userId": 1, "targetUser": 0, "createdAt": "2021-07-04T13:47:09.888Z", "metadata": "plain string", "source": "file"},
+		{"id": 3, "type": "EDIT_CONTACT", "userId": 1, "targetUser": 0, "createdAt": "2021-07-04T14:47:09.888Z", "metadata": [1, null, {"nested": true}], "source": "file"},
+		{"id": 4, "type": "VIEW_CONTACTS", "userId": 1, "targetUser": 0, "createdAt": "2021-07-04T15:47:09.888Z", "source": "file"}
+	]`
+
+	inputFile := "metadata_actions.json"
+	if err := os.WriteFile(inputFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write mock file: %v", err)
+	}
+	defer os.Remove(inputFile)
+
+	storage := &InMemoryStorage{}
+	assert.NoError(t, storage.loadActions(inputFile))
+
+	// Metadata is returned unchanged and omitted when absent.
+	output, err := json.Marshal(storage.GetActions())
+	if err != nil {
+		t.Fatalf("Failed to marshal actions: %v", err)
+	}
+	assert.JSONEq(t, expected, string(output))
+	assert.Nil(t, storage.actions[3].Metadata)
+}
+
+func TestGetAction(t *testing.T) {
+	storage := &InMemoryStorage{
+		actions: []types.Action{
+			{ID: 1, UserID: 1, Type: "WELCOME"},
+			{ID: 2, UserID: 1, Type: "CONNECT_CRM"},
+		},
+		mu: sync.RWMutex{},
+	}
+	storage.warmup()
+
+	assert.Equal(t, &types.Action{ID: 2, UserID: 1, Type: "CONNECT_CRM"}, storage.GetAction(2))
+	assert.Nil(t, storage.GetAction(3))
+
+	// The returned action is a copy.
+	storage.GetAction(1).Type = "CHANGED"
+	assert.Equal(t, types.ActionWelcome, storage.actions[0].Type)
+}
+
+func TestLoadActionsSource(t *testing.T) {
+	content := `[
+		{"id": 1, "type": "WELCOME", "userId": 1, "createdAt": "2021-07-04T12:47:09.888Z"},
+		{"id": 2, "type": "ADD_CONTACT", "userId": 1, "createdAt": "2021-07-04T13:47:09.888Z", "source": "kafka"}
+	]`
+
+	inputFile := "source_actions.json"
+	if err := os.WriteFile(inputFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write mock file: %v", err)
+	}
+	defer os.Remove(inputFile)
+
+	storage := &InMemoryStorage{}
+	assert.NoError(t, storage.loadActions(inputFile))
+
+	// Untagged actions default to the file source, explicit sources are kept.
+	assert.Equal(t, types.SourceFile, storage.actions[0].Source)
+	assert.Equal(t, "kafka", storage.actions[1].Source)
+}
+
+func TestUserIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		users    map[int]types.User
+		expected []int
+	}{
+		{
+			name: "Sorted and complete",
+			users: map[int]types.User{
+				7: {ID: 7, Name: "Grace"},
+				1: {ID: 1, Name: "Alice"},
+				4: {ID: 4, Name: "Dave"},
+				2: {ID: 2, Name: "Bob"},
+			},
+			expected: []int{1, 2, 4, 7},
+		},
+		{
+			name:     "No users",
+			users:    map[int]types.User{},
+			expected: []int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			storage := &InMemoryStorage{users: tt.users}
+
+			assert.Equal(t, tt.expected, storage.UserIDs())
+		})
+	}
+}
+
+func TestGetUserActions(t *testing.T) {
+	storage := &InMemoryStorage{
+		actions: []types.Action{
+			{ID: 1, UserID: 1, Type: types.ActionWelcome},
+			{ID: 2, UserID: 1, Type: types.ActionConnectCRM},
+			{ID: 3, UserID: 3, Type: types.ActionWelcome},
+		},
+	}
+	storage.warmup()
+
+	assert.Equal(t, []int{1, 3}, storage.ActiveUserIDs())
+	assert.Equal(t, []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionWelcome},
+		{ID: 2, UserID: 1, Type: types.ActionConnectCRM},
+	}, storage.GetUserActions(1))
+	assert.Equal(t, []types.Action{}, storage.GetUserActions(2))
+
+	// The returned actions are a copy.
+	storage.GetUserActions(3)[0].Type = "CHANGED"
+	assert.Equal(t, types.ActionWelcome, storage.actions[2].Type)
+}
+
+func TestLoadTimeout(t *testing.T) {
+	const users = `[{"id": 1, "name": "Alice", "createdAt": "2021-07-04T12:47:09.888Z"}]`
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(users))
+	}))
+	t.Cleanup(fast.Close)
+
+	tests := []struct {
+		name        string
+		source      string
+		timeout     time.Duration
+		expectedErr string
+	}{
+		{name: "Slow source exceeds timeout", source: slow.URL, timeout: 50 * time.Millisecond, expectedErr: "timed out after 50ms"},
+		{name: "Fast source within timeout", source: fast.URL, timeout: time.Second},
+		{name: "Local file is unaffected", source: "../users.json", timeout: time.Nanosecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			storage := &InMemoryStorage{users: make(map[int]types.User)}
+			WithLoadTimeout(tt.timeout)(storage)
+
+			start := time.Now()
+			err := storage.loadUsers(tt.source)
+
+			if tt.expectedErr != "" {
+				assert.ErrorContains(t, err, tt.expectedErr)
+				assert.Less(t, time.Since(start), 5*time.Second)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotEmpty(t, storage.users)
+		})
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	createdAt := time.Date(2021, time.July, 4, 12, 47, 9, 0, time.UTC)
+	storage := &InMemoryStorage{
+		users:   map[int]types.User{1: {ID: 1, Name: "Alice", CreatedAt: createdAt}},
+		version: 1,
+	}
+
+	name := "Alicia"
+	user, err := storage.UpdateUser(1, types.UserPatch{Name: &name})
+	assert.NoError(t, err)
+	assert.Equal(t, &types.User{ID: 1, Name: "Alicia", CreatedAt: createdAt}, user)
+	assert.Equal(t, "Alicia", storage.users[1].Name)
+	assert.Equal(t, uint64(2), storage.Version())
+
+	// An empty patch leaves the user intact.
+	user, err = storage.UpdateUser(1, types.UserPatch{})
+	assert.NoError(t, err)
+	assert.Equal(t, &types.User{ID: 1, Name: "Alicia", CreatedAt: createdAt}, user)
+
+	user, err = storage.UpdateUser(2, types.UserPatch{Name: &name})
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	assert.Nil(t, user)
+	assert.Equal(t, uint64(3), storage.Version())
+}
+
+func TestInsertPosition(t *testing.T) {
+	base := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	storage := &InMemoryStorage{
+		actions: []types.Action{
+			{ID: 1, UserID: 1, CreatedAt: base},
+			{ID: 2, UserID: 1, CreatedAt: base.Add(2 * time.Hour)},
+			{ID: 3, UserID: 3, CreatedAt: base},
+			{ID: 4, UserID: 3, CreatedAt: base.Add(time.Hour)},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		userID    int
+		createdAt time.Time
+		expected  int
+	}{
+		{"Before every action", 0, base, 0},
+		{"Between a user's actions", 1, base.Add(time.Hour), 1},
+		{"Same timestamp goes after", 1, base, 1},
+		{"After a user's last action", 1, base.Add(3 * time.Hour), 2},
+		{"User without actions", 2, base, 2},
+		{"Before a user's first action", 3, base.Add(-time.Hour), 2},
+		{"After every action", 4, base, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			assert.Equal(t, tt.expected, storage.InsertPosition(tt.userID, tt.createdAt))
+		})
+	}
+
+	// Nothing was inserted.
+	assert.Len(t, storage.actions, 4)
+}
+
+func TestResort(t *testing.T) {
+	base := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	target := 2
+	sorted := []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 2, UserID: 1, Type: types.ActionAddContact, CreatedAt: base.Add(time.Hour)},
+		{ID: 3, UserID: 2, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 4, UserID: 3, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 5, UserID: 3, Type: types.ActionReferUser, CreatedAt: base.Add(time.Hour), TargetUser: &target},
+	}
+
+	storage := &InMemoryStorage{actions: append([]types.Action(nil), sorted...)}
+	storage.warmup()
+
+	// Already sorted, nothing moves.
+	moved, err := storage.Resort()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, moved)
+	assert.Equal(t, sorted, storage.actions)
+	assert.Equal(t, uint64(0), storage.Version())
+
+	// Shuffle the internal slice behind the storage's back, leaving one action in place.
+	storage.actions = []types.Action{sorted[4], sorted[1], sorted[3], sorted[2], sorted[0]}
+
+	moved, err = storage.Resort()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, moved)
+	assert.Equal(t, sorted, storage.actions)
+	assert.Equal(t, uint64(1), storage.Version())
+
+	// The indices point at the new positions.
+	assert.Equal(t, &sorted[1], storage.GetAction(2))
+	assert.Equal(t, sorted[3:], storage.GetUserActions(3))
+}
+
+func TestCreateAction(t *testing.T) {
+	base := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	users := map[int]types.User{
+		1: {ID: 1, Name: "Tom", CreatedAt: base},
+		2: {ID: 2, Name: "Alice", CreatedAt: base},
+		3: {ID: 3, Name: "Bob", CreatedAt: base},
+	}
+	actions := []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 2, UserID: 1, Type: types.ActionAddContact, CreatedAt: base.Add(2 * time.Hour)},
+		{ID: 7, UserID: 3, Type: types.ActionWelcome, CreatedAt: base},
+	}
+
+	tests := []struct {
+		name             string
+		action           types.Action
+		expectedPosition int
+	}{
+		{
+			name:             "Between a user's actions",
+			action:           types.Action{UserID: 1, Type: types.ActionConnectCRM, CreatedAt: base.Add(time.Hour)},
+			expectedPosition: 1,
+		},
+		{
+			name:             "After a user's last action",
+			action:           types.Action{UserID: 3, Type: types.ActionAddContact, CreatedAt: base.Add(time.Hour)},
+			expectedPosition: 3,
+		},
+		{
+			name:             "User without actions",
+			action:           types.Action{UserID: 2, Type: types.ActionWelcome, CreatedAt: base},
+			expectedPosition: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			storage := NewInMemoryStorageFromData(users, actions)
+
+			created, err := storage.CreateAction(tt.action)
+			assert.NoError(t, err)
+
+			// IDs continue after the highest existing one.
+			expected := tt.action
+			expected.ID = 8
+			assert.Equal(t, &expected, created)
+
+			stored := storage.GetActions()
+			assert.Len(t, stored, 4)
+			assert.Equal(t, expected, stored[tt.expectedPosition])
+			assert.Zero(t, countOutOfOrder(stored))
+			assert.Equal(t, uint64(2), storage.Version())
+
+			// The indices point at the shifted positions.
+			assert.Equal(t, &expected, storage.GetAction(8))
+			assert.Equal(t, &actions[2], storage.GetAction(7))
+			userActions := storage.GetUserActions(tt.action.UserID)
+			assert.Contains(t, userActions, expected)
+			assert.Equal(t, len(userActions), storage.CountActionsByUserID(tt.action.UserID))
+
+			// The next action gets the next ID.
+			next, err := storage.CreateAction(tt.action)
+			assert.NoError(t, err)
+			assert.Equal(t, 9, next.ID)
+		})
+	}
+}
+
+func TestDeleteAction(t *testing.T) {
+	base := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	actions := []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 2, UserID: 1, Type: types.ActionAddContact, CreatedAt: base.Add(time.Hour)},
+		{ID: 3, UserID: 2, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 4, UserID: 2, Type: types.ActionAddContact, CreatedAt: base.Add(time.Hour)},
+		{ID: 5, UserID: 3, Type: types.ActionWelcome, CreatedAt: base},
+	}
+
+	tests := []struct {
+		name        string
+		id          int
+		expectedIDs []int
+		userID      int
+		actionType  types.ActionType
+	}{
+		{name: "First action", id: 1, expectedIDs: []int{2, 3, 4, 5}, userID: 1, actionType: types.ActionWelcome},
+		{name: "Middle action", id: 3, expectedIDs: []int{1, 2, 4, 5}, userID: 2, actionType: types.ActionWelcome},
+		{name: "Last action", id: 5, expectedIDs: []int{1, 2, 3, 4}, userID: 3, actionType: types.ActionWelcome},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			storage := NewInMemoryStorageFromData(nil, actions)
+			userCount := storage.CountActionsByUserID(tt.userID)
+			typeCount := storage.CountActionsByType(tt.actionType)
+
+			assert.NoError(t, storage.DeleteAction(tt.id))
+
+			ids := []int{}
+			for _, action := range storage.GetActions() {
+				ids = append(ids, action.ID)
+			}
+			assert.Equal(t, tt.expectedIDs, ids)
+			assert.Nil(t, storage.GetAction(tt.id))
+			assert.Equal(t, userCount-1, storage.CountActionsByUserID(tt.userID))
+			assert.Equal(t, typeCount-1, storage.CountActionsByType(tt.actionType))
+			assert.Equal(t, uint64(2), storage.Version())
+			assert.True(t, storage.CheckConsistency().Consistent)
+
+			// The indices point at the shifted positions.
+			for _, id := range tt.expectedIDs {
+				assert.Equal(t, id, storage.GetAction(id).ID)
+			}
+
+			// The ID is not reused, even when the highest one was deleted.
+			created, err := storage.CreateAction(types.Action{UserID: 1, Type: types.ActionWelcome, CreatedAt: base})
+			assert.NoError(t, err)
+			assert.Equal(t, 6, created.ID)
+		})
+	}
+
+	t.Run("Unknown action", func(t *testing.T) {
+		t.Parallel() // Enable parallel execution
+
+		storage := NewInMemoryStorageFromData(nil, actions)
+
+		assert.ErrorIs(t, storage.DeleteAction(42), ErrActionNotFound)
+		assert.Len(t, storage.GetActions(), len(actions))
+		assert.Equal(t, uint64(1), storage.Version())
+	})
+}
+
+func TestActionsByTime(t *testing.T) {
+	base := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	storage := NewInMemoryStorageFromData(nil, []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: base.Add(2 * time.Hour)},
+		{ID: 2, UserID: 1, Type: types.ActionAddContact, CreatedAt: base.Add(4 * time.Hour)},
+		{ID: 3, UserID: 2, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 4, UserID: 3, Type: types.ActionWelcome, CreatedAt: base.Add(2 * time.Hour)},
+	})
+
+	ids := func(actions []types.Action) []int {
+		ids := []int{}
+		for _, action := range actions {
+			ids = append(ids, action.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []int{3, 1, 4, 2}, ids(storage.ActionsByTime(0, 10)))
+	assert.Equal(t, []int{1, 4}, ids(storage.ActionsByTime(1, 2)))
+	assert.Empty(t, storage.ActionsByTime(4, 10))
+
+	// Created actions take their place in time order.
+	_, err := storage.CreateAction(types.Action{UserID: 2, Type: types.ActionAddContact, CreatedAt: base.Add(3 * time.Hour)})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 1, 4, 5, 2}, ids(storage.ActionsByTime(0, 10)))
+}
+
+func TestActionsByTimeAfter(t *testing.T) {
+	base := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	storage := NewInMemoryStorageFromData(nil, []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: base.Add(2 * time.Hour)},
+		{ID: 2, UserID: 1, Type: types.ActionAddContact, CreatedAt: base.Add(4 * time.Hour)},
+		{ID: 3, UserID: 2, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 4, UserID: 3, Type: types.ActionWelcome, CreatedAt: base.Add(2 * time.Hour)},
+	})
+
+	ids := func(actions []types.Action) []int {
+		ids := []int{}
+		for _, action := range actions {
+			ids = append(ids, action.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name        string
+		createdAt   time.Time
+		id          int
+		to          time.Time
+		limit       int
+		expectedIDs []int
+	}{
+		{name: "From the start", createdAt: base, id: math.MinInt, limit: 10, expectedIDs: []int{3, 1, 4, 2}},
+		{name: "After an action", createdAt: base.Add(2 * time.Hour), id: 1, limit: 10, expectedIDs: []int{4, 2}},
+		{name: "Between actions", createdAt: base.Add(time.Hour), id: math.MinInt, limit: 10, expectedIDs: []int{1, 4, 2}},
+		{name: "Limited", createdAt: base, id: math.MinInt, limit: 2, expectedIDs: []int{3, 1}},
+		{name: "Before to", createdAt: base, id: 3, to: base.Add(4 * time.Hour), limit: 10, expectedIDs: []int{1, 4}},
+		{name: "After the last action", createdAt: base.Add(4 * time.Hour), id: 2, limit: 10, expectedIDs: []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			assert.Equal(t, tt.expectedIDs, ids(storage.ActionsByTimeAfter(tt.createdAt, tt.id, tt.to, tt.limit)))
+		})
+	}
+}
+
+// TestActionsByTimeAfterPaging checks that paging from the last action returned
+// neither repeats nor skips actions when others are created or deleted in between.
+func TestActionsByTimeAfterPaging(t *testing.T) {
+	base := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	storage := NewInMemoryStorageFromData(nil, []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 2, UserID: 1, Type: types.ActionAddContact, CreatedAt: base.Add(time.Hour)},
+		{ID: 3, UserID: 1, Type: types.ActionAddContact, CreatedAt: base.Add(2 * time.Hour)},
+		{ID: 4, UserID: 1, Type: types.ActionAddContact, CreatedAt: base.Add(3 * time.Hour)},
+	})
+
+	page := storage.ActionsByTimeAfter(base, math.MinInt, time.Time{}, 2)
+	assert.Len(t, page, 2)
+
+	// An action created before the cursor and a deleted one would shift an offset.
+	_, err := storage.CreateAction(types.Action{UserID: 1, Type: types.ActionWelcome, CreatedAt: base.Add(-time.Hour)})
+	assert.NoError(t, err)
+	assert.NoError(t, storage.DeleteAction(1))
+
+	last := page[len(page)-1]
+	page = storage.ActionsByTimeAfter(last.CreatedAt, last.ID, time.Time{}, 2)
+	var ids []int
+	for _, action := range page {
+		ids = append(ids, action.ID)
+	}
+	assert.Equal(t, []int{3, 4}, ids)
+}
+
+func TestGetActionsBetween(t *testing.T) {
+	base := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	storage := NewInMemoryStorageFromData(nil, []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: base.Add(2 * time.Hour)},
+		{ID: 2, UserID: 1, Type: types.ActionAddContact, CreatedAt: base.Add(4 * time.Hour)},
+		{ID: 3, UserID: 2, Type: types.ActionWelcome, CreatedAt: base},
+		{ID: 4, UserID: 3, Type: types.ActionWelcome, CreatedAt: base.Add(2 * time.Hour)},
+	})
+
+	tests := []struct {
+		name        string
+		from        time.Time
+		to          time.Time
+		expectedIDs []int
+	}{
+		{
+			name:        "Unbounded",
+			expectedIDs: []int{3, 1, 4, 2},
+		},
+		{
+			// from is inclusive, so actions created exactly at it are kept.
+			name:        "From only",
+			from:        base.Add(2 * time.Hour),
+			expectedIDs: []int{1, 4, 2},
+		},
+		{
+			// to is exclusive, so actions created exactly at it are dropped.
+			name:        "To only",
+			to:          base.Add(2 * time.Hour),
+			expectedIDs: []int{3},
+		},
+		{
+			name:        "Both ends",
+			from:        base,
+			to:          base.Add(4 * time.Hour),
+			expectedIDs: []int{3, 1, 4},
+		},
+		{
+			name:        "Between actions",
+			from:        base.Add(time.Hour),
+			to:          base.Add(3 * time.Hour),
+			expectedIDs: []int{1, 4},
+		},
+		{
+			name:        "Empty range",
+			from:        base.Add(2 * time.Hour),
+			to:          base.Add(2 * time.Hour),
+			expectedIDs: []int{},
+		},
+		{
+			name:        "Inverted range",
+			from:        base.Add(4 * time.Hour),
+			to:          base,
+			expectedIDs: []int{},
+		},
+		{
+			name:        "After all actions",
+			from:        base.Add(5 * time.Hour),
+			expectedIDs: []int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			ids := []int{}
+			for _, action := range storage.GetActionsBetween(tt.from, tt.to) {
+				ids = append(ids, action.ID)
+			}
+			assert.Equal(t, tt.expectedIDs, ids)
+		})
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	createdAt := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	storage := NewInMemoryStorageFromData(map[int]types.User{
+		1: {ID: 1, Name: "Tom", CreatedAt: createdAt},
+		5: {ID: 5, Name: "Alice", CreatedAt: createdAt},
+	}, nil)
+
+	// Without an ID, users are numbered after the highest stored ID.
+	user, err := storage.CreateUser(types.User{Name: "Bob", CreatedAt: createdAt})
+	assert.NoError(t, err)
+	assert.Equal(t, &types.User{ID: 6, Name: "Bob", CreatedAt: createdAt}, user)
+
+	user, err = storage.CreateUser(types.User{Name: "Carol", CreatedAt: createdAt})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, user.ID)
+
+	// An explicit free ID is kept, even below the highest one.
+	user, err = storage.CreateUser(types.User{ID: 3, Name: "Dave", CreatedAt: createdAt})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, user.ID)
+	assert.Equal(t, &types.User{ID: 3, Name: "Dave", CreatedAt: createdAt}, storage.GetUser(3))
+
+	// A taken ID is rejected and leaves the existing user untouched.
+	user, err = storage.CreateUser(types.User{ID: 5, Name: "Eve", CreatedAt: createdAt})
+	assert.ErrorIs(t, err, ErrUserExists)
+	assert.Nil(t, user)
+	assert.Equal(t, "Alice", storage.GetUser(5).Name)
+
+	assert.Equal(t, []int{1, 3, 5, 6, 7}, storage.UserIDs())
+	assert.Equal(t, uint64(4), storage.Version())
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"log"
+
+	"github.com/klemis/user-actions-api/types"
+)
+
+// actionTypeCap limits the number of distinct action types, so dirty data where every
+// typo becomes a new type cannot blow up the transition statistics. It is not safe for
+// concurrent use; the storage guards it with its lock.
+type actionTypeCap struct {
+	max int
+	// registered holds the types admitted so far. Loaded actions are admitted after
+	// sorting, by user and then createdAt, and created ones as they arrive, so the cap
+	// keeps the types that come first in that order.
+	registered map[types.ActionType]bool
+	// bucketed holds the types stored as OTHER, so each is only warned about once.
+	bucketed map[types.ActionType]bool
+	// originals maps the IDs of actions stored as OTHER to their original type, which
+	// Persist writes back instead of OTHER.
+	originals map[int]types.ActionType
+}
+
+func newActionTypeCap(max int) *actionTypeCap {
+	return &actionTypeCap{
+		max:        max,
+		registered: make(map[types.ActionType]bool),
+		bucketed:   make(map[types.ActionType]bool),
+		originals:  make(map[int]types.ActionType),
+	}
+}
+
+// admit returns the type to store an action of the given type as: the type itself while
+// it is registered or the cap has room for it, and ActionOther otherwise. OTHER itself
+// does not count towards the cap.
+func (c *actionTypeCap) admit(actionType types.ActionType) types.ActionType {
+	if actionType == types.ActionOther || c.registered[actionType] {
+		return actionType
+	}
+	if len(c.registered) < c.max {
+		c.registered[actionType] = true
+		return actionType
+	}
+
+	if !c.bucketed[actionType] {
+		c.bucketed[actionType] = true
+		log.Printf("WARNING: action type %q exceeds the cap of %d distinct types, storing it as %s", actionType, c.max, types.ActionOther)
+	}
+	return types.ActionOther
+}
+
+// WithMaxActionTypes caps the number of distinct action types. Once the cap is reached,
+// actions of further types, loaded or created, are stored as OTHER and a warning is
+// logged for each such type.
+func WithMaxActionTypes(max int) Option {
+	return func(s *InMemoryStorage) {
+		s.typeCap = newActionTypeCap(max)
+	}
+}
+
+// capActionType replaces the type of the action with OTHER if it exceeds the cap,
+// remembering the original type. The caller must hold the write lock, or have
+// exclusive access during construction.
+func (s *InMemoryStorage) capActionType(action *types.Action) {
+	if s.typeCap == nil {
+		return
+	}
+
+	if capped := s.typeCap.admit(action.Type); capped != action.Type {
+		s.typeCap.originals[action.ID] = action.Type
+		action.Type = capped
+	}
+}
+
+// uncappedActions returns the actions with their original types, as they were before
+// capping. The caller must hold the lock.
+func (s *InMemoryStorage) uncappedActions() []types.Action {
+	if s.typeCap == nil || len(s.typeCap.originals) == 0 {
+		return s.actions
+	}
+
+	actions := make([]types.Action, len(s.actions))
+	for i, action := range s.actions {
+		if original, capped := s.typeCap.originals[action.ID]; capped {
+			action.Type = original
+		}
+		actions[i] = action
+	}
+
+	return actions
+}
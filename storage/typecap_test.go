@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/klemis/user-actions-api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxActionTypes(t *testing.T) {
+	createdAt := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	storage := NewInMemoryStorageFromData(map[int]types.User{
+		1: {ID: 1, Name: "Tom", CreatedAt: createdAt},
+	}, []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: createdAt},
+		{ID: 2, UserID: 1, Type: types.ActionConnectCRM, CreatedAt: createdAt.Add(time.Minute)},
+		{ID: 3, UserID: 1, Type: "ADD_CONTCAT", CreatedAt: createdAt.Add(2 * time.Minute)},
+		{ID: 4, UserID: 1, Type: types.ActionWelcome, CreatedAt: createdAt.Add(3 * time.Minute)},
+	}, WithMaxActionTypes(2))
+
+	// Loaded actions beyond the cap are bucketed, registered types are kept.
+	actions := storage.GetActions()
+	assert.Equal(t, []types.ActionType{
+		types.ActionWelcome, types.ActionConnectCRM, types.ActionOther, types.ActionWelcome,
+	}, []types.ActionType{actions[0].Type, actions[1].Type, actions[2].Type, actions[3].Type})
+
+	// Created actions are bucketed the same way.
+	action, err := storage.CreateAction(types.Action{UserID: 1, Type: types.ActionReferUser, CreatedAt: createdAt.Add(4 * time.Minute)})
+	assert.NoError(t, err)
+	assert.Equal(t, types.ActionOther, action.Type)
+
+	action, err = storage.CreateAction(types.Action{UserID: 1, Type: types.ActionConnectCRM, CreatedAt: createdAt.Add(5 * time.Minute)})
+	assert.NoError(t, err)
+	assert.Equal(t, types.ActionConnectCRM, action.Type)
+
+	// OTHER itself does not count towards the cap.
+	action, err = storage.CreateAction(types.Action{UserID: 1, Type: types.ActionOther, CreatedAt: createdAt.Add(6 * time.Minute)})
+	assert.NoError(t, err)
+	assert.Equal(t, types.ActionOther, action.Type)
+}
+
+func TestNoMaxActionTypes(t *testing.T) {
+	createdAt := time.Date(2021, time.July, 4, 12, 0, 0, 0, time.UTC)
+	storage := NewInMemoryStorageFromData(nil, []types.Action{
+		{ID: 1, UserID: 1, Type: types.ActionWelcome, CreatedAt: createdAt},
+		{ID: 2, UserID: 1, Type: "ADD_CONTCAT", CreatedAt: createdAt.Add(time.Minute)},
+	})
+
+	actions := storage.GetActions()
+	assert.Equal(t, types.ActionType("ADD_CONTCAT"), actions[1].Type)
+}
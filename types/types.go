@@ -1,6 +1,10 @@
 package types
 
-import "time"
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
 
 type User struct {
 	ID        int       `json:"id"`
@@ -8,19 +12,444 @@ type User struct {
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+// CreateUserRequest is the body of a request creating a user. Without an ID the next
+// free one is assigned.
+type CreateUserRequest struct {
+	ID   *int   `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserPatch is a partial update of a user. Fields left nil are not changed.
+type UserPatch struct {
+	Name *string `json:"name"`
+}
+
+// ActionType identifies the kind of an action. The data may contain types beyond
+// the well-known ones below, which are kept as-is.
+type ActionType string
+
+// Well-known action types.
+const (
+	ActionWelcome      ActionType = "WELCOME"
+	ActionConnectCRM   ActionType = "CONNECT_CRM"
+	ActionAddContact   ActionType = "ADD_CONTACT"
+	ActionEditContact  ActionType = "EDIT_CONTACT"
+	ActionViewContacts ActionType = "VIEW_CONTACTS"
+	ActionReferUser    ActionType = "REFER_USER"
+)
+
+// ActionOther is the type actions are stored as when their own type exceeds the
+// configured cap on distinct action types.
+const ActionOther ActionType = "OTHER"
+
+// KnownActionTypes lists the well-known action types.
+var KnownActionTypes = []ActionType{
+	ActionWelcome,
+	ActionConnectCRM,
+	ActionAddContact,
+	ActionEditContact,
+	ActionViewContacts,
+	ActionReferUser,
+}
+
+// Valid reports whether t is one of the well-known action types.
+func (t ActionType) Valid() bool {
+	return slices.Contains(KnownActionTypes, t)
+}
+
 type Action struct {
-	ID         int       `json:"id"`
-	Type       string    `json:"type"` // use type
-	UserID     int       `json:"userId"`
-	TargetUser int       `json:"targetUser"`
+	ID     int        `json:"id"`
+	Type   ActionType `json:"type"`
+	UserID int        `json:"userId"`
+	// TargetUser is the user the action is aimed at, e.g. the referred user of
+	// REFER_USER. It is nil when the action has no target.
+	TargetUser *int      `json:"targetUser,omitempty"`
 	CreatedAt  time.Time `json:"createdAt"`
+	// Metadata is free-form client context, stored and returned unchanged.
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	// Source identifies the ingestion path the action arrived through.
+	Source string `json:"source,omitempty"`
+}
+
+// ReferralTarget returns the user referred by a REFER_USER action, reporting false for
+// other actions and for referrals without a target. Older data marks a missing target
+// with 0, so a target of 0 only counts when zeroValid is set.
+func (a Action) ReferralTarget(zeroValid bool) (int, bool) {
+	if a.Type != ActionReferUser || a.TargetUser == nil {
+		return 0, false
+	}
+	if *a.TargetUser == 0 && !zeroValid {
+		return 0, false
+	}
+
+	return *a.TargetUser, true
+}
+
+// StorageEventType identifies the kind of mutation a storage event describes.
+type StorageEventType string
+
+// Storage event types.
+const (
+	EventUserCreated     StorageEventType = "user.created"
+	EventUserUpdated     StorageEventType = "user.updated"
+	EventActionCreated   StorageEventType = "action.created"
+	EventActionDeleted   StorageEventType = "action.deleted"
+	EventActionsResorted StorageEventType = "actions.resorted"
+)
+
+// StorageEvent describes a mutation of the stored data.
+type StorageEvent struct {
+	Type StorageEventType `json:"type"`
+	// Version is the data version the mutation produced.
+	Version uint64 `json:"version"`
+	// User is the created or updated user, for user events.
+	User *User `json:"user,omitempty"`
+	// Action is the created or deleted action, for action events.
+	Action *Action `json:"action,omitempty"`
+}
+
+// IndexedAction is an action annotated with its position in the user's timeline.
+type IndexedAction struct {
+	Action
+	// Position is the 1-based ordinal of the action among the user's actions.
+	Position int `json:"position"`
+}
+
+// Ingestion sources an action can be tagged with.
+const (
+	SourceFile = "file"
+	SourceAPI  = "api"
+	SourceMock = "mock"
+)
+
+// ActionsBatchGetRequest asks for several actions by ID at once.
+type ActionsBatchGetRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// CreateActionRequest is the body of a request creating an action. CreatedAt defaults
+// to the time of the request.
+type CreateActionRequest struct {
+	Type       ActionType      `json:"type"`
+	UserID     int             `json:"userId"`
+	TargetUser *int            `json:"targetUser"`
+	CreatedAt  *time.Time      `json:"createdAt"`
+	Metadata   json.RawMessage `json:"metadata"`
 }
 
 // ActionsProbalibity holds the probability for each possible next action.
-type ActionsProbalibity map[string]float64
+type ActionsProbalibity map[ActionType]float64
+
+// ActionProbability is the probability of a single next action type.
+type ActionProbability struct {
+	Type        ActionType `json:"type"`
+	Probability float64    `json:"probability"`
+}
+
+// DetailedActionProbability is the probability of an action type together with the
+// number of transitions behind it. RawProbability is not rounded.
+type DetailedActionProbability struct {
+	Type           ActionType `json:"type"`
+	Count          int        `json:"count"`
+	Probability    float64    `json:"probability"`
+	RawProbability float64    `json:"rawProbability"`
+}
+
+// ActionDistribution is the next-action distribution of a single action type.
+type ActionDistribution struct {
+	Type          ActionType         `json:"type"`
+	Probabilities ActionsProbalibity `json:"probabilities"`
+}
+
+// TransitionPair identifies an action and the action of the same user directly following it.
+type TransitionPair struct {
+	SourceActionID int `json:"sourceActionId"`
+	NextActionID   int `json:"nextActionId"`
+}
+
+// NextActionExplanation lists the transitions a next-action probability was computed from.
+type NextActionExplanation struct {
+	Count int              `json:"count"`
+	Pairs []TransitionPair `json:"pairs"`
+}
+
+// ExplainedActionsProbability is a next-action distribution together with the
+// transitions behind it, for auditing against the raw data.
+type ExplainedActionsProbability struct {
+	Probabilities ActionsProbalibity                   `json:"probabilities"`
+	Transitions   map[ActionType]NextActionExplanation `json:"transitions"`
+}
+
+// ActionsComparison compares the next-action distributions of two action types.
+type ActionsComparison struct {
+	A                      ActionDistribution `json:"a"`
+	B                      ActionDistribution `json:"b"`
+	TotalVariationDistance *float64           `json:"totalVariationDistance"`
+}
+
+// TransitionEdge is the number of times one action type directly followed another.
+type TransitionEdge struct {
+	From  ActionType `json:"from"`
+	To    ActionType `json:"to"`
+	Count int        `json:"count"`
+}
+
+// TransitionMatrix holds, for each action type, the probability of each action type
+// following it.
+type TransitionMatrix map[ActionType]ActionsProbalibity
+
+// ActionSequence is a sequence of action types performed one after another by a user,
+// with the number of times it occurs across all users.
+type ActionSequence struct {
+	Sequence []ActionType `json:"sequence"`
+	Count    int          `json:"count"`
+}
+
+// FunnelRequest is the body of a request measuring an ordered funnel of action types.
+type FunnelRequest struct {
+	Steps []ActionType `json:"steps"`
+}
+
+// FunnelStep is a step of a funnel with the number of users who performed it and every
+// step before it, in order.
+type FunnelStep struct {
+	Type  ActionType `json:"type"`
+	Users int        `json:"users"`
+	// Conversion is the share of the users at the previous step who reached this one. It
+	// is nil for the first step, and when no user reached the previous step.
+	Conversion *float64 `json:"conversion"`
+}
+
+// Funnel is the progress of users through an ordered funnel of action types.
+type Funnel struct {
+	Steps []FunnelStep `json:"steps"`
+	// Conversion is the share of the users at the first step who reached the last one,
+	// or nil when no user reached the first step.
+	Conversion *float64 `json:"conversion"`
+}
+
+// TypeShareBucket holds the share of each action type within one time bucket.
+type TypeShareBucket struct {
+	Start  time.Time              `json:"start"`
+	Total  int                    `json:"total"`
+	Shares map[ActionType]float64 `json:"shares"`
+}
+
+// TimelineEvent is a single entry of a user timeline.
+type TimelineEvent struct {
+	Type      ActionType `json:"type"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// UserTimeline is the ordered sequence of a user's actions.
+type UserTimeline struct {
+	UserID int             `json:"userId"`
+	Events []TimelineEvent `json:"events"`
+}
 
 // Referral represents mapping of users to the IDs of users they referred.
 type Referral map[int][]int
 
 // ReferralIndex store the referral index for each user.
 type ReferralIndex map[int]int
+
+// ReferralIndexWithCycles is a referral index together with the referrals that lie on
+// a referral cycle, each as a [referrer, referred] pair.
+type ReferralIndexWithCycles struct {
+	Index  ReferralIndex `json:"index"`
+	Cycles [][2]int      `json:"cycles"`
+}
+
+// ReferralFanout maps a number of directly referred users to the number of referrers
+// who referred that many.
+type ReferralFanout map[int]int
+
+// ReferralIndexEntry is a user's referral index together with their name. Name is nil
+// for referrers missing from the users.
+type ReferralIndexEntry struct {
+	Name          *string `json:"name"`
+	ReferralIndex int     `json:"referralIndex"`
+}
+
+// ExpandedReferralIndex is a referral index with the name of each referrer.
+type ExpandedReferralIndex map[int]ReferralIndexEntry
+
+// UserReferralIndex is the referral index of a single user.
+type UserReferralIndex struct {
+	UserID        int `json:"userId"`
+	ReferralIndex int `json:"referralIndex"`
+}
+
+// ReferralTree is a user together with the users they referred, recursively.
+type ReferralTree struct {
+	UserID    int             `json:"userId"`
+	Referrals []*ReferralTree `json:"referrals"`
+}
+
+// ReferralTreesRequest asks for the referral trees of several root users at once.
+type ReferralTreesRequest struct {
+	UserIDs []int `json:"userIds"`
+	// MaxDepth limits how many levels below each root are included. Zero means no limit.
+	MaxDepth int `json:"maxDepth"`
+}
+
+// ReferralEdge is a directed edge of the referral graph: a referral from one user to
+// another at the time of the REFER_USER action.
+type ReferralEdge struct {
+	From int       `json:"from"`
+	To   int       `json:"to"`
+	At   time.Time `json:"at"`
+}
+
+// ReferralDetail describes a single user referred by a referrer.
+type ReferralDetail struct {
+	UserID     int       `json:"userId"`
+	ActionID   int       `json:"actionId"`
+	ReferredAt time.Time `json:"referredAt"`
+	// Active reports whether the referred user performed at least one action.
+	Active bool `json:"active"`
+}
+
+// ReferralConversion describes how many referred users went on to perform an action.
+type ReferralConversion struct {
+	ReferredUsers int     `json:"referredUsers"`
+	ActiveUsers   int     `json:"activeUsers"`
+	Rate          float64 `json:"rate"`
+}
+
+// ActionTypeCount is the number of actions of one type.
+type ActionTypeCount struct {
+	Type  ActionType `json:"type"`
+	Count int        `json:"count"`
+}
+
+// UserProfile is a user together with statistics derived from their actions.
+type UserProfile struct {
+	User        User `json:"user"`
+	ActionCount int  `json:"actionCount"`
+	// FirstActivity and LastActivity are null for users without actions.
+	FirstActivity  *time.Time        `json:"firstActivity"`
+	LastActivity   *time.Time        `json:"lastActivity"`
+	TopActionTypes []ActionTypeCount `json:"topActionTypes"`
+	ReferralIndex  int               `json:"referralIndex"`
+}
+
+// UserVelocity is a user's action rate over a trailing window.
+type UserVelocity struct {
+	UserID     int `json:"userId"`
+	WindowDays int `json:"windowDays"`
+	// WindowEnd is the time of the newest action in the data, which the window ends at.
+	WindowEnd     time.Time `json:"windowEnd"`
+	Actions       int       `json:"actions"`
+	ActionsPerDay float64   `json:"actionsPerDay"`
+}
+
+// UserNextActionProbability is the probability that a user's next action has a given
+// type, based on the global transitions from their last action. LastActionType and
+// Probability are null for users without actions.
+type UserNextActionProbability struct {
+	UserID         int         `json:"userId"`
+	LastActionType *ActionType `json:"lastActionType"`
+	Type           ActionType  `json:"type"`
+	Probability    *float64    `json:"probability"`
+}
+
+// GapStats summarizes the time from actions of one type to the next action of the same
+// user. The statistics are null when there are no samples.
+type GapStats struct {
+	Samples       int      `json:"samples"`
+	MinSeconds    *float64 `json:"minSeconds"`
+	MaxSeconds    *float64 `json:"maxSeconds"`
+	MeanSeconds   *float64 `json:"meanSeconds"`
+	MedianSeconds *float64 `json:"medianSeconds"`
+	P90Seconds    *float64 `json:"p90Seconds"`
+}
+
+// UserCadence is the distribution of the time between a user's consecutive actions,
+// with the statistics also formatted for display. The statistics are nil when the
+// user has fewer than two actions.
+type UserCadence struct {
+	UserID int `json:"userId"`
+	GapStats
+	Formatted FormattedGapStats `json:"formatted"`
+}
+
+// FormattedGapStats holds gap statistics formatted for display, e.g. "1h 30m".
+type FormattedGapStats struct {
+	Min    *string `json:"min"`
+	Max    *string `json:"max"`
+	Mean   *string `json:"mean"`
+	Median *string `json:"median"`
+}
+
+// ActionTypeEntropy is the Shannon entropy, in bits, of the next-action distribution of
+// one action type. Terminal types are never followed by another action and have an
+// entropy of 0.
+type ActionTypeEntropy struct {
+	Type     ActionType `json:"type"`
+	Entropy  float64    `json:"entropy"`
+	Samples  int        `json:"samples"`
+	Terminal bool       `json:"terminal"`
+}
+
+// ErrorCode identifies the kind of an error response, for clients to switch on
+// instead of matching messages.
+type ErrorCode string
+
+// Error codes of error responses.
+const (
+	CodeInvalidUserID         ErrorCode = "INVALID_USER_ID"
+	CodeInvalidActionID       ErrorCode = "INVALID_ACTION_ID"
+	CodeInvalidActionType     ErrorCode = "INVALID_ACTION_TYPE"
+	CodeActionTypeNotAllowed  ErrorCode = "ACTION_TYPE_NOT_ALLOWED"
+	CodeInvalidParameter      ErrorCode = "INVALID_PARAMETER"
+	CodeInvalidRequestBody    ErrorCode = "INVALID_REQUEST_BODY"
+	CodeUserNotFound          ErrorCode = "USER_NOT_FOUND"
+	CodeActionNotFound        ErrorCode = "ACTION_NOT_FOUND"
+	CodeActionTypeNotFound    ErrorCode = "ACTION_TYPE_NOT_FOUND"
+	CodeUserExists            ErrorCode = "USER_EXISTS"
+	CodeNoActions             ErrorCode = "NO_ACTIONS"
+	CodeNoReferrals           ErrorCode = "NO_REFERRALS"
+	CodeReadOnly              ErrorCode = "READ_ONLY"
+	CodeOverloaded            ErrorCode = "OVERLOADED"
+	CodeUnauthorized          ErrorCode = "UNAUTHORIZED"
+	CodeRateLimited           ErrorCode = "RATE_LIMITED"
+	CodeReferralLimitExceeded ErrorCode = "REFERRAL_LIMIT_EXCEEDED"
+	CodeInternal              ErrorCode = "INTERNAL_ERROR"
+)
+
+// APIError is the body of an error response. The message is kept under "error", where
+// it was before codes were added, so existing clients keep working.
+type APIError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"error"`
+}
+
+// Stats summarizes the loaded data and its quality.
+type Stats struct {
+	Users             int `json:"users"`
+	Actions           int `json:"actions"`
+	OutOfOrderActions int `json:"outOfOrderActions"`
+}
+
+// ConsistencyReport is the result of checking the maintained storage indices against
+// indices rebuilt from scratch. Drift describes each mismatch found.
+type ConsistencyReport struct {
+	Consistent bool     `json:"consistent"`
+	Version    uint64   `json:"version"`
+	Drift      []string `json:"drift"`
+}
+
+// NextActionTiming describes how soon a particular next action tends to follow.
+type NextActionTiming struct {
+	Count       int     `json:"count"`
+	Probability float64 `json:"probability"`
+	MeanSeconds float64 `json:"meanSeconds"`
+}
+
+// ExpectedNextAction is the probability-weighted time until the next action.
+type ExpectedNextAction struct {
+	Samples         int                             `json:"samples"`
+	ExpectedSeconds *float64                        `json:"expectedSeconds"`
+	Transitions     map[ActionType]NextActionTiming `json:"transitions"`
+}
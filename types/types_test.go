@@ -0,0 +1,92 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActionTypeWireValues(t *testing.T) {
+	tests := []struct {
+		actionType ActionType
+		expected   string
+	}{
+		{actionType: ActionWelcome, expected: "WELCOME"},
+		{actionType: ActionConnectCRM, expected: "CONNECT_CRM"},
+		{actionType: ActionAddContact, expected: "ADD_CONTACT"},
+		{actionType: ActionEditContact, expected: "EDIT_CONTACT"},
+		{actionType: ActionViewContacts, expected: "VIEW_CONTACTS"},
+		{actionType: ActionReferUser, expected: "REFER_USER"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			assert.Equal(t, tt.expected, string(tt.actionType))
+			assert.Contains(t, KnownActionTypes, tt.actionType)
+		})
+	}
+
+	assert.Len(t, KnownActionTypes, len(tests))
+}
+
+func TestActionTypeValid(t *testing.T) {
+	tests := []struct {
+		actionType ActionType
+		expected   bool
+	}{
+		{actionType: ActionWelcome, expected: true},
+		{actionType: ActionReferUser, expected: true},
+		{actionType: "WELCM", expected: false},
+		{actionType: "welcome", expected: false},
+		{actionType: ActionOther, expected: false},
+		{actionType: "", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.actionType), func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			assert.Equal(t, tt.expected, tt.actionType.Valid())
+		})
+	}
+}
+
+func TestActionTypeAllowsUnknownValues(t *testing.T) {
+	var action Action
+	err := json.Unmarshal([]byte(`{"id": 1, "type": "SOMETHING_NEW", "userId": 1}`), &action)
+
+	assert.NoError(t, err)
+	assert.Equal(t, ActionType("SOMETHING_NEW"), action.Type)
+}
+
+func TestActionReferralTarget(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		zeroValid  bool
+		expectedID int
+		expectedOK bool
+	}{
+		{name: "Target", data: `{"type": "REFER_USER", "targetUser": 2}`, expectedID: 2, expectedOK: true},
+		{name: "Absent target", data: `{"type": "REFER_USER"}`, zeroValid: true, expectedOK: false},
+		{name: "Zero target treated as absent", data: `{"type": "REFER_USER", "targetUser": 0}`, expectedOK: false},
+		{name: "Zero target valid", data: `{"type": "REFER_USER", "targetUser": 0}`, zeroValid: true, expectedID: 0, expectedOK: true},
+		{name: "Not a referral", data: `{"type": "ADD_CONTACT", "targetUser": 2}`, zeroValid: true, expectedOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Enable parallel execution
+
+			var action Action
+			assert.NoError(t, json.Unmarshal([]byte(tt.data), &action))
+
+			id, ok := action.ReferralTarget(tt.zeroValid)
+			assert.Equal(t, tt.expectedOK, ok)
+			assert.Equal(t, tt.expectedID, id)
+		})
+	}
+}